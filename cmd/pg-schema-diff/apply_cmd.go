@@ -2,10 +2,15 @@ package main
 
 import (
 	"context"
+	"database/sql"
 	"errors"
 	"fmt"
+	"os"
+	"os/signal"
 	"sort"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/jackc/pgx/v4"
@@ -14,10 +19,21 @@ import (
 	"github.com/stripe/pg-schema-diff/pkg/log"
 )
 
+// errApplyInterrupted is returned when the migration is stopped because the process received SIGINT or SIGTERM
+var errApplyInterrupted = errors.New("migration interrupted")
+
 func buildApplyCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "apply",
 		Short: "Migrate your database to the match the inputted schema (apply the schema to the database)",
+		Long: "Migrate your database to the match the inputted schema (apply the schema to the database).\n\n" +
+			"With --progress-ledger, the status of each statement is recorded in a progress ledger table, such that a " +
+			"failed or interrupted migration can be continued via --resume. The ledger's schema and table, by default " +
+			diff.DefaultProgressLedgerSchema + "." + diff.DefaultProgressLedgerTable + ", are created in the database " +
+			"if they do not exist.\n\n" +
+			"With --record-history, the applied plan is recorded in a migration history table, which can be read via " +
+			"the history command. The history's schema and table, by default " + diff.DefaultHistorySchema + "." +
+			diff.DefaultHistoryTable + ", are created in the database if they do not exist",
 	}
 
 	connFlags := createConnFlags(cmd)
@@ -26,7 +42,35 @@ func buildApplyCmd() *cobra.Command {
 		"Specify the hazards that are allowed. Order does not matter, and duplicates are ignored. If the"+
 			" migration plan contains unwanted hazards (hazards not in this list), then the migration will fail to run"+
 			" (example: --allowed-hazards DELETES_DATA,INDEX_BUILD)")
-	lockTimeout := cmd.Flags().Duration("lock-timeout", 30*time.Second, "the max time to wait to acquire a lock. 0 implies no timeout")
+	lockTimeout := cmd.Flags().Duration("lock-timeout", 30*time.Second, "the max time to wait to acquire a lock for statements without "+
+		"their own lock timeout. 0 implies no timeout")
+	lockTimeoutRetries := cmd.Flags().Int("lock-timeout-retries", 0, "the max number of times a statement is retried "+
+		"after it fails to acquire a lock within the lock timeout. Pair with a short --lock-timeout on busy databases")
+	lockTimeoutRetryInitialBackoff := cmd.Flags().Duration("lock-timeout-retry-initial-backoff",
+		diff.DefaultLockTimeoutRetryPolicy.InitialBackoff, "the backoff before the first lock timeout retry. The backoff "+
+			"doubles with every retry and is jittered")
+	lockTimeoutRetryMaxBackoff := cmd.Flags().Duration("lock-timeout-retry-max-backoff",
+		diff.DefaultLockTimeoutRetryPolicy.MaxBackoff, "the max backoff between lock timeout retries")
+	dryRun := cmd.Flags().Bool("dry-run", false, "Verify the plan can be applied, i.e., the schema has not changed and "+
+		"the hazards are allowed, without executing any statements")
+	progressLedger := cmd.Flags().Bool("progress-ledger", false, "Record the status of each statement in the progress "+
+		"ledger table, which is created alongside its schema if it does not exist. Required by --resume")
+	progressLedgerFlags := createProgressLedgerFlags(cmd)
+	resume := cmd.Flags().Bool("resume", false, "Resume the most recent migration that did not complete, continuing "+
+		"from the statement that failed. The plan is loaded from the progress ledger rather than generated from the schema "+
+		"dir. Requires --progress-ledger")
+	advisoryLockKey := cmd.Flags().Int64("advisory-lock-key", diff.DefaultAdvisoryLockKey, "the key of the advisory "+
+		"lock held while the migration is applied, such that concurrent applies against the same database are serialized")
+	advisoryLockTimeout := cmd.Flags().Duration("advisory-lock-timeout", diff.DefaultAdvisoryLockWaitTimeout, "the max "+
+		"time to wait for another apply to release the advisory lock. 0 implies no waiting")
+	cleanupInvalidIndexes := cmd.Flags().Bool("cleanup-invalid-indexes", false, "Drop the invalid index left behind "+
+		"when a CREATE INDEX CONCURRENTLY statement fails, e.g., because it timed out, such that the migration can be "+
+		"resumed or re-planned")
+	recordHistory := cmd.Flags().Bool("record-history", false, "Record the applied plan, its outcome and the operator "+
+		"in the migration history table, which is created alongside its schema if it does not exist")
+	historyFlags := createHistoryFlags(cmd)
+	operator := cmd.Flags().String("operator", "", "The operator recorded in the migration history, e.g., your name. "+
+		"Defaults to the database user. Only used with --record-history")
 	cmd.RunE = func(cmd *cobra.Command, args []string) error {
 		connConfig, err := connFlags.parseConnConfig()
 		if err != nil {
@@ -41,15 +85,47 @@ func buildApplyCmd() *cobra.Command {
 		if *lockTimeout < 0 {
 			return errors.New("lock timeout must be >= 0")
 		}
+		if *lockTimeoutRetries < 0 {
+			return errors.New("lock timeout retries must be >= 0")
+		}
+		if *lockTimeoutRetryInitialBackoff < 0 || *lockTimeoutRetryMaxBackoff < 0 {
+			return errors.New("lock timeout retry backoffs must be >= 0")
+		}
+		if *advisoryLockTimeout < 0 {
+			return errors.New("advisory lock timeout must be >= 0")
+		}
+		if *resume && !*progressLedger {
+			return errors.New("resuming requires --progress-ledger")
+		}
+		var progressLedgerOpts []diff.ProgressLedgerOpt
+		if *progressLedger {
+			progressLedgerOpts = progressLedgerFlags.progressLedgerOpts()
+		}
+		var historyOpts []diff.HistoryOpt
+		if *recordHistory {
+			historyOpts = append(historyFlags.historyOpts(), diff.WithHistoryOperator(*operator))
+		}
 
 		cmd.SilenceUsage = true
 
-		plan, err := generatePlan(context.Background(), log.SimpleLogger(), connConfig, planConfig)
-		if err != nil {
-			return err
-		} else if len(plan.Statements) == 0 {
-			fmt.Println("Schema matches expected. No plan generated")
-			return nil
+		var plan diff.Plan
+		if *resume {
+			var found bool
+			plan, found, err = getIncompletePlan(context.Background(), connConfig, progressLedgerOpts)
+			if err != nil {
+				return err
+			} else if !found {
+				fmt.Println("No incomplete migration found to resume")
+				return nil
+			}
+		} else {
+			plan, err = generatePlan(context.Background(), log.SimpleLogger(), connConfig, planConfig)
+			if err != nil {
+				return err
+			} else if len(plan.Statements) == 0 {
+				fmt.Println("Schema matches expected. No plan generated")
+				return nil
+			}
 		}
 
 		fmt.Println(header("Review plan"))
@@ -58,19 +134,42 @@ func buildApplyCmd() *cobra.Command {
 		if err := failIfHazardsNotAllowed(plan, *allowedHazardsTypesStrs); err != nil {
 			return err
 		}
-		if err := mustContinuePrompt(
-			fmt.Sprintf(
-				"Apply migration with the following hazards: %s?",
-				strings.Join(*allowedHazardsTypesStrs, ", "),
-			),
-		); err != nil {
-			return err
+		if !*dryRun {
+			if err := mustContinuePrompt(
+				fmt.Sprintf(
+					"Apply migration with the following hazards: %s?",
+					strings.Join(*allowedHazardsTypesStrs, ", "),
+				),
+			); err != nil {
+				return err
+			}
 		}
 
-		if err := runPlan(context.Background(), connConfig, plan, lockTimeout); err != nil {
+		if err := runPlan(context.Background(), connConfig, plan, applyConfig{
+			allowedHazardsTypesStrs: *allowedHazardsTypesStrs,
+			lockTimeout:             *lockTimeout,
+			lockTimeoutRetryPolicy: diff.LockTimeoutRetryPolicy{
+				MaxRetries:     *lockTimeoutRetries,
+				InitialBackoff: *lockTimeoutRetryInitialBackoff,
+				MaxBackoff:     *lockTimeoutRetryMaxBackoff,
+			},
+			dryRun:                *dryRun,
+			progressLedger:        *progressLedger,
+			progressLedgerOpts:    progressLedgerOpts,
+			resume:                *resume,
+			recordHistory:         *recordHistory,
+			historyOpts:           historyOpts,
+			advisoryLockKey:       *advisoryLockKey,
+			advisoryLockTimeout:   *advisoryLockTimeout,
+			cleanupInvalidIndexes: *cleanupInvalidIndexes,
+		}); err != nil {
 			return err
 		}
-		fmt.Println("Schema applied successfully")
+		if *dryRun {
+			fmt.Println("Dry run succeeded. No statements were executed")
+		} else {
+			fmt.Println("Schema applied successfully")
+		}
 		return nil
 	}
 
@@ -109,7 +208,22 @@ func failIfHazardsNotAllowed(plan diff.Plan, allowedHazardsTypesStrs []string) e
 	return nil
 }
 
-func runPlan(ctx context.Context, connConfig *pgx.ConnConfig, plan diff.Plan, lockTimeout *time.Duration) error {
+type applyConfig struct {
+	allowedHazardsTypesStrs []string
+	lockTimeout             time.Duration
+	lockTimeoutRetryPolicy  diff.LockTimeoutRetryPolicy
+	dryRun                  bool
+	progressLedger          bool
+	progressLedgerOpts      []diff.ProgressLedgerOpt
+	resume                  bool
+	recordHistory           bool
+	historyOpts             []diff.HistoryOpt
+	advisoryLockKey         int64
+	advisoryLockTimeout     time.Duration
+	cleanupInvalidIndexes   bool
+}
+
+func runPlan(ctx context.Context, connConfig *pgx.ConnConfig, plan diff.Plan, config applyConfig) error {
 	connPool, err := openDbWithPgxConfig(connConfig)
 	if err != nil {
 		return err
@@ -122,34 +236,179 @@ func runPlan(ctx context.Context, connConfig *pgx.ConnConfig, plan diff.Plan, lo
 	}
 	defer conn.Close()
 
-	_, err = conn.ExecContext(ctx, fmt.Sprintf("SET SESSION lock_timeout = %d", lockTimeout.Milliseconds()))
-	if err != nil {
-		return fmt.Errorf("setting lock timeout: %w", err)
+	var backendPID int
+	if err := conn.QueryRowContext(ctx, "SELECT pg_backend_pid()").Scan(&backendPID); err != nil {
+		return fmt.Errorf("getting backend pid: %w", err)
 	}
+	// The plan execution stops before its next statement once the process receives SIGINT or SIGTERM
+	applyCtx, stopNotify := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stopNotify()
+	inFlight := &inFlightStatement{}
+	stopCancellingOnInterrupt := cancelInFlightStatementOnInterrupt(applyCtx, stopNotify, connPool, backendPID, inFlight)
+	defer stopCancellingOnInterrupt()
 
-	// Due to the way *sql.Db works, when a statement_timeout is set for the session, it will NOT reset
-	// by default when it's returned to the pool.
-	//
-	// We can't set the timeout at the TRANSACTION-level (for each transaction) because `ADD INDEX CONCURRENTLY`
-	// must be executed within its own transaction block. Postgres will error if you try to set a TRANSACTION-level
-	// timeout for it. SESSION-level statement_timeouts are respected by `ADD INDEX CONCURRENTLY`
-	for i, stmt := range plan.Statements {
-		fmt.Println(header(fmt.Sprintf("Executing statement %d", getDisplayableStmtIdx(i))))
-		fmt.Printf("%s\n\n", statementToPrettyS(stmt))
-		start := time.Now()
-		if _, err := conn.ExecContext(ctx, fmt.Sprintf("SET SESSION statement_timeout = %d", stmt.Timeout.Milliseconds())); err != nil {
-			return fmt.Errorf("setting statement timeout: %w", err)
+	var allowedHazardTypes []diff.MigrationHazardType
+	for _, val := range config.allowedHazardsTypesStrs {
+		allowedHazardTypes = append(allowedHazardTypes, strings.ToUpper(val))
+	}
+	applyOpts := []diff.ApplyOpt{
+		diff.WithLockTimeout(config.lockTimeout),
+		diff.WithLockTimeoutRetries(config.lockTimeoutRetryPolicy),
+		diff.WithAdvisoryLock(
+			diff.WithAdvisoryLockKey(config.advisoryLockKey),
+			diff.WithAdvisoryLockWaitTimeout(config.advisoryLockTimeout),
+		),
+		diff.WithAllowedHazards(allowedHazardTypes...),
+		diff.WithBeforeStatementHook(func(_ context.Context, stmtIdx int, stmt diff.Statement) error {
+			if config.dryRun {
+				fmt.Println(header(fmt.Sprintf("Skipping statement %d (dry run)", getDisplayableStmtIdx(stmtIdx))))
+			} else {
+				fmt.Println(header(fmt.Sprintf("Executing statement %d", getDisplayableStmtIdx(stmtIdx))))
+			}
+			fmt.Printf("%s\n\n", statementToPrettyS(stmt))
+			return nil
+		}),
+		diff.WithStatementExecutionHook(func(_ int, executing bool) {
+			inFlight.set(executing)
+		}),
+		diff.WithAfterStatementHook(func(_ context.Context, _ int, _ diff.Statement, duration time.Duration) error {
+			fmt.Printf("Finished executing statement. Duration: %s\n", duration)
+			return nil
+		}),
+	}
+	if config.dryRun {
+		applyOpts = append(applyOpts, diff.WithDryRun())
+	}
+	if config.progressLedger {
+		applyOpts = append(applyOpts, diff.WithProgressLedger(config.progressLedgerOpts...))
+	}
+	if config.resume {
+		applyOpts = append(applyOpts, diff.WithResume())
+	}
+	if config.recordHistory {
+		applyOpts = append(applyOpts, diff.WithHistory(config.historyOpts...))
+	}
+	if config.cleanupInvalidIndexes {
+		applyOpts = append(applyOpts, diff.WithInvalidIndexCleanup())
+	}
+	err = diff.ApplyPlan(applyCtx, conn, plan, applyOpts...)
+	// The connection is used to clean up after the plan, so none of its queries must be cancelled from here on
+	stopCancellingOnInterrupt()
+	if err != nil {
+		if applyCtx.Err() != nil {
+			return handleInterruptedApply(ctx, conn, plan, config, err)
 		}
-		if _, err := conn.ExecContext(ctx, stmt.ToSQL()); err != nil {
-			return fmt.Errorf("executing migration statement. the database maybe be in a dirty state: %s: %w", stmt, err)
+		if config.progressLedger {
+			return fmt.Errorf("applying plan. the database maybe be in a dirty state. Once the cause is fixed, the "+
+				"migration can be continued from the failed statement via --resume: %w", err)
 		}
-		fmt.Printf("Finished executing statement. Duration: %s\n", time.Since(start))
+		return fmt.Errorf("applying plan. the database maybe be in a dirty state: %w", err)
 	}
 	fmt.Println(header("Complete"))
 
 	return nil
 }
 
+// inFlightStatement tracks whether a statement of the plan is running on the migration's connection
+type inFlightStatement struct {
+	mu      sync.Mutex
+	running bool
+}
+
+func (s *inFlightStatement) set(running bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.running = running
+}
+
+// cancelInFlightStatementOnInterrupt cancels the statement running on the backend once ctx is cancelled by SIGINT or
+// SIGTERM. The plan execution stops before its next statement on its own, but it does not interrupt the running
+// statement, e.g., a 20 minute index build. The cancellation is only sent while the statement itself is executing
+// rather than, e.g., while its timeouts are set or its progress is recorded, since it cancels whatever query the backend
+// runs. Once ctx is cancelled, the signals are no longer handled, so a second signal kills the process
+func cancelInFlightStatementOnInterrupt(ctx context.Context, stopNotify func(), connPool *sql.DB, backendPID int, inFlight *inFlightStatement) (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-done:
+			return
+		case <-ctx.Done():
+		}
+		stopNotify()
+		fmt.Println("\nReceived interrupt. Stopping before the next statement. Send the signal again to exit immediately")
+
+		inFlight.mu.Lock()
+		defer inFlight.mu.Unlock()
+		if !inFlight.running {
+			return
+		}
+		fmt.Println("Cancelling the running statement")
+		// The migration's connection is busy running the statement, so the cancellation is sent from another one
+		if _, err := connPool.ExecContext(context.Background(), "SELECT pg_cancel_backend($1)", backendPID); err != nil {
+			fmt.Printf("Failed to cancel the running statement: %s\n", err)
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			<-stopped
+		})
+	}
+}
+
+// handleInterruptedApply drops any invalid index left behind by the cancelled statement, if --cleanup-invalid-indexes is
+// set, and prints the status of each statement, as recorded in the progress ledger. Without the progress ledger, the statuses of the statements are unknown
+func handleInterruptedApply(ctx context.Context, conn *sql.Conn, plan diff.Plan, config applyConfig, applyErr error) error {
+	fmt.Println(header("Interrupted"))
+	fmt.Printf("The migration was stopped: %s\n", applyErr)
+	if config.dryRun {
+		return fmt.Errorf("%w. No statements were executed (dry run)", errApplyInterrupted)
+	}
+	if !config.progressLedger {
+		return fmt.Errorf("%w. The database might be in a dirty state. Use --progress-ledger to record which "+
+			"statements succeeded", errApplyInterrupted)
+	}
+
+	statuses, err := diff.GetStatementStatusesFromProgressLedger(ctx, conn, plan, config.progressLedgerOpts...)
+	if err != nil {
+		return fmt.Errorf("%w. Failed to get the status of the statements: %s", errApplyInterrupted, err)
+	}
+	if config.cleanupInvalidIndexes {
+		for i, status := range statuses {
+			if status != diff.StatementStatusFailed {
+				continue
+			}
+			if droppedIndexName, dropped, err := diff.CleanupInvalidIndex(ctx, conn, plan.Statements[i]); err != nil {
+				fmt.Printf("Failed to clean up the invalid index left behind by statement %d: %s\n", getDisplayableStmtIdx(i), err)
+			} else if dropped {
+				fmt.Printf("Dropped the invalid index %s left behind by statement %d\n", droppedIndexName, getDisplayableStmtIdx(i))
+			}
+		}
+	}
+	fmt.Println(header("Statement statuses"))
+	for i, status := range statuses {
+		fmt.Printf("Statement %d: %s\n", getDisplayableStmtIdx(i), status)
+	}
+
+	return fmt.Errorf("%w. The migration can be continued from the first statement that did not succeed via --resume",
+		errApplyInterrupted)
+}
+
+func getIncompletePlan(ctx context.Context, connConfig *pgx.ConnConfig, opts []diff.ProgressLedgerOpt) (diff.Plan, bool, error) {
+	var plan diff.Plan
+	var found bool
+	err := withConn(ctx, connConfig, func(conn *sql.Conn) error {
+		var err error
+		plan, found, err = diff.GetIncompletePlanFromProgressLedger(ctx, conn, opts...)
+		return err
+	})
+	return plan, found, err
+}
+
 func getHazardTypes(plan diff.Plan) []diff.MigrationHazardType {
 	seenHazardTypes := make(map[diff.MigrationHazardType]bool)
 	var hazardTypes []diff.MigrationHazardType
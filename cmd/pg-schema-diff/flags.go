@@ -5,6 +5,7 @@ import (
 
 	"github.com/jackc/pgx/v4"
 	"github.com/spf13/cobra"
+	"github.com/stripe/pg-schema-diff/pkg/diff"
 )
 
 type connFlags struct {
@@ -35,6 +36,52 @@ func (c connFlags) parseConnConfig() (*pgx.ConnConfig, error) {
 	return config, nil
 }
 
+type progressLedgerFlags struct {
+	schema *string
+	table  *string
+}
+
+func createProgressLedgerFlags(cmd *cobra.Command) progressLedgerFlags {
+	schema := cmd.Flags().String("progress-ledger-schema", diff.DefaultProgressLedgerSchema, "the schema containing "+
+		"the progress ledger table. Must not be the public schema")
+	table := cmd.Flags().String("progress-ledger-table", diff.DefaultProgressLedgerTable, "the progress ledger table")
+
+	return progressLedgerFlags{
+		schema: schema,
+		table:  table,
+	}
+}
+
+func (p progressLedgerFlags) progressLedgerOpts() []diff.ProgressLedgerOpt {
+	return []diff.ProgressLedgerOpt{
+		diff.WithProgressLedgerSchema(*p.schema),
+		diff.WithProgressLedgerTable(*p.table),
+	}
+}
+
+type historyFlags struct {
+	schema *string
+	table  *string
+}
+
+func createHistoryFlags(cmd *cobra.Command) historyFlags {
+	schema := cmd.Flags().String("history-schema", diff.DefaultHistorySchema, "the schema containing the migration "+
+		"history table. Must not be the public schema")
+	table := cmd.Flags().String("history-table", diff.DefaultHistoryTable, "the migration history table")
+
+	return historyFlags{
+		schema: schema,
+		table:  table,
+	}
+}
+
+func (h historyFlags) historyOpts() []diff.HistoryOpt {
+	return []diff.HistoryOpt{
+		diff.WithHistorySchema(*h.schema),
+		diff.WithHistoryTable(*h.table),
+	}
+}
+
 func mustMarkFlagAsRequired(cmd *cobra.Command, flagName string) {
 	if err := cmd.MarkFlagRequired(flagName); err != nil {
 		panic(err)
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/spf13/cobra"
+	"github.com/stripe/pg-schema-diff/pkg/diff"
+)
+
+func buildHistoryCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "history",
+		Short: "List and show the migrations applied to the database",
+		Long: "List and show the migrations applied to the database with --record-history. The history is read from " +
+			"the table set via --history-schema and --history-table, which must match the flags the migrations were " +
+			"applied with",
+	}
+	cmd.AddCommand(buildHistoryListCmd())
+	cmd.AddCommand(buildHistoryShowCmd())
+	return cmd
+}
+
+func buildHistoryListCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the migrations applied to the database, most recent first",
+	}
+
+	connFlags := createConnFlags(cmd)
+	historyFlags := createHistoryFlags(cmd)
+	limit := cmd.Flags().Int("limit", 20, "the max number of migrations to list. 0 implies no limit")
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		connConfig, err := connFlags.parseConnConfig()
+		if err != nil {
+			return err
+		}
+		cmd.SilenceUsage = true
+
+		var records []diff.MigrationHistoryRecord
+		if err := withConn(context.Background(), connConfig, func(conn *sql.Conn) error {
+			records, err = diff.ListMigrationHistory(context.Background(), conn, *limit, historyFlags.historyOpts()...)
+			return err
+		}); err != nil {
+			return err
+		}
+		if len(records) == 0 {
+			fmt.Println("No migrations found")
+			return nil
+		}
+
+		w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tSTARTED AT\tDURATION\tOPERATOR\tOUTCOME\tSTATEMENTS")
+		for _, record := range records {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%d\n",
+				record.ID,
+				record.StartedAt.Format(time.RFC3339),
+				historyRecordDurationToPrettyS(record),
+				record.Operator,
+				record.Outcome,
+				len(record.Plan.Statements),
+			)
+		}
+		return w.Flush()
+	}
+
+	return cmd
+}
+
+func buildHistoryShowCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "show <id>",
+		Short: "Show a migration applied to the database, including its plan",
+		Args:  cobra.ExactArgs(1),
+	}
+
+	connFlags := createConnFlags(cmd)
+	historyFlags := createHistoryFlags(cmd)
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		id, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			return fmt.Errorf("parsing id %q: %w", args[0], err)
+		}
+		connConfig, err := connFlags.parseConnConfig()
+		if err != nil {
+			return err
+		}
+		cmd.SilenceUsage = true
+
+		var record diff.MigrationHistoryRecord
+		var found bool
+		if err := withConn(context.Background(), connConfig, func(conn *sql.Conn) error {
+			record, found, err = diff.GetMigrationHistoryRecord(context.Background(), conn, id, historyFlags.historyOpts()...)
+			return err
+		}); err != nil {
+			return err
+		} else if !found {
+			return fmt.Errorf("migration %d not found", id)
+		}
+
+		fmt.Println(historyRecordToPrettyS(record))
+		return nil
+	}
+
+	return cmd
+}
+
+func withConn(ctx context.Context, connConfig *pgx.ConnConfig, fn func(conn *sql.Conn) error) error {
+	connPool, err := openDbWithPgxConfig(connConfig)
+	if err != nil {
+		return err
+	}
+	defer connPool.Close()
+
+	conn, err := connPool.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return fn(conn)
+}
+
+func historyRecordToPrettyS(record diff.MigrationHistoryRecord) string {
+	allowedHazards := "all"
+	if record.AllowedHazardTypes != nil {
+		allowedHazards = strings.Join(record.AllowedHazardTypes, ", ")
+	}
+
+	sb := strings.Builder{}
+	sb.WriteString(header(fmt.Sprintf("Migration %d", record.ID)))
+	sb.WriteString(fmt.Sprintf("\nOperator: %s", record.Operator))
+	sb.WriteString(fmt.Sprintf("\nOutcome: %s", record.Outcome))
+	if len(record.ErrorMessage) > 0 {
+		sb.WriteString(fmt.Sprintf("\nError: %s", record.ErrorMessage))
+	}
+	sb.WriteString(fmt.Sprintf("\nResumed: %t", record.IsResume))
+	sb.WriteString(fmt.Sprintf("\nStarted at: %s", record.StartedAt.Format(time.RFC3339)))
+	sb.WriteString(fmt.Sprintf("\nDuration: %s", historyRecordDurationToPrettyS(record)))
+	sb.WriteString(fmt.Sprintf("\nSchema hash before: %s", record.BeforeSchemaHash))
+	sb.WriteString(fmt.Sprintf("\nSchema hash after: %s", record.AfterSchemaHash))
+	sb.WriteString(fmt.Sprintf("\nAllowed hazards: %s", allowedHazards))
+	sb.WriteString("\n\n" + header("Plan") + "\n")
+	sb.WriteString(planToPrettyS(record.Plan))
+	return sb.String()
+}
+
+func historyRecordDurationToPrettyS(record diff.MigrationHistoryRecord) string {
+	if record.FinishedAt.IsZero() {
+		return "-"
+	}
+	return record.FinishedAt.Sub(record.StartedAt).Round(time.Millisecond).String()
+}
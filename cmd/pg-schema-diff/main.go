@@ -1,11 +1,15 @@
 package main
 
 import (
+	"errors"
 	"os"
 
 	"github.com/spf13/cobra"
 )
 
+// exitCodeInterrupted is the conventional exit code of a process stopped by SIGINT
+const exitCodeInterrupted = 130
+
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
 	Use:   "pg-schema-diff",
@@ -15,11 +19,14 @@ var rootCmd = &cobra.Command{
 func init() {
 	rootCmd.AddCommand(buildPlanCmd())
 	rootCmd.AddCommand(buildApplyCmd())
+	rootCmd.AddCommand(buildHistoryCmd())
 }
 
 func main() {
 	err := rootCmd.Execute()
-	if err != nil {
+	if errors.Is(err, errApplyInterrupted) {
+		os.Exit(exitCodeInterrupted)
+	} else if err != nil {
 		os.Exit(1)
 	}
 }
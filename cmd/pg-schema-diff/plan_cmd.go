@@ -34,6 +34,23 @@ var (
 	indexInsertStatementRegexIndex    = insertStatementRegex.SubexpIndex("index")
 	durationInsertStatementRegexIndex = insertStatementRegex.SubexpIndex("duration")
 	ddlInsertStatementRegexIndex      = insertStatementRegex.SubexpIndex("ddl")
+
+	// Match arguments in the format "type:old=new", where column renames are in the format "column:table.old=new"
+	renameRegex           = regexp.MustCompile(`^(?P<type>table|column|index):(?:(?P<table>[^.=]+)\.)?(?P<old>[^=]+)=(?P<new>.+)$`)
+	typeRenameRegexIndex  = renameRegex.SubexpIndex("type")
+	tableRenameRegexIndex = renameRegex.SubexpIndex("table")
+	oldRenameRegexIndex   = renameRegex.SubexpIndex("old")
+	newRenameRegexIndex   = renameRegex.SubexpIndex("new")
+
+	// Match arguments in the format "old type:new type=using expression", optionally restricted to a column in the
+	// format "old type:new type:table.column=using expression". Type names don't contain ":" or "=", so the using
+	// expression is all characters after the first "="
+	typeConversionRuleRegex            = regexp.MustCompile(`^(?P<old>[^:=]+):(?P<new>[^:=]+)(?::(?P<table>[^.:=]+)\.(?P<column>[^:=]+))?=(?P<using>.+)$`)
+	oldTypeConversionRuleRegexIndex    = typeConversionRuleRegex.SubexpIndex("old")
+	newTypeConversionRuleRegexIndex    = typeConversionRuleRegex.SubexpIndex("new")
+	tableTypeConversionRuleRegexIndex  = typeConversionRuleRegex.SubexpIndex("table")
+	columnTypeConversionRuleRegexIndex = typeConversionRuleRegex.SubexpIndex("column")
+	usingTypeConversionRuleRegexIndex  = typeConversionRuleRegex.SubexpIndex("using")
 )
 
 func buildPlanCmd() *cobra.Command {
@@ -77,7 +94,11 @@ type (
 	planFlags struct {
 		schemaDir                 *string
 		statementTimeoutModifiers *[]string
+		lockTimeoutModifiers      *[]string
 		insertStatements          *[]string
+		renames                   *[]string
+		typeConversionRules       *[]string
+		shadowColumnTypeChanges   *bool
 	}
 
 	statementTimeoutModifier struct {
@@ -94,7 +115,12 @@ type (
 	planConfig struct {
 		schemaDir                 string
 		statementTimeoutModifiers []statementTimeoutModifier
-		insertStatements          []insertStatement
+		// lockTimeoutModifiers have the same format as statementTimeoutModifiers but modify the lock timeouts
+		lockTimeoutModifiers    []statementTimeoutModifier
+		insertStatements        []insertStatement
+		renames                 []diff.Rename
+		typeConversionRules     []diff.TypeConversionRule
+		shadowColumnTypeChanges bool
 	}
 )
 
@@ -105,14 +131,36 @@ func createPlanFlags(cmd *cobra.Command) planFlags {
 	statementTimeoutModifiers := cmd.Flags().StringArrayP("statement-timeout-modifier", "t", nil,
 		"regex=timeout key-value pairs, where if a statement matches the regex, the statement will have the target"+
 			" timeout. If multiple regexes match, the latest regex will take priority. Example: -t 'CREATE TABLE=5m' -t 'CONCURRENTLY=10s'")
+	lockTimeoutModifiers := cmd.Flags().StringArray("lock-timeout-modifier", nil,
+		"regex=timeout key-value pairs, where if a statement matches the regex, the statement will have the target"+
+			" lock timeout. If multiple regexes match, the latest regex will take priority. Example: --lock-timeout-modifier 'DROP INDEX CONCURRENTLY=1h'")
 	insertStatements := cmd.Flags().StringArrayP("insert-statement", "s", nil,
 		"<index>_<timeout>:<statement> values. Will insert the statement at the index in the "+
-			"generated plan with the specified timeout. This follows normal insert semantics. Example: -s '0 5s:SELECT 1''")
+			"generated plan with the specified timeout. This follows normal insert semantics. The statement runs "+
+			"outside of a transaction. Example: -s '0 5s:SELECT 1''")
+	renames := cmd.Flags().StringArray("rename", nil,
+		"<type>:<old name>=<new name> values, where type is one of table, column or index. Column renames are in the "+
+			"format column:<table>.<old name>=<new name>, where table is the new name of the table. The renamed objects "+
+			"will be renamed in place rather than dropped and re-created. Example: --rename 'column:foobar.foo=bar'")
+	typeConversionRules := cmd.Flags().StringArray("type-conversion-rule", nil,
+		"<old type>:<new type>=<using expression> values, optionally restricted to a column in the format "+
+			"<old type>:<new type>:<table>.<column>=<using expression>. Columns converted from the old type to the new "+
+			"type will use the expression in the USING clause, where "+diff.TypeConversionColumnPlaceholder+" references the "+
+			"column. If multiple rules match, the latest rule will take priority, but rules for a column take priority over "+
+			"rules for all columns. Example: --type-conversion-rule 'text:jsonb=to_jsonb({column})'")
+	shadowColumnTypeChanges := cmd.Flags().Bool("shadow-column-type-changes", false,
+		"Change the types of columns via shadow columns, which are backfilled in batches and then swapped in, rather "+
+			"than re-writing the table while it is locked. The shadow column is added as the last column of the table, so "+
+			"the changed column does not keep its position")
 
 	return planFlags{
 		schemaDir:                 schemaDir,
 		statementTimeoutModifiers: statementTimeoutModifiers,
+		lockTimeoutModifiers:      lockTimeoutModifiers,
 		insertStatements:          insertStatements,
+		renames:                   renames,
+		typeConversionRules:       typeConversionRules,
+		shadowColumnTypeChanges:   shadowColumnTypeChanges,
 	}
 }
 
@@ -126,6 +174,15 @@ func (p planFlags) parsePlanConfig() (planConfig, error) {
 		statementTimeoutModifiers = append(statementTimeoutModifiers, stm)
 	}
 
+	var lockTimeoutModifiers []statementTimeoutModifier
+	for _, s := range *p.lockTimeoutModifiers {
+		ltm, err := parseStatementTimeoutModifierStr(s)
+		if err != nil {
+			return planConfig{}, fmt.Errorf("parsing lock timeout modifier from %q: %w", s, err)
+		}
+		lockTimeoutModifiers = append(lockTimeoutModifiers, ltm)
+	}
+
 	var insertStatements []insertStatement
 	for _, i := range *p.insertStatements {
 		is, err := parseInsertStatementStr(i)
@@ -135,10 +192,32 @@ func (p planFlags) parsePlanConfig() (planConfig, error) {
 		insertStatements = append(insertStatements, is)
 	}
 
+	var renames []diff.Rename
+	for _, r := range *p.renames {
+		rename, err := parseRenameStr(r)
+		if err != nil {
+			return planConfig{}, fmt.Errorf("parsing rename from %q: %w", r, err)
+		}
+		renames = append(renames, rename)
+	}
+
+	var typeConversionRules []diff.TypeConversionRule
+	for _, t := range *p.typeConversionRules {
+		rule, err := parseTypeConversionRuleStr(t)
+		if err != nil {
+			return planConfig{}, fmt.Errorf("parsing type conversion rule from %q: %w", t, err)
+		}
+		typeConversionRules = append(typeConversionRules, rule)
+	}
+
 	return planConfig{
 		schemaDir:                 *p.schemaDir,
 		statementTimeoutModifiers: statementTimeoutModifiers,
+		lockTimeoutModifiers:      lockTimeoutModifiers,
 		insertStatements:          insertStatements,
+		renames:                   renames,
+		typeConversionRules:       typeConversionRules,
+		shadowColumnTypeChanges:   *p.shadowColumnTypeChanges,
 	}, nil
 }
 
@@ -194,6 +273,57 @@ func parseInsertStatementStr(val string) (insertStatement, error) {
 	}, nil
 }
 
+func parseRenameStr(val string) (diff.Rename, error) {
+	submatches := renameRegex.FindStringSubmatch(val)
+	if len(submatches) == 0 {
+		return diff.Rename{}, fmt.Errorf("could not parse type, old name and new name from arg. expected to be in the " +
+			"format of '<type>:<old name>=<new name>' or 'column:<table>.<old name>=<new name>'")
+	}
+	tableName := submatches[tableRenameRegexIndex]
+	oldName := submatches[oldRenameRegexIndex]
+	newName := submatches[newRenameRegexIndex]
+
+	switch objectType := submatches[typeRenameRegexIndex]; objectType {
+	case "column":
+		if len(tableName) == 0 {
+			return diff.Rename{}, fmt.Errorf("column renames must be in the format of 'column:<table>.<old name>=<new name>'")
+		}
+		return diff.ColumnRename(tableName, oldName, newName), nil
+	case "table", "index":
+		if len(tableName) > 0 {
+			return diff.Rename{}, fmt.Errorf("%s names cannot contain \".\"", objectType)
+		}
+		if objectType == "table" {
+			return diff.TableRename(oldName, newName), nil
+		}
+		return diff.IndexRename(oldName, newName), nil
+	default:
+		return diff.Rename{}, fmt.Errorf("unknown object type %q", objectType)
+	}
+}
+
+func parseTypeConversionRuleStr(val string) (diff.TypeConversionRule, error) {
+	submatches := typeConversionRuleRegex.FindStringSubmatch(val)
+	if len(submatches) == 0 {
+		return diff.TypeConversionRule{}, fmt.Errorf("could not parse old type, new type and using expression from arg. " +
+			"expected to be in the format of '<old type>:<new type>=<using expression>' or " +
+			"'<old type>:<new type>:<table>.<column>=<using expression>'")
+	}
+	using := submatches[usingTypeConversionRuleRegexIndex]
+	if !strings.Contains(using, diff.TypeConversionColumnPlaceholder) {
+		return diff.TypeConversionRule{}, fmt.Errorf("using expression must reference the column via %q",
+			diff.TypeConversionColumnPlaceholder)
+	}
+
+	return diff.TypeConversionRule{
+		OldType:    strings.TrimSpace(submatches[oldTypeConversionRuleRegexIndex]),
+		NewType:    strings.TrimSpace(submatches[newTypeConversionRuleRegexIndex]),
+		TableName:  submatches[tableTypeConversionRuleRegexIndex],
+		ColumnName: submatches[columnTypeConversionRuleRegexIndex],
+		Using:      using,
+	}, nil
+}
+
 func generatePlan(ctx context.Context, logger log.Logger, connConfig *pgx.ConnConfig, planConfig planConfig) (diff.Plan, error) {
 	ddl, err := getDDLFromPath(planConfig.schemaDir)
 	if err != nil {
@@ -227,14 +357,25 @@ func generatePlan(ctx context.Context, logger log.Logger, connConfig *pgx.ConnCo
 	}
 	defer conn.Close()
 
-	plan, err := diff.GeneratePlan(ctx, conn, tempDbFactory, ddl,
+	planOpts := []diff.PlanOpt{
 		diff.WithDataPackNewTables(),
-	)
+		diff.WithRenames(planConfig.renames...),
+		diff.WithTypeConversionRules(planConfig.typeConversionRules...),
+	}
+	if planConfig.shadowColumnTypeChanges {
+		planOpts = append(planOpts, diff.WithShadowColumnTypeChanges())
+	}
+	plan, err := diff.GeneratePlan(ctx, conn, tempDbFactory, ddl, planOpts...)
 	if err != nil {
 		return diff.Plan{}, fmt.Errorf("generating plan: %w", err)
 	}
 
-	modifiedPlan, err := applyPlanModifiers(plan, planConfig.statementTimeoutModifiers, planConfig.insertStatements)
+	modifiedPlan, err := applyPlanModifiers(
+		plan,
+		planConfig.statementTimeoutModifiers,
+		planConfig.lockTimeoutModifiers,
+		planConfig.insertStatements,
+	)
 	if err != nil {
 		return diff.Plan{}, fmt.Errorf("applying plan modifiers: %w", err)
 	}
@@ -245,16 +386,22 @@ func generatePlan(ctx context.Context, logger log.Logger, connConfig *pgx.ConnCo
 func applyPlanModifiers(
 	plan diff.Plan,
 	statementTimeoutModifiers []statementTimeoutModifier,
+	lockTimeoutModifiers []statementTimeoutModifier,
 	insertStatements []insertStatement,
 ) (diff.Plan, error) {
 	for _, stm := range statementTimeoutModifiers {
 		plan = plan.ApplyStatementTimeoutModifier(stm.regex, stm.timeout)
 	}
+	for _, ltm := range lockTimeoutModifiers {
+		plan = plan.ApplyLockTimeoutModifier(ltm.regex, ltm.timeout)
+	}
 	for _, is := range insertStatements {
 		var err error
 		plan, err = plan.InsertStatement(is.index, diff.Statement{
 			DDL:     is.ddl,
 			Timeout: is.timeout,
+			// The statement might not be able to run in a transaction, e.g., VACUUM
+			IsNonTransactional: true,
 			Hazards: []diff.MigrationHazard{{
 				Type:    diff.MigrationHazardTypeIsUserGenerated,
 				Message: "This statement is user-generated",
@@ -313,6 +460,12 @@ func statementToPrettyS(stmt diff.Statement) string {
 	sb := strings.Builder{}
 	sb.WriteString(fmt.Sprintf("%s;", stmt.DDL))
 	sb.WriteString(fmt.Sprintf("\n\t-- Timeout: %s", stmt.Timeout))
+	if stmt.LockTimeout > 0 {
+		sb.WriteString(fmt.Sprintf("\n\t-- Lock timeout: %s", stmt.LockTimeout))
+	}
+	if stmt.IsNonTransactional {
+		sb.WriteString("\n\t-- Runs outside of a transaction")
+	}
 	if len(stmt.Hazards) > 0 {
 		for _, hazard := range stmt.Hazards {
 			sb.WriteString(fmt.Sprintf("\n\t-- Hazard %s", hazardToPrettyS(hazard)))
@@ -6,6 +6,7 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/stripe/pg-schema-diff/pkg/diff"
 )
 
 func TestParseStatementTimeoutModifierStr(t *testing.T) {
@@ -117,3 +118,103 @@ func TestParseInsertStatementStr(t *testing.T) {
 		})
 	}
 }
+
+func TestParseRenameStr(t *testing.T) {
+	for _, tc := range []struct {
+		opt                 string `explicit:"always"`
+		expectedRename      diff.Rename
+		expectedErrContains string
+	}{
+		{
+			opt:            "table:foo=bar",
+			expectedRename: diff.TableRename("foo", "bar"),
+		},
+		{
+			opt:            "column:foobar.foo=Bar",
+			expectedRename: diff.ColumnRename("foobar", "foo", "Bar"),
+		},
+		{
+			opt:            "index:foo_idx=bar_idx",
+			expectedRename: diff.IndexRename("foo_idx", "bar_idx"),
+		},
+		{
+			opt:                 "column:foo=bar",
+			expectedErrContains: "column renames must be in the format",
+		},
+		{
+			opt:                 "table:public.foo=bar",
+			expectedErrContains: "table names cannot contain",
+		},
+		{
+			opt:                 "function:foo=bar",
+			expectedErrContains: "could not parse type, old name and new name from arg",
+		},
+		{
+			opt:                 "table:foo",
+			expectedErrContains: "could not parse type, old name and new name from arg",
+		},
+	} {
+		t.Run(tc.opt, func(t *testing.T) {
+			rename, err := parseRenameStr(tc.opt)
+			if len(tc.expectedErrContains) > 0 {
+				assert.ErrorContains(t, err, tc.expectedErrContains)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedRename, rename)
+		})
+	}
+}
+
+func TestParseTypeConversionRuleStr(t *testing.T) {
+	for _, tc := range []struct {
+		opt                 string `explicit:"always"`
+		expectedRule        diff.TypeConversionRule
+		expectedErrContains string
+	}{
+		{
+			opt:          "text:jsonb=to_jsonb({column})",
+			expectedRule: diff.TypeConversionRule{OldType: "text", NewType: "jsonb", Using: "to_jsonb({column})"},
+		},
+		{
+			opt: "integer:uuid:foobar.id=(SELECT uuid FROM id_mappings WHERE id = {column})",
+			expectedRule: diff.TypeConversionRule{
+				OldType:    "integer",
+				NewType:    "uuid",
+				TableName:  "foobar",
+				ColumnName: "id",
+				Using:      "(SELECT uuid FROM id_mappings WHERE id = {column})",
+			},
+		},
+		{
+			opt: "character varying(255):timestamp without time zone={column}::timestamp",
+			expectedRule: diff.TypeConversionRule{
+				OldType: "character varying(255)",
+				NewType: "timestamp without time zone",
+				Using:   "{column}::timestamp",
+			},
+		},
+		{
+			opt:                 "text:jsonb='{}'::jsonb",
+			expectedErrContains: "using expression must reference the column",
+		},
+		{
+			opt:                 "text=to_jsonb({column})",
+			expectedErrContains: "could not parse old type, new type and using expression from arg",
+		},
+		{
+			opt:                 "text:jsonb:foobar=to_jsonb({column})",
+			expectedErrContains: "could not parse old type, new type and using expression from arg",
+		},
+	} {
+		t.Run(tc.opt, func(t *testing.T) {
+			rule, err := parseTypeConversionRuleStr(tc.opt)
+			if len(tc.expectedErrContains) > 0 {
+				assert.ErrorContains(t, err, tc.expectedErrContains)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedRule, rule)
+		})
+	}
+}
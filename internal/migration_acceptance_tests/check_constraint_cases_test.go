@@ -46,9 +46,13 @@ var checkConstraintCases = []acceptanceTestCase{
 			);
 			`,
 		},
+		expectedHazardTypes: []diff.MigrationHazardType{
+			diff.MigrationHazardTypeAcquiresAccessExclusiveLock,
+			diff.MigrationHazardTypeImpactsDatabasePerformance,
+		},
 	},
 	{
-		name: "Add check constraint with UDF dependency should error",
+		name: "Add check constraint with UDF dependency",
 		oldSchemaDDL: []string{
 			`
 			CREATE TABLE foobar(
@@ -73,11 +77,10 @@ var checkConstraintCases = []acceptanceTestCase{
 			);
 			`,
 		},
-		vanillaExpectations: expectations{
-			planErrorIs: diff.ErrNotImplemented,
-		},
-		dataPackingExpectations: expectations{
-			planErrorIs: diff.ErrNotImplemented,
+		expectedHazardTypes: []diff.MigrationHazardType{
+			diff.MigrationHazardTypeHasUntrackableDependencies,
+			diff.MigrationHazardTypeAcquiresAccessExclusiveLock,
+			diff.MigrationHazardTypeImpactsDatabasePerformance,
 		},
 	},
 	{
@@ -100,6 +103,10 @@ var checkConstraintCases = []acceptanceTestCase{
 			);
 			`,
 		},
+		expectedHazardTypes: []diff.MigrationHazardType{
+			diff.MigrationHazardTypeAcquiresAccessExclusiveLock,
+			diff.MigrationHazardTypeImpactsDatabasePerformance,
+		},
 	},
 	{
 		name: "Add multiple check constraints",
@@ -122,6 +129,10 @@ var checkConstraintCases = []acceptanceTestCase{
 			);
 			`,
 		},
+		expectedHazardTypes: []diff.MigrationHazardType{
+			diff.MigrationHazardTypeAcquiresAccessExclusiveLock,
+			diff.MigrationHazardTypeImpactsDatabasePerformance,
+		},
 	},
 	{
 		name: "Add check constraints to new column",
@@ -142,6 +153,10 @@ var checkConstraintCases = []acceptanceTestCase{
 			);
 			`,
 		},
+		expectedHazardTypes: []diff.MigrationHazardType{
+			diff.MigrationHazardTypeAcquiresAccessExclusiveLock,
+			diff.MigrationHazardTypeImpactsDatabasePerformance,
+		},
 	},
 	{
 		name: "Add check constraint with quoted identifiers",
@@ -164,6 +179,10 @@ var checkConstraintCases = []acceptanceTestCase{
 			ALTER TABLE foobar ADD CONSTRAINT "BAR_CHECK" CHECK ( "Bar" < "ID" );
 			`,
 		},
+		expectedHazardTypes: []diff.MigrationHazardType{
+			diff.MigrationHazardTypeAcquiresAccessExclusiveLock,
+			diff.MigrationHazardTypeImpactsDatabasePerformance,
+		},
 	},
 	{
 		name: "Add no inherit check constraint",
@@ -186,6 +205,10 @@ var checkConstraintCases = []acceptanceTestCase{
 			ALTER TABLE foobar ADD CONSTRAINT bar_check CHECK ( bar > id ) NO INHERIT;
 			`,
 		},
+		expectedHazardTypes: []diff.MigrationHazardType{
+			diff.MigrationHazardTypeAcquiresAccessExclusiveLock,
+			diff.MigrationHazardTypeImpactsDatabasePerformance,
+		},
 	},
 	{
 		name: "Add No-Inherit, Not-Valid check constraint",
@@ -275,7 +298,7 @@ var checkConstraintCases = []acceptanceTestCase{
 		expectedHazardTypes: []diff.MigrationHazardType{diff.MigrationHazardTypeDeletesData},
 	},
 	{
-		name: "Drop check constraint with UDF dependency should error",
+		name: "Drop check constraint with UDF dependency",
 		oldSchemaDDL: []string{
 			`
 			CREATE FUNCTION add(a integer, b integer) RETURNS integer
@@ -300,12 +323,6 @@ var checkConstraintCases = []acceptanceTestCase{
 			);
 			`,
 		},
-		vanillaExpectations: expectations{
-			planErrorIs: diff.ErrNotImplemented,
-		},
-		dataPackingExpectations: expectations{
-			planErrorIs: diff.ErrNotImplemented,
-		},
 	},
 	{
 		name: "Drop check constraint with system function dependency should not error",
@@ -350,6 +367,9 @@ var checkConstraintCases = []acceptanceTestCase{
 			ALTER TABLE foobar ADD CONSTRAINT bar_check CHECK ( bar > id );
 			`,
 		},
+		expectedHazardTypes: []diff.MigrationHazardType{
+			diff.MigrationHazardTypeImpactsDatabasePerformance,
+		},
 	},
 	{
 		name: "Alter a valid check constraint to be invalid",
@@ -396,6 +416,10 @@ var checkConstraintCases = []acceptanceTestCase{
 			ALTER TABLE foobar ADD CONSTRAINT bar_check CHECK ( bar > id );
 			`,
 		},
+		expectedHazardTypes: []diff.MigrationHazardType{
+			diff.MigrationHazardTypeAcquiresAccessExclusiveLock,
+			diff.MigrationHazardTypeImpactsDatabasePerformance,
+		},
 	},
 	{
 		name: "Alter an Inheritable check constraint to be No-Inherit",
@@ -419,6 +443,10 @@ var checkConstraintCases = []acceptanceTestCase{
 			ALTER TABLE foobar ADD CONSTRAINT bar_check CHECK ( bar > id ) NO INHERIT;
 			`,
 		},
+		expectedHazardTypes: []diff.MigrationHazardType{
+			diff.MigrationHazardTypeAcquiresAccessExclusiveLock,
+			diff.MigrationHazardTypeImpactsDatabasePerformance,
+		},
 	},
 	{
 		name: "Alter a check constraint expression",
@@ -440,9 +468,40 @@ var checkConstraintCases = []acceptanceTestCase{
 			);
 			`,
 		},
+		expectedHazardTypes: []diff.MigrationHazardType{
+			diff.MigrationHazardTypeAcquiresAccessExclusiveLock,
+			diff.MigrationHazardTypeImpactsDatabasePerformance,
+		},
+	},
+	{
+		name: "Alter a check constraint expression that references a dropped column",
+		oldSchemaDDL: []string{
+			`
+			CREATE TABLE foobar(
+			    id INT PRIMARY KEY,
+				foo VARCHAR(255),
+				bar BIGINT,
+				CONSTRAINT some_check CHECK (bar > id)
+			);
+			`,
+		},
+		newSchemaDDL: []string{
+			`
+			CREATE TABLE foobar(
+			    id INT PRIMARY KEY,
+				foo VARCHAR(255),
+				CONSTRAINT some_check CHECK (id > 0)
+			);
+			`,
+		},
+		expectedHazardTypes: []diff.MigrationHazardType{
+			diff.MigrationHazardTypeDeletesData,
+			diff.MigrationHazardTypeAcquiresAccessExclusiveLock,
+			diff.MigrationHazardTypeImpactsDatabasePerformance,
+		},
 	},
 	{
-		name: "Alter check constraint with UDF dependency should error",
+		name: "Validate check constraint with UDF dependency",
 		oldSchemaDDL: []string{
 			`
 			CREATE FUNCTION add(a integer, b integer) RETURNS integer
@@ -475,11 +534,43 @@ var checkConstraintCases = []acceptanceTestCase{
 			ALTER TABLE foobar ADD CONSTRAINT some_constraint CHECK ( add(bar, id) > 0 );
 			`,
 		},
-		vanillaExpectations: expectations{
-			planErrorIs: diff.ErrNotImplemented,
+	},
+	{
+		name: "Check constraint with UDF dependency is re-validated when the UDF changes",
+		oldSchemaDDL: []string{
+			`
+			CREATE FUNCTION is_positive(a integer) RETURNS boolean
+				LANGUAGE SQL
+				IMMUTABLE
+				RETURNS NULL ON NULL INPUT
+				RETURN a > 0;
+
+			CREATE TABLE foobar(
+			    id INT PRIMARY KEY,
+				foo VARCHAR(255),
+				bar INT CHECK ( is_positive(bar) )
+			);
+			`,
+		},
+		newSchemaDDL: []string{
+			`
+			CREATE FUNCTION is_positive(a integer) RETURNS boolean
+				LANGUAGE SQL
+				IMMUTABLE
+				RETURNS NULL ON NULL INPUT
+				RETURN a >= 1;
+
+			CREATE TABLE foobar(
+			    id INT PRIMARY KEY,
+				foo VARCHAR(255),
+				bar INT CHECK ( is_positive(bar) )
+			);
+			`,
 		},
-		dataPackingExpectations: expectations{
-			planErrorIs: diff.ErrNotImplemented,
+		expectedHazardTypes: []diff.MigrationHazardType{
+			diff.MigrationHazardTypeHasUntrackableDependencies,
+			diff.MigrationHazardTypeAcquiresAccessExclusiveLock,
+			diff.MigrationHazardTypeImpactsDatabasePerformance,
 		},
 	},
 	{
@@ -81,6 +81,9 @@ var columnAcceptanceTestCases = []acceptanceTestCase{
 			);
 			`,
 		},
+		expectedHazardTypes: []diff.MigrationHazardType{
+			diff.MigrationHazardTypeFailsOnExistingData,
+		},
 	},
 	{
 		name: "Add one column with all options",
@@ -100,6 +103,29 @@ var columnAcceptanceTestCases = []acceptanceTestCase{
 			`,
 		},
 	},
+	{
+		name: "Add one column with volatile default",
+		oldSchemaDDL: []string{
+			`
+			CREATE TABLE foobar(
+			    id INT PRIMARY KEY
+			);
+			INSERT INTO foobar (id) VALUES (1), (2);
+			`,
+		},
+		newSchemaDDL: []string{
+			`
+			CREATE TABLE foobar(
+			    id INT PRIMARY KEY,
+				my_new_column UUID NOT NULL DEFAULT gen_random_uuid()
+			);
+			`,
+		},
+		expectedHazardTypes: []diff.MigrationHazardType{
+			diff.MigrationHazardTypeAcquiresAccessExclusiveLock,
+			diff.MigrationHazardTypeImpactsDatabasePerformance,
+		},
+	},
 	{
 		name: "Add one column and change ordering",
 		oldSchemaDDL: []string{
@@ -219,7 +245,6 @@ var columnAcceptanceTestCases = []acceptanceTestCase{
 		},
 		expectedHazardTypes: []diff.MigrationHazardType{
 			diff.MigrationHazardTypeAcquiresAccessExclusiveLock,
-			diff.MigrationHazardTypeImpactsDatabasePerformance,
 		},
 	},
 	{
@@ -371,6 +396,67 @@ var columnAcceptanceTestCases = []acceptanceTestCase{
 			`,
 		},
 	},
+	{
+		name: "Add default that depends on a new function",
+		oldSchemaDDL: []string{
+			`
+			CREATE TABLE foobar(
+			    id INT PRIMARY KEY,
+				foobar INT
+			);
+			`,
+		},
+		newSchemaDDL: []string{
+			`
+			CREATE FUNCTION add(a integer, b integer) RETURNS integer
+				LANGUAGE SQL
+				IMMUTABLE
+				RETURNS NULL ON NULL INPUT
+				RETURN a + b;
+
+			CREATE TABLE foobar(
+			    id INT PRIMARY KEY,
+				foobar INT DEFAULT add(1, 2)
+			);
+
+			CREATE TABLE foobar_new(
+			    id INT PRIMARY KEY,
+				foobar INT DEFAULT add(3, 4)
+			);
+			`,
+		},
+	},
+	{
+		name: "Remove default and drop the function it depends on",
+		oldSchemaDDL: []string{
+			`
+			CREATE FUNCTION add(a integer, b integer) RETURNS integer
+				LANGUAGE SQL
+				IMMUTABLE
+				RETURNS NULL ON NULL INPUT
+				RETURN a + b;
+
+			CREATE TABLE foobar(
+			    id INT PRIMARY KEY,
+				foobar INT DEFAULT add(1, 2)
+			);
+
+			CREATE TABLE foobar_old(
+			    id INT PRIMARY KEY,
+				foobar INT DEFAULT add(3, 4)
+			);
+			`,
+		},
+		newSchemaDDL: []string{
+			`
+			CREATE TABLE foobar(
+			    id INT PRIMARY KEY,
+				foobar INT
+			);
+			`,
+		},
+		expectedHazardTypes: []diff.MigrationHazardType{diff.MigrationHazardTypeDeletesData},
+	},
 	{
 		name: "Set NOT NULL",
 		oldSchemaDDL: []string{
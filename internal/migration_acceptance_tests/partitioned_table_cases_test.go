@@ -266,6 +266,7 @@ var partitionedTableAcceptanceTestCases = []acceptanceTestCase{
 		},
 		expectedHazardTypes: []diff.MigrationHazardType{
 			diff.MigrationHazardTypeAcquiresAccessExclusiveLock,
+			diff.MigrationHazardTypeAcquiresShareLock,
 			diff.MigrationHazardTypeImpactsDatabasePerformance,
 			diff.MigrationHazardTypeIndexDropped,
 			diff.MigrationHazardTypeIndexBuild,
@@ -644,6 +645,49 @@ var partitionedTableAcceptanceTestCases = []acceptanceTestCase{
 			`,
 		},
 	},
+	{
+		name: "Adding a partition with an existing default partition",
+		oldSchemaDDL: []string{
+			`
+			CREATE TABLE foobar(
+			    id INT,
+				fizz INT,
+				foo VARCHAR(255),
+				bar TEXT,
+				CHECK ( fizz > 0 ),
+				PRIMARY KEY (foo, id)
+			) PARTITION BY LIST (foo);
+
+			CREATE TABLE foobar_default PARTITION OF foobar DEFAULT;
+
+			-- partitioned indexes
+			CREATE UNIQUE INDEX some_partitioned_idx ON foobar(foo, bar);
+			`,
+		},
+		newSchemaDDL: []string{
+			`
+			CREATE TABLE foobar(
+			    id INT,
+				fizz INT,
+				foo VARCHAR(255),
+				bar TEXT,
+				CHECK ( fizz > 0 ),
+				PRIMARY KEY (foo, id)
+			) PARTITION BY LIST (foo);
+
+			CREATE TABLE foobar_default PARTITION OF foobar DEFAULT;
+			CREATE TABLE foobar_1 PARTITION OF foobar FOR VALUES IN ('foo_1');
+			CREATE TABLE foobar_2 PARTITION OF foobar FOR VALUES IN ('foo_2', 'foo_3');
+
+			-- partitioned indexes
+			CREATE UNIQUE INDEX some_partitioned_idx ON foobar(foo, bar);
+			`,
+		},
+		expectedHazardTypes: []diff.MigrationHazardType{
+			diff.MigrationHazardTypeAcquiresAccessExclusiveLock,
+			diff.MigrationHazardTypeImpactsDatabasePerformance,
+		},
+	},
 	{
 		name: "Deleting a partitioning errors",
 		oldSchemaDDL: []string{
@@ -237,8 +237,10 @@ var tableAcceptanceTestCases = []acceptanceTestCase{
 		expectedHazardTypes: []diff.MigrationHazardType{
 			diff.MigrationHazardTypeAcquiresAccessExclusiveLock,
 			diff.MigrationHazardTypeDeletesData,
+			diff.MigrationHazardTypeFailsOnExistingData,
 			diff.MigrationHazardTypeIndexDropped,
 			diff.MigrationHazardTypeIndexBuild,
+			diff.MigrationHazardTypeImpactsDatabasePerformance,
 		},
 	},
 	{
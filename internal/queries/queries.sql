@@ -11,7 +11,12 @@ SELECT c.oid                                        AS oid,
        (CASE
             WHEN c.relispartition THEN pg_catalog.pg_get_expr(c.relpartbound, c.oid)
             ELSE ''
-           END)::text                               AS partition_for_values
+           END)::text                               AS partition_for_values,
+       (CASE
+            WHEN c.relispartition AND pg_catalog.pg_get_expr(c.relpartbound, c.oid) != 'DEFAULT'
+                THEN COALESCE(pg_catalog.pg_get_partition_constraintdef(c.oid), '')
+            ELSE ''
+           END)::text                               AS partition_constraint_def
 FROM pg_catalog.pg_class c
          LEFT JOIN pg_catalog.pg_inherits inherits ON inherits.inhrelid = c.oid
          LEFT JOIN pg_catalog.pg_class parent_c ON inherits.inhparent = parent_c.oid
@@ -19,6 +24,13 @@ FROM pg_catalog.pg_class c
 WHERE c.relnamespace = (SELECT oid FROM pg_catalog.pg_namespace WHERE nspname = 'public')
   AND (c.relkind = 'r' OR c.relkind = 'p');
 
+-- name: GetTableSizes :many
+SELECT c.relname::TEXT                                   AS table_name,
+       pg_catalog.pg_total_relation_size(c.oid)::BIGINT AS size_in_bytes
+FROM pg_catalog.pg_class c
+WHERE c.relnamespace = (SELECT oid FROM pg_catalog.pg_namespace WHERE nspname = 'public')
+  AND c.relkind = 'r';
+
 -- name: GetColumnsForTable :many
 SELECT a.attname::TEXT                                                AS column_name,
        pg_catalog.format_type(a.atttypid, a.atttypmod)                AS column_type,
@@ -26,7 +38,13 @@ SELECT a.attname::TEXT                                                AS column_
        COALESCE(collation_namespace.nspname, '')::TEXT                AS collation_schema_name,
        COALESCE(pg_catalog.pg_get_expr(d.adbin, d.adrelid), '')::TEXT AS default_value,
        a.attnotnull                                                   AS is_not_null,
-       a.attlen                                                       AS column_size
+       a.attlen                                                       AS column_size,
+       -- The functions called by the default expression, including via operators, are parsed from its node tree
+       COALESCE((SELECT BOOL_OR(proc.provolatile = 'v')
+                 FROM pg_catalog.regexp_matches(d.adbin::TEXT, ':(?:funcid|opfuncid) (\d+)', 'g') AS func_id_match(func_ids)
+                          JOIN pg_catalog.pg_proc proc ON proc.oid = (func_id_match.func_ids)[1]::OID),
+                false)                                                AS is_default_volatile,
+       d.oid                                                          AS default_oid
 FROM pg_catalog.pg_attribute a
          LEFT JOIN pg_catalog.pg_attrdef d ON (d.adrelid = a.attrelid AND d.adnum = a.attnum)
          LEFT JOIN pg_catalog.pg_collation coll ON coll.oid = a.attcollation
@@ -102,6 +120,17 @@ FROM pg_catalog.pg_depend depend
 WHERE depend.objid = $1
   AND depend.deptype = 'n';
 
+-- name: GetDependsOnSequences :many
+SELECT seq_c.relname::TEXT         as sequence_name,
+       seq_namespace.nspname::TEXT as sequence_schema_name
+FROM pg_catalog.pg_depend depend
+         JOIN pg_catalog.pg_class seq_c ON depend.refobjid = seq_c.oid
+         JOIN pg_catalog.pg_namespace seq_namespace ON seq_c.relnamespace = seq_namespace.oid
+WHERE depend.objid = $1
+  AND depend.refclassid = 'pg_catalog.pg_class'::REGCLASS
+  AND seq_c.relkind = 'S'
+  AND depend.deptype = 'n';
+
 -- name: GetTriggers :many
 SELECT trig.tgname::TEXT                                       as trigger_name,
        owning_c.relname::TEXT                                  as owning_table_name,
@@ -120,3 +149,9 @@ WHERE proc_namespace.nspname = 'public'
   AND trig.tgparentid = 0
   AND NOT trig.tgisinternal;
 
+
+-- name: GetBinaryCoercibleCasts :many
+SELECT pg_catalog.format_type(c.castsource, NULL) AS source_type,
+       pg_catalog.format_type(c.casttarget, NULL) AS target_type
+FROM pg_catalog.pg_cast c
+WHERE c.castmethod = 'b';
@@ -9,6 +9,41 @@ import (
 	"context"
 )
 
+const getBinaryCoercibleCasts = `-- name: GetBinaryCoercibleCasts :many
+SELECT pg_catalog.format_type(c.castsource, NULL) AS source_type,
+       pg_catalog.format_type(c.casttarget, NULL) AS target_type
+FROM pg_catalog.pg_cast c
+WHERE c.castmethod = 'b'
+`
+
+type GetBinaryCoercibleCastsRow struct {
+	SourceType string
+	TargetType string
+}
+
+func (q *Queries) GetBinaryCoercibleCasts(ctx context.Context) ([]GetBinaryCoercibleCastsRow, error) {
+	rows, err := q.db.QueryContext(ctx, getBinaryCoercibleCasts)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetBinaryCoercibleCastsRow
+	for rows.Next() {
+		var i GetBinaryCoercibleCastsRow
+		if err := rows.Scan(&i.SourceType, &i.TargetType); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getCheckConstraints = `-- name: GetCheckConstraints :many
 SELECT pg_constraint.oid,
        conname::TEXT                            as name,
@@ -100,7 +135,13 @@ SELECT a.attname::TEXT                                                AS column_
        COALESCE(collation_namespace.nspname, '')::TEXT                AS collation_schema_name,
        COALESCE(pg_catalog.pg_get_expr(d.adbin, d.adrelid), '')::TEXT AS default_value,
        a.attnotnull                                                   AS is_not_null,
-       a.attlen                                                       AS column_size
+       a.attlen                                                       AS column_size,
+       -- The functions called by the default expression, including via operators, are parsed from its node tree
+       COALESCE((SELECT BOOL_OR(proc.provolatile = 'v')
+                 FROM pg_catalog.regexp_matches(d.adbin::TEXT, ':(?:funcid|opfuncid) (\d+)', 'g') AS func_id_match(func_ids)
+                          JOIN pg_catalog.pg_proc proc ON proc.oid = (func_id_match.func_ids)[1]::OID),
+                false)                                                AS is_default_volatile,
+       d.oid                                                          AS default_oid
 FROM pg_catalog.pg_attribute a
          LEFT JOIN pg_catalog.pg_attrdef d ON (d.adrelid = a.attrelid AND d.adnum = a.attnum)
          LEFT JOIN pg_catalog.pg_collation coll ON coll.oid = a.attcollation
@@ -119,6 +160,8 @@ type GetColumnsForTableRow struct {
 	DefaultValue        string
 	IsNotNull           bool
 	ColumnSize          int16
+	IsDefaultVolatile   bool
+	DefaultOid          interface{}
 }
 
 func (q *Queries) GetColumnsForTable(ctx context.Context, attrelid interface{}) ([]GetColumnsForTableRow, error) {
@@ -138,6 +181,8 @@ func (q *Queries) GetColumnsForTable(ctx context.Context, attrelid interface{})
 			&i.DefaultValue,
 			&i.IsNotNull,
 			&i.ColumnSize,
+			&i.IsDefaultVolatile,
+			&i.DefaultOid,
 		); err != nil {
 			return nil, err
 		}
@@ -192,6 +237,46 @@ func (q *Queries) GetDependsOnFunctions(ctx context.Context, objid interface{})
 	return items, nil
 }
 
+const getDependsOnSequences = `-- name: GetDependsOnSequences :many
+SELECT seq_c.relname::TEXT         as sequence_name,
+       seq_namespace.nspname::TEXT as sequence_schema_name
+FROM pg_catalog.pg_depend depend
+         JOIN pg_catalog.pg_class seq_c ON depend.refobjid = seq_c.oid
+         JOIN pg_catalog.pg_namespace seq_namespace ON seq_c.relnamespace = seq_namespace.oid
+WHERE depend.objid = $1
+  AND depend.refclassid = 'pg_catalog.pg_class'::REGCLASS
+  AND seq_c.relkind = 'S'
+  AND depend.deptype = 'n'
+`
+
+type GetDependsOnSequencesRow struct {
+	SequenceName       string
+	SequenceSchemaName string
+}
+
+func (q *Queries) GetDependsOnSequences(ctx context.Context, objid interface{}) ([]GetDependsOnSequencesRow, error) {
+	rows, err := q.db.QueryContext(ctx, getDependsOnSequences, objid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetDependsOnSequencesRow
+	for rows.Next() {
+		var i GetDependsOnSequencesRow
+		if err := rows.Scan(&i.SequenceName, &i.SequenceSchemaName); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getFunctions = `-- name: GetFunctions :many
 SELECT proc.oid,
        proname::TEXT                                           as func_name,
@@ -316,6 +401,42 @@ func (q *Queries) GetIndexes(ctx context.Context) ([]GetIndexesRow, error) {
 	return items, nil
 }
 
+const getTableSizes = `-- name: GetTableSizes :many
+SELECT c.relname::TEXT                                   AS table_name,
+       pg_catalog.pg_total_relation_size(c.oid)::BIGINT AS size_in_bytes
+FROM pg_catalog.pg_class c
+WHERE c.relnamespace = (SELECT oid FROM pg_catalog.pg_namespace WHERE nspname = 'public')
+  AND c.relkind = 'r'
+`
+
+type GetTableSizesRow struct {
+	TableName   string
+	SizeInBytes int64
+}
+
+func (q *Queries) GetTableSizes(ctx context.Context) ([]GetTableSizesRow, error) {
+	rows, err := q.db.QueryContext(ctx, getTableSizes)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetTableSizesRow
+	for rows.Next() {
+		var i GetTableSizesRow
+		if err := rows.Scan(&i.TableName, &i.SizeInBytes); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getTables = `-- name: GetTables :many
 SELECT c.oid                                        AS oid,
        c.relname::TEXT                              AS table_name,
@@ -329,7 +450,12 @@ SELECT c.oid                                        AS oid,
        (CASE
             WHEN c.relispartition THEN pg_catalog.pg_get_expr(c.relpartbound, c.oid)
             ELSE ''
-           END)::text                               AS partition_for_values
+           END)::text                               AS partition_for_values,
+       (CASE
+            WHEN c.relispartition AND pg_catalog.pg_get_expr(c.relpartbound, c.oid) != 'DEFAULT'
+                THEN COALESCE(pg_catalog.pg_get_partition_constraintdef(c.oid), '')
+            ELSE ''
+           END)::text                               AS partition_constraint_def
 FROM pg_catalog.pg_class c
          LEFT JOIN pg_catalog.pg_inherits inherits ON inherits.inhrelid = c.oid
          LEFT JOIN pg_catalog.pg_class parent_c ON inherits.inhparent = parent_c.oid
@@ -339,12 +465,13 @@ WHERE c.relnamespace = (SELECT oid FROM pg_catalog.pg_namespace WHERE nspname =
 `
 
 type GetTablesRow struct {
-	Oid                   interface{}
-	TableName             string
-	ParentTableName       string
-	ParentTableSchemaName string
-	PartitionKeyDef       string
-	PartitionForValues    string
+	Oid                    interface{}
+	TableName              string
+	ParentTableName        string
+	ParentTableSchemaName  string
+	PartitionKeyDef        string
+	PartitionForValues     string
+	PartitionConstraintDef string
 }
 
 func (q *Queries) GetTables(ctx context.Context) ([]GetTablesRow, error) {
@@ -363,6 +490,7 @@ func (q *Queries) GetTables(ctx context.Context) ([]GetTablesRow, error) {
 			&i.ParentTableSchemaName,
 			&i.PartitionKeyDef,
 			&i.PartitionForValues,
+			&i.PartitionConstraintDef,
 		); err != nil {
 			return nil, err
 		}
@@ -66,6 +66,14 @@ func (s Schema) Normalize() Schema {
 	for _, table := range sortSchemaObjectsByName(s.Tables) {
 		// Don't normalize columns order. their order is derived from the postgres catalogs
 		// (relevant to data packing)
+		var normColumns []Column
+		for _, column := range table.Columns {
+			column.DependsOnFunctions = sortSchemaObjectsByName(column.DependsOnFunctions)
+			column.DependsOnSequences = sortSchemaObjectsByName(column.DependsOnSequences)
+			normColumns = append(normColumns, column)
+		}
+		table.Columns = normColumns
+
 		var normCheckConstraints []CheckConstraint
 		for _, checkConstraint := range sortSchemaObjectsByName(table.CheckConstraints) {
 			checkConstraint.DependsOnFunctions = sortSchemaObjectsByName(checkConstraint.DependsOnFunctions)
@@ -121,6 +129,10 @@ type Table struct {
 
 	ParentTableName string
 	ForValues       string
+	// PartitionConstraintDef is the output of Pg function pg_get_partition_constraintdef, i.e., the implicit
+	// constraint that all rows in the partition satisfy. It is empty if the table is not a partition or if the table
+	// is the DEFAULT partition
+	PartitionConstraintDef string
 }
 
 func (t Table) IsPartitioned() bool {
@@ -131,6 +143,11 @@ func (t Table) IsPartition() bool {
 	return len(t.ForValues) > 0
 }
 
+// IsDefaultPartition returns true if the table is the DEFAULT partition of its parent table
+func (t Table) IsDefaultPartition() bool {
+	return t.ForValues == "DEFAULT"
+}
+
 func (t Table) GetName() string {
 	return t.Name
 }
@@ -144,8 +161,15 @@ type Column struct {
 	//   ''::text
 	//   CURRENT_TIMESTAMP
 	// If empty, indicates that there is no default value.
-	Default    string
-	IsNullable bool
+	Default string
+	// IsDefaultVolatile indicates the default value calls a volatile function, e.g., gen_random_uuid(), such that it
+	// is evaluated separately for every row
+	IsDefaultVolatile bool
+	// DependsOnFunctions are the functions called by the default value
+	DependsOnFunctions []SchemaQualifiedName
+	// DependsOnSequences are the sequences used by the default value, e.g., via nextval
+	DependsOnSequences []SchemaQualifiedName
+	IsNullable         bool
 
 	// Size is the number of bytes required to store the value.
 	// It is used for data-packing purposes
@@ -166,6 +190,8 @@ var (
 	//
 	// The third matching group is the rest of the statement
 	idxToConcurrentlyRegex = regexp.MustCompile("^(CREATE (UNIQUE )?INDEX )(.*)$")
+	idxToOnOnlyRegex       = regexp.MustCompile(`^(CREATE (UNIQUE )?INDEX (CONCURRENTLY )?("([^"]|"")*"|[^ "]+) ON )(ONLY )?(.*)$`)
+	idxToOnTableRegex      = regexp.MustCompile(`^(CREATE (UNIQUE )?INDEX )("([^"]|"")*"|[^ "]+)( ON (ONLY )?)((("([^"]|"")*"|[^ ".]+)\.)?("([^"]|"")*"|[^ ".]+))( USING .*)$`)
 )
 
 // GetIndexDefStatement is the output of pg_getindexdef. It is a `CREATE INDEX` statement that will re-create
@@ -181,6 +207,32 @@ func (i GetIndexDefStatement) ToCreateIndexConcurrently() (string, error) {
 	return idxToConcurrentlyRegex.ReplaceAllString(string(i), "${1}CONCURRENTLY ${3}"), nil
 }
 
+// ToCreateIndexOnOnly returns the statement with `ONLY`, such that the index is only created on the table itself and
+// not on its partitions. pg_getindexdef already includes `ONLY` for indexes on partitioned tables, so this is
+// a no-op for those statements
+func (i GetIndexDefStatement) ToCreateIndexOnOnly() (string, error) {
+	if !idxToOnOnlyRegex.MatchString(string(i)) {
+		return "", fmt.Errorf("%s follows an unexpected structure", i)
+	}
+	return idxToOnOnlyRegex.ReplaceAllString(string(i), "${1}ONLY ${7}"), nil
+}
+
+// ToCreateIndexOnTable returns the statement to create the same index with the given name on the given table. The
+// table must have the columns referenced by the index
+func (i GetIndexDefStatement) ToCreateIndexOnTable(indexName, tableName string) (string, error) {
+	matches := idxToOnTableRegex.FindStringSubmatch(string(i))
+	if matches == nil {
+		return "", fmt.Errorf("%s follows an unexpected structure", i)
+	}
+	return fmt.Sprintf("%s%s%s%s%s",
+		matches[1],
+		EscapeIdentifier(indexName),
+		matches[5],
+		EscapeIdentifier(tableName),
+		matches[13],
+	), nil
+}
+
 type Index struct {
 	TableName string
 	Name      string
@@ -326,6 +378,18 @@ func fetchTables(ctx context.Context, q *queries.Queries) ([]Table, error) {
 		}
 		var columns []Column
 		for _, column := range rawColumns {
+			var defaultDependsOnFunctions, defaultDependsOnSequences []SchemaQualifiedName
+			if column.DefaultOid != nil {
+				defaultDependsOnFunctions, err = fetchDependsOnFunctions(ctx, q, column.DefaultOid)
+				if err != nil {
+					return nil, fmt.Errorf("fetchDependsOnFunctions(%s): %w", column.DefaultOid, err)
+				}
+				defaultDependsOnSequences, err = fetchDependsOnSequences(ctx, q, column.DefaultOid)
+				if err != nil {
+					return nil, fmt.Errorf("fetchDependsOnSequences(%s): %w", column.DefaultOid, err)
+				}
+			}
+
 			collation := SchemaQualifiedName{}
 			if len(column.CollationName) > 0 {
 				collation = SchemaQualifiedName{
@@ -344,8 +408,11 @@ func fetchTables(ctx context.Context, q *queries.Queries) ([]Table, error) {
 				//   ''::text
 				//   CURRENT_TIMESTAMP
 				// If empty, indicates that there is no default value.
-				Default: column.DefaultValue,
-				Size:    int(column.ColumnSize),
+				Default:            column.DefaultValue,
+				IsDefaultVolatile:  column.IsDefaultVolatile,
+				DependsOnFunctions: defaultDependsOnFunctions,
+				DependsOnSequences: defaultDependsOnSequences,
+				Size:               int(column.ColumnSize),
 			})
 		}
 
@@ -356,8 +423,9 @@ func fetchTables(ctx context.Context, q *queries.Queries) ([]Table, error) {
 
 			PartitionKeyDef: table.PartitionKeyDef,
 
-			ParentTableName: table.ParentTableName,
-			ForValues:       table.PartitionForValues,
+			ParentTableName:        table.ParentTableName,
+			ForValues:              table.PartitionForValues,
+			PartitionConstraintDef: table.PartitionConstraintDef,
 		})
 	}
 	return tables, nil
@@ -461,6 +529,20 @@ func fetchDependsOnFunctions(ctx context.Context, q *queries.Queries, oid any) (
 	return functionNames, nil
 }
 
+func fetchDependsOnSequences(ctx context.Context, q *queries.Queries, oid any) ([]SchemaQualifiedName, error) {
+	dependsOnSequences, err := q.GetDependsOnSequences(ctx, oid)
+	if err != nil {
+		return nil, err
+	}
+
+	var sequenceNames []SchemaQualifiedName
+	for _, rawSequence := range dependsOnSequences {
+		sequenceNames = append(sequenceNames, buildNameFromUnescaped(rawSequence.SequenceName, rawSequence.SequenceSchemaName))
+	}
+
+	return sequenceNames, nil
+}
+
 func fetchTriggers(ctx context.Context, q *queries.Queries) ([]Trigger, error) {
 	rawTriggers, err := q.GetTriggers(ctx)
 	if err != nil {
@@ -79,7 +79,7 @@ var (
 				WHEN (OLD.* IS DISTINCT FROM NEW.*)
 				EXECUTE PROCEDURE increment_version();
 		`},
-			expectedHash: "9648c294aed76ef6",
+			expectedHash: "700ae13dac05fa47",
 			expectedSchema: schema.Schema{
 				Name: "public",
 				Tables: []schema.Table{
@@ -210,7 +210,7 @@ var (
 				EXECUTE PROCEDURE increment_version();
 
 		`},
-			expectedHash: "651c9229cd8373f0",
+			expectedHash: "9eec92dc17fbb59a",
 			expectedSchema: schema.Schema{
 				Name: "public",
 				Tables: []schema.Table{
@@ -240,8 +240,9 @@ var (
 							{Name: "genre", Type: "character varying(256)", Size: -1, Collation: defaultCollation},
 							{Name: "created_at", Type: "timestamp without time zone", Default: "CURRENT_TIMESTAMP", Size: 8},
 						},
-						CheckConstraints: nil,
-						ForValues:        "FOR VALUES IN ('some author 1')",
+						CheckConstraints:       nil,
+						ForValues:              "FOR VALUES IN ('some author 1')",
+						PartitionConstraintDef: "((author IS NOT NULL) AND (author = 'some author 1'::text))",
 					},
 					{
 						ParentTableName: "foo",
@@ -253,8 +254,9 @@ var (
 							{Name: "genre", Type: "character varying(256)", Size: -1, Collation: defaultCollation},
 							{Name: "created_at", Type: "timestamp without time zone", Default: "CURRENT_TIMESTAMP", Size: 8},
 						},
-						CheckConstraints: nil,
-						ForValues:        "FOR VALUES IN ('some author 2')",
+						CheckConstraints:       nil,
+						ForValues:              "FOR VALUES IN ('some author 2')",
+						PartitionConstraintDef: "((author IS NOT NULL) AND (author = 'some author 2'::text))",
 					},
 					{
 						ParentTableName: "foo",
@@ -266,8 +268,9 @@ var (
 							{Name: "genre", Type: "character varying(256)", Size: -1, Collation: defaultCollation},
 							{Name: "created_at", Type: "timestamp without time zone", Default: "CURRENT_TIMESTAMP", Size: 8},
 						},
-						CheckConstraints: nil,
-						ForValues:        "FOR VALUES IN ('some author 3')",
+						CheckConstraints:       nil,
+						ForValues:              "FOR VALUES IN ('some author 3')",
+						PartitionConstraintDef: "((author IS NOT NULL) AND (author = 'some author 3'::text))",
 					},
 				},
 				Indexes: []schema.Index{
@@ -393,7 +396,7 @@ var (
 			    PRIMARY KEY (author, id)
 			) FOR VALUES IN ('some author 1');
 		`},
-			expectedHash: "6976f3d0ada49b66",
+			expectedHash: "20bffdd6d46278c8",
 			expectedSchema: schema.Schema{
 				Name: "public",
 				Tables: []schema.Table{
@@ -413,8 +416,9 @@ var (
 							{Name: "id", Type: "integer", Size: 4},
 							{Name: "author", Type: "text", Size: -1, Collation: defaultCollation},
 						},
-						CheckConstraints: nil,
-						ForValues:        "FOR VALUES IN ('some author 1')",
+						CheckConstraints:       nil,
+						ForValues:              "FOR VALUES IN ('some author 1')",
+						PartitionConstraintDef: "((author IS NOT NULL) AND (author = 'some author 1'::text))",
 					},
 				},
 				Indexes: []schema.Index{
@@ -442,7 +446,7 @@ var (
 				"decimal" DECIMAL(65, 10) NOT NULL DEFAULT 0.0
 			);
 		`},
-			expectedHash: "2181b2da75bb74f7",
+			expectedHash: "8c8ec9794200bd65",
 			expectedSchema: schema.Schema{
 				Name: "public",
 				Tables: []schema.Table{
@@ -485,7 +489,7 @@ var (
 			ALTER TABLE foobar ADD CONSTRAINT foobar_id_check CHECK (id > 0) NOT VALID;
 			CREATE UNIQUE INDEX foobar_idx ON foobar(content);
 		`},
-			expectedHash: "6518bbfe220d4f16",
+			expectedHash: "55df2eddc4d0d83",
 			expectedSchema: schema.Schema{
 				Name: "public",
 				Tables: []schema.Table{
@@ -634,7 +638,7 @@ var (
 				WHEN (OLD.* IS DISTINCT FROM NEW.*)
 				EXECUTE PROCEDURE test.increment_version();
 		`},
-			expectedHash: "a6a845ad846dc362",
+			expectedHash: "f17842ba2839c28c",
 			expectedSchema: schema.Schema{
 				Name: "public",
 				Tables: []schema.Table{
@@ -667,8 +671,9 @@ var (
 							{Name: "id", Type: "integer", Default: "", Size: 4},
 							{Name: "author", Type: "text", Default: "", Size: -1, Collation: schema.SchemaQualifiedName{SchemaName: "test", EscapedName: `"some collation"`}},
 						},
-						CheckConstraints: nil,
-						ForValues:        "FOR VALUES IN ('some author 1')",
+						CheckConstraints:       nil,
+						ForValues:              "FOR VALUES IN ('some author 1')",
+						PartitionConstraintDef: "((author IS NOT NULL) AND (author = 'some author 1'::text))",
 					},
 				},
 				Indexes: []schema.Index{
@@ -724,6 +729,46 @@ var (
 				},
 			},
 		},
+		{
+			name: "Column defaults using sequences",
+			ddl: []string{`
+			CREATE SEQUENCE foo_version_seq;
+			CREATE TABLE foo (
+				id SERIAL,
+				version BIGINT NOT NULL DEFAULT nextval('foo_version_seq')
+			);
+		`},
+			expectedHash: "c7b30648b3b8b8c5",
+			expectedSchema: schema.Schema{
+				Name: "public",
+				Tables: []schema.Table{
+					{
+						Name: "foo",
+						Columns: []schema.Column{
+							{
+								Name:    "id",
+								Type:    "integer",
+								Default: "nextval('foo_id_seq'::regclass)",
+								Size:    4,
+								DependsOnSequences: []schema.SchemaQualifiedName{
+									{SchemaName: "public", EscapedName: "\"foo_id_seq\""},
+								},
+							},
+							{
+								Name:    "version",
+								Type:    "bigint",
+								Default: "nextval('foo_version_seq'::regclass)",
+								Size:    8,
+								DependsOnSequences: []schema.SchemaQualifiedName{
+									{SchemaName: "public", EscapedName: "\"foo_version_seq\""},
+								},
+							},
+						},
+						CheckConstraints: nil,
+					},
+				},
+			},
+		},
 		{
 			name:         "Empty Schema",
 			ddl:          nil,
@@ -740,7 +785,7 @@ var (
 				value TEXT
 			);
 		`},
-			expectedHash: "9db57cf969f0a509",
+			expectedHash: "abb06fe02d12e532",
 			expectedSchema: schema.Schema{
 				Name: "public",
 				Tables: []schema.Table{
@@ -845,6 +890,106 @@ func TestIdxDefStmtToCreateIdxConcurrently(t *testing.T) {
 	}
 }
 
+func TestIdxDefStmtToCreateIdxOnOnly(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		defStmt   string
+		out       string
+		expectErr bool
+	}{
+		{
+			name:    "simple index",
+			defStmt: `CREATE INDEX foobar ON public.foobar USING btree (foo)`,
+			out:     `CREATE INDEX foobar ON ONLY public.foobar USING btree (foo)`,
+		},
+		{
+			name:    "unique index",
+			defStmt: `CREATE UNIQUE INDEX foobar ON public.foobar USING btree (foo)`,
+			out:     `CREATE UNIQUE INDEX foobar ON ONLY public.foobar USING btree (foo)`,
+		},
+		{
+			name:    "already only",
+			defStmt: `CREATE UNIQUE INDEX foobar ON ONLY public.foobar USING btree (foo)`,
+			out:     `CREATE UNIQUE INDEX foobar ON ONLY public.foobar USING btree (foo)`,
+		},
+		{
+			name:    "malicious name index",
+			defStmt: `CREATE UNIQUE INDEX "CREATE INDEX "" ON" ON public.foobar USING btree (foo)`,
+			out:     `CREATE UNIQUE INDEX "CREATE INDEX "" ON" ON ONLY public.foobar USING btree (foo)`,
+		},
+		{
+			name:      "case sensitive",
+			defStmt:   `CREATE uNIQUE INDEX foobar ON public.foobar USING btree (foo)`,
+			expectErr: true,
+		},
+		{
+			name:      "errors with random start character",
+			defStmt:   `ALTER TABLE CREATE UNIQUE INDEX foobar ON public.foobar USING btree (foo)`,
+			expectErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			out, err := schema.GetIndexDefStatement(tc.defStmt).ToCreateIndexOnOnly()
+			if tc.expectErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, tc.out, out)
+			}
+		})
+	}
+}
+
+func TestIdxDefStmtToCreateIdxOnTable(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		defStmt   string
+		out       string
+		expectErr bool
+	}{
+		{
+			name:    "simple index",
+			defStmt: `CREATE INDEX foobar ON public.foobar USING btree (foo)`,
+			out:     `CREATE INDEX "new_idx" ON "new_table" USING btree (foo)`,
+		},
+		{
+			name:    "unique index",
+			defStmt: `CREATE UNIQUE INDEX foobar ON public.foobar USING btree (foo)`,
+			out:     `CREATE UNIQUE INDEX "new_idx" ON "new_table" USING btree (foo)`,
+		},
+		{
+			name:    "only",
+			defStmt: `CREATE INDEX foobar ON ONLY public.foobar USING btree (foo)`,
+			out:     `CREATE INDEX "new_idx" ON ONLY "new_table" USING btree (foo)`,
+		},
+		{
+			name:    "malicious names",
+			defStmt: `CREATE UNIQUE INDEX "CREATE INDEX "" ON" ON public."foo USING bar" USING btree (foo) WHERE (foo > 0)`,
+			out:     `CREATE UNIQUE INDEX "new_idx" ON "new_table" USING btree (foo) WHERE (foo > 0)`,
+		},
+		{
+			name:      "case sensitive",
+			defStmt:   `CREATE uNIQUE INDEX foobar ON public.foobar USING btree (foo)`,
+			expectErr: true,
+		},
+		{
+			name:      "errors with random start character",
+			defStmt:   `ALTER TABLE CREATE UNIQUE INDEX foobar ON public.foobar USING btree (foo)`,
+			expectErr: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			out, err := schema.GetIndexDefStatement(tc.defStmt).ToCreateIndexOnTable("new_idx", "new_table")
+			if tc.expectErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, tc.out, out)
+			}
+		})
+	}
+}
+
 func TestTriggerDefStmtToCreateOrReplace(t *testing.T) {
 	for _, tc := range []struct {
 		name      string
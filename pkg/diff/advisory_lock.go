@@ -0,0 +1,135 @@
+package diff
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const (
+	// DefaultAdvisoryLockKey is "pgschema" encoded as a bigint
+	DefaultAdvisoryLockKey         int64 = 0x7067736368656d61
+	DefaultAdvisoryLockWaitTimeout       = 30 * time.Second
+
+	advisoryLockPollInterval = 100 * time.Millisecond
+)
+
+// ErrAdvisoryLockNotAcquired is returned when the advisory lock is held by another session for longer than the wait
+// timeout, e.g., because another migration is being applied
+var ErrAdvisoryLockNotAcquired = fmt.Errorf("advisory lock not acquired")
+
+type (
+	advisoryLockOptions struct {
+		key         int64
+		waitTimeout time.Duration
+	}
+
+	AdvisoryLockOpt func(opts *advisoryLockOptions)
+)
+
+// WithAdvisoryLockKey sets the key of the advisory lock. Plans applied with the same key are serialized
+func WithAdvisoryLockKey(key int64) AdvisoryLockOpt {
+	return func(opts *advisoryLockOptions) {
+		opts.key = key
+	}
+}
+
+// WithAdvisoryLockWaitTimeout sets the max time to wait for another session to release the advisory lock. A timeout
+// of 0 implies the lock is only acquired if it is not held
+func WithAdvisoryLockWaitTimeout(waitTimeout time.Duration) AdvisoryLockOpt {
+	return func(opts *advisoryLockOptions) {
+		opts.waitTimeout = waitTimeout
+	}
+}
+
+// WithAdvisoryLock configures the plan execution to take a session-level advisory lock before the schema hash is
+// verified and to hold it until the plan finishes executing, such that plans applied concurrently, e.g., by two CI
+// jobs, do not interleave their statements. If the lock is held by another session for longer than the wait timeout,
+// ErrAdvisoryLockNotAcquired is returned, identifying the session holding the lock. Dry runs also acquire the lock
+func WithAdvisoryLock(opts ...AdvisoryLockOpt) ApplyOpt {
+	return func(applyOpts *applyOptions) {
+		options := advisoryLockOptions{
+			key:         DefaultAdvisoryLockKey,
+			waitTimeout: DefaultAdvisoryLockWaitTimeout,
+		}
+		for _, opt := range opts {
+			opt(&options)
+		}
+		applyOpts.advisoryLock = &options
+	}
+}
+
+// acquireAdvisoryLock polls for the advisory lock until the wait timeout elapses. Polling is used rather than
+// pg_advisory_lock, such that the session holding the lock can be identified if it's not released in time
+func acquireAdvisoryLock(ctx context.Context, conn *sql.Conn, opts advisoryLockOptions) error {
+	deadline := time.Now().Add(opts.waitTimeout)
+	for {
+		var acquired bool
+		if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", opts.key).Scan(&acquired); err != nil {
+			return fmt.Errorf("acquiring advisory lock: %w", err)
+		}
+		if acquired {
+			return nil
+		}
+		if !time.Now().Before(deadline) {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(advisoryLockPollInterval):
+		}
+	}
+
+	holder, err := getAdvisoryLockHolder(ctx, conn, opts.key)
+	if err != nil {
+		return fmt.Errorf("advisory lock %d not acquired after %s and failed to identify its holder: %s: %w",
+			opts.key, opts.waitTimeout, err, ErrAdvisoryLockNotAcquired)
+	}
+	return fmt.Errorf("advisory lock %d not acquired after %s. It is held by %s: %w",
+		opts.key, opts.waitTimeout, holder, ErrAdvisoryLockNotAcquired)
+}
+
+func releaseAdvisoryLock(ctx context.Context, conn *sql.Conn, key int64) error {
+	var released bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_advisory_unlock($1)", key).Scan(&released); err != nil {
+		return fmt.Errorf("releasing advisory lock: %w", err)
+	}
+	if !released {
+		return fmt.Errorf("releasing advisory lock: lock %d was not held", key)
+	}
+	return nil
+}
+
+// getAdvisoryLockHolder describes the session holding the advisory lock. A bigint advisory lock key is split across
+// the classid (high bits) and objid (low bits) columns of pg_locks
+func getAdvisoryLockHolder(ctx context.Context, conn *sql.Conn, key int64) (string, error) {
+	var pid int
+	var user, applicationName, clientAddr string
+	var backendStart time.Time
+	if err := conn.QueryRowContext(ctx, `
+		SELECT activity.pid,
+		       COALESCE(activity.usename::TEXT, ''),
+		       COALESCE(activity.application_name, ''),
+		       COALESCE(host(activity.client_addr), ''),
+		       activity.backend_start
+		FROM pg_catalog.pg_locks AS locks
+		INNER JOIN pg_catalog.pg_stat_activity AS activity ON activity.pid = locks.pid
+		WHERE locks.locktype = 'advisory'
+		  AND locks.granted
+		  AND locks.database = (SELECT oid FROM pg_catalog.pg_database WHERE datname = current_database())
+		  AND locks.classid::BIGINT = ($1::BIGINT >> 32) & 4294967295
+		  AND locks.objid::BIGINT = $1::BIGINT & 4294967295
+		  AND locks.objsubid = 1
+		LIMIT 1
+	`, key).Scan(&pid, &user, &applicationName, &clientAddr, &backendStart); err == sql.ErrNoRows {
+		// The lock was released after the last attempt to acquire it
+		return "no session", nil
+	} else if err != nil {
+		return "", fmt.Errorf("querying advisory lock holder: %w", err)
+	}
+	return fmt.Sprintf("pid %d (user %q, application %q, client %q, connected at %s)",
+		pid, user, applicationName, clientAddr, backendStart.Format(time.RFC3339),
+	), nil
+}
@@ -0,0 +1,69 @@
+package diff
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+)
+
+// createIndexConcurrentlyRegex matches CREATE INDEX CONCURRENTLY statements, capturing the name of the index and the
+// schema of the table, if the table is schema-qualified. Indexes are always created in the schema of their table
+var createIndexConcurrentlyRegex = regexp.MustCompile(
+	`^\s*CREATE (?:UNIQUE )?INDEX CONCURRENTLY (?:IF NOT EXISTS )?(?P<index>"(?:[^"]|"")*"|[^ "]+) ON (?:ONLY )?(?:(?P<schema>"(?:[^"]|"")*"|[^ ".]+)\.)?`,
+)
+
+// WithInvalidIndexCleanup configures the plan execution to drop the invalid index left behind when a
+// CREATE INDEX CONCURRENTLY statement fails, e.g., because it exceeded its statement timeout. Otherwise, the invalid
+// index causes the schema hash verification of a resumed plan to fail and can't be handled when generating a new plan.
+// The index is dropped concurrently, and the cleanup is logged and included in the returned error
+func WithInvalidIndexCleanup() ApplyOpt {
+	return func(opts *applyOptions) {
+		opts.cleanupInvalidIndexes = true
+	}
+}
+
+// getIndexCreatedConcurrently returns the name of the index created by the statement, schema-qualified if its table
+// is. Returns false if the statement is not a CREATE INDEX CONCURRENTLY statement
+func getIndexCreatedConcurrently(stmt Statement) (string, bool) {
+	matches := createIndexConcurrentlyRegex.FindStringSubmatch(stmt.DDL)
+	if matches == nil {
+		return "", false
+	}
+	indexName := matches[createIndexConcurrentlyRegex.SubexpIndex("index")]
+	if schemaName := matches[createIndexConcurrentlyRegex.SubexpIndex("schema")]; len(schemaName) > 0 {
+		indexName = fmt.Sprintf("%s.%s", schemaName, indexName)
+	}
+	return indexName, true
+}
+
+// CleanupInvalidIndex drops the index created by the statement if it is a CREATE INDEX CONCURRENTLY statement that
+// failed and left behind an invalid index, e.g., because it was cancelled. Returns the name of the dropped index or
+// false if there was no invalid index to drop
+func CleanupInvalidIndex(ctx context.Context, conn *sql.Conn, stmt Statement) (string, bool, error) {
+	indexName, ok := getIndexCreatedConcurrently(stmt)
+	if !ok {
+		return "", false, nil
+	}
+
+	var invalidIndexName string
+	if err := conn.QueryRowContext(ctx, `
+		SELECT idx.indexrelid::regclass::TEXT
+		FROM pg_catalog.pg_index AS idx
+		WHERE idx.indexrelid = to_regclass($1) AND NOT idx.indisvalid
+	`, indexName).Scan(&invalidIndexName); err == sql.ErrNoRows {
+		// The statement failed before the index was created, or the index is valid
+		return "", false, nil
+	} else if err != nil {
+		return "", false, fmt.Errorf("checking if index %s is invalid: %w", indexName, err)
+	}
+
+	if err := executeStatementWithTimeouts(ctx, conn, Statement{
+		DDL:         fmt.Sprintf("DROP INDEX CONCURRENTLY %s", invalidIndexName),
+		Timeout:     statementTimeoutConcurrentIndexDrop,
+		LockTimeout: statementTimeoutConcurrentIndexDrop,
+	}, "", false, nil); err != nil {
+		return "", false, fmt.Errorf("dropping invalid index %s: %w", invalidIndexName, err)
+	}
+	return invalidIndexName, true, nil
+}
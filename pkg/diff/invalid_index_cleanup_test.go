@@ -0,0 +1,51 @@
+package diff
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetIndexCreatedConcurrently(t *testing.T) {
+	for _, tc := range []struct {
+		name              string
+		ddl               string
+		expectedIndexName string
+		expectedOk        bool
+	}{
+		{
+			name:              "Unqualified table",
+			ddl:               "CREATE INDEX CONCURRENTLY some_idx ON foobar USING btree (foo)",
+			expectedIndexName: "some_idx",
+			expectedOk:        true,
+		},
+		{
+			name:              "Schema-qualified table",
+			ddl:               "CREATE UNIQUE INDEX CONCURRENTLY some_idx ON public.foobar USING btree (foo)",
+			expectedIndexName: "public.some_idx",
+			expectedOk:        true,
+		},
+		{
+			name:              "Quoted identifiers",
+			ddl:               `CREATE INDEX CONCURRENTLY "Some ""idx""" ON ONLY "Some schema"."foobar" USING btree (foo)`,
+			expectedIndexName: `"Some schema"."Some ""idx"""`,
+			expectedOk:        true,
+		},
+		{
+			name:       "Not concurrent",
+			ddl:        "CREATE INDEX some_idx ON public.foobar USING btree (foo)",
+			expectedOk: false,
+		},
+		{
+			name:       "Reindex",
+			ddl:        `REINDEX INDEX CONCURRENTLY "some_idx"`,
+			expectedOk: false,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			indexName, ok := getIndexCreatedConcurrently(Statement{DDL: tc.ddl})
+			assert.Equal(t, tc.expectedOk, ok)
+			assert.Equal(t, tc.expectedIndexName, indexName)
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package diff
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/stripe/pg-schema-diff/pkg/log"
+)
+
+// lockNotAvailableSQLState is the SQLSTATE of the error returned when a statement exceeds the lock_timeout
+const lockNotAvailableSQLState = "55P03"
+
+// LockTimeoutRetryPolicy configures how statements that fail to acquire a lock within the lock_timeout are retried.
+// On a busy database, a short lock_timeout retried many times blocks other queries for less time than a long
+// lock_timeout, since queued lock requests block every query that needs a conflicting lock
+type LockTimeoutRetryPolicy struct {
+	// MaxRetries is the max number of times a statement is retried after it fails to acquire a lock
+	MaxRetries int
+	// InitialBackoff is the backoff before the first retry. The backoff doubles with every retry up to MaxBackoff, and
+	// a random jitter of up to half the backoff is subtracted from it
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+var DefaultLockTimeoutRetryPolicy = LockTimeoutRetryPolicy{
+	MaxRetries:     10,
+	InitialBackoff: 500 * time.Millisecond,
+	MaxBackoff:     30 * time.Second,
+}
+
+// WithLockTimeoutRetries configures the plan execution to retry each statement that fails to acquire a lock within the
+// lock_timeout, i.e., with SQLSTATE 55P03, according to the policy. Statements grouped into a transaction are retried
+// as a whole. Other errors are not retried. Before a statement is retried, the schema hash is verified to be unchanged,
+// since statements that can't run in a transaction, e.g., CREATE INDEX CONCURRENTLY, might leave behind partial
+// changes. Every failed attempt is logged
+func WithLockTimeoutRetries(policy LockTimeoutRetryPolicy) ApplyOpt {
+	return func(opts *applyOptions) {
+		opts.lockTimeoutRetryPolicy = &policy
+	}
+}
+
+// WithApplyLogger configures the plan execution to use the provided logger instead of the default
+func WithApplyLogger(logger log.Logger) ApplyOpt {
+	return func(opts *applyOptions) {
+		opts.logger = logger
+	}
+}
+
+// applyStatementGroupWithRetries applies the statement group, retrying it according to the lock timeout retry policy.
+// A transactional group is retried as a whole, since the failed statement rolls back the whole transaction
+func applyStatementGroupWithRetries(
+	ctx context.Context,
+	conn *sql.Conn,
+	plan Plan,
+	planID string,
+	group statementGroup,
+	applyStartIdx int,
+	expectedSchemaHash string,
+	defaultLockTimeout string,
+	applyOptions *applyOptions,
+) (string, error) {
+	policy := applyOptions.lockTimeoutRetryPolicy
+	var random *rand.Rand
+	for retry := 0; ; retry++ {
+		schemaHash, err := applyStatementGroup(ctx, conn, plan, planID, group, applyStartIdx, expectedSchemaHash, defaultLockTimeout, applyOptions)
+		if err == nil || policy == nil || !isLockNotAvailableErr(err) {
+			return schemaHash, err
+		}
+		if retry >= policy.MaxRetries {
+			applyOptions.logger.Errorf("%s failed to acquire a lock (attempt %d of %d). Not retrying: %s",
+				group, retry+1, policy.MaxRetries+1, err)
+			return "", err
+		}
+
+		if random == nil {
+			// Seed the jitter per plan execution, such that concurrent executions don't retry in lockstep
+			random = rand.New(rand.NewSource(time.Now().UnixNano()))
+		}
+		backoff := policy.getBackoff(retry, random)
+		applyOptions.logger.Errorf("%s failed to acquire a lock (attempt %d of %d). Retrying in %s: %s",
+			group, retry+1, policy.MaxRetries+1, backoff, err)
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		if err := assertSchemaHash(ctx, conn, expectedSchemaHash); err != nil {
+			return "", fmt.Errorf("verifying schema before retrying: %w", err)
+		}
+	}
+}
+
+func (p LockTimeoutRetryPolicy) getBackoff(retry int, random *rand.Rand) time.Duration {
+	backoff := p.InitialBackoff
+	for i := 0; i < retry && backoff < p.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > p.MaxBackoff {
+		backoff = p.MaxBackoff
+	}
+	if backoff <= 0 {
+		return 0
+	}
+	return backoff - time.Duration(random.Int63n(int64(backoff)/2+1))
+}
+
+func isLockNotAvailableErr(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == lockNotAvailableSQLState
+}
@@ -0,0 +1,46 @@
+package diff
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLockTimeoutRetryPolicyGetBackoff(t *testing.T) {
+	policy := LockTimeoutRetryPolicy{
+		MaxRetries:     10,
+		InitialBackoff: time.Second,
+		MaxBackoff:     5 * time.Second,
+	}
+	random := rand.New(rand.NewSource(0))
+	for _, tc := range []struct {
+		retry      int
+		maxBackoff time.Duration
+	}{
+		{retry: 0, maxBackoff: time.Second},
+		{retry: 1, maxBackoff: 2 * time.Second},
+		{retry: 2, maxBackoff: 4 * time.Second},
+		{retry: 3, maxBackoff: 5 * time.Second},
+		{retry: 100, maxBackoff: 5 * time.Second},
+	} {
+		t.Run(fmt.Sprintf("retry %d", tc.retry), func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				backoff := policy.getBackoff(tc.retry, random)
+				assert.LessOrEqual(t, backoff, tc.maxBackoff)
+				assert.GreaterOrEqual(t, backoff, tc.maxBackoff/2)
+			}
+		})
+	}
+
+	assert.Zero(t, LockTimeoutRetryPolicy{}.getBackoff(3, random))
+}
+
+func TestIsLockNotAvailableErr(t *testing.T) {
+	assert.True(t, isLockNotAvailableErr(fmt.Errorf("executing migration statement: %w", &pgconn.PgError{Code: "55P03"})))
+	assert.False(t, isLockNotAvailableErr(fmt.Errorf("executing migration statement: %w", &pgconn.PgError{Code: "57014"})))
+	assert.False(t, isLockNotAvailableErr(fmt.Errorf("some error")))
+}
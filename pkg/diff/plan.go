@@ -12,6 +12,7 @@ const (
 	MigrationHazardTypeAcquiresAccessExclusiveLock MigrationHazardType = "ACQUIRES_ACCESS_EXCLUSIVE_LOCK"
 	MigrationHazardTypeAcquiresShareLock           MigrationHazardType = "ACQUIRES_SHARE_LOCK"
 	MigrationHazardTypeDeletesData                 MigrationHazardType = "DELETES_DATA"
+	MigrationHazardTypeFailsOnExistingData         MigrationHazardType = "FAILS_ON_EXISTING_DATA"
 	MigrationHazardTypeHasUntrackableDependencies  MigrationHazardType = "HAS_UNTRACKABLE_DEPENDENCIES"
 	MigrationHazardTypeIndexBuild                  MigrationHazardType = "INDEX_BUILD"
 	MigrationHazardTypeIndexDropped                MigrationHazardType = "INDEX_DROPPED"
@@ -35,6 +36,14 @@ type Statement struct {
 	// the session-level statement_timeout to this value before executing the statement. A transaction-level statement_timeout
 	// will not work since building indexes concurrently cannot be done in a transaction
 	Timeout time.Duration
+	// The lock_timeout to apply to this statement, i.e., the max time the statement waits to acquire a lock. If 0, the
+	// executor's default lock_timeout is used. Like the statement_timeout, it must be set at the session-level
+	LockTimeout time.Duration
+	// IsNonTransactional is true if the statement must not be grouped into a transaction with other statements, either
+	// because it can't run in a transaction, e.g., CREATE INDEX CONCURRENTLY or a backfill that commits in batches, or
+	// because it would hold the locks acquired by the preceding statements for a long time, e.g., VALIDATE CONSTRAINT.
+	// ApplyPlan executes consecutive transactional statements in a single transaction
+	IsNonTransactional bool
 	// The hazards this statement poses
 	Hazards []MigrationHazard
 }
@@ -49,7 +58,8 @@ type Plan struct {
 	Statements []Statement
 	// CurrentSchemaHash is the hash of the current schema, schema A. If you serialize this plans somewhere and
 	// plan on running them later, you should verify that the current schema hash matches the current schema hash.
-	// To get the current schema hash, you can use schema.GetPublicSchemaHash(ctx, conn)
+	// To get the current schema hash, you can use schema.GetPublicSchemaHash(ctx, conn). ApplyPlan verifies the hash
+	// for you
 	CurrentSchemaHash string
 }
 
@@ -66,6 +76,19 @@ func (p Plan) ApplyStatementTimeoutModifier(regex *regexp.Regexp, timeout time.D
 	return p
 }
 
+// ApplyLockTimeoutModifier applies the given lock timeout to all statements that match the given regex
+func (p Plan) ApplyLockTimeoutModifier(regex *regexp.Regexp, lockTimeout time.Duration) Plan {
+	var modifiedStmts []Statement
+	for _, stmt := range p.Statements {
+		if regex.MatchString(stmt.DDL) {
+			stmt.LockTimeout = lockTimeout
+		}
+		modifiedStmts = append(modifiedStmts, stmt)
+	}
+	p.Statements = modifiedStmts
+	return p
+}
+
 // InsertStatement inserts the given statement at the given index. If index is equal to the length of the statements,
 // it will append the statement to the end of the statement in the plan
 func (p Plan) InsertStatement(index int, statement Statement) (Plan, error) {
@@ -0,0 +1,540 @@
+package diff
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/stripe/pg-schema-diff/internal/schema"
+	"github.com/stripe/pg-schema-diff/pkg/log"
+)
+
+var (
+	// ErrSchemaChanged is returned when the schema of the database does not match the schema the plan expects, e.g.,
+	// because the schema was changed after the plan was generated or by another migration running concurrently
+	ErrSchemaChanged = fmt.Errorf("schema changed")
+	// ErrHazardsNotAllowed is returned when the plan contains hazards that are not allowed
+	ErrHazardsNotAllowed = fmt.Errorf("hazards not allowed")
+)
+
+type (
+	// BeforeStatementHook is called before the statement at stmtIdx is executed. Returning an error aborts the plan
+	// execution before the statement is executed
+	BeforeStatementHook func(ctx context.Context, stmtIdx int, stmt Statement) error
+	// AfterStatementHook is called after the statement at stmtIdx is successfully executed. Returning an error aborts
+	// the plan execution
+	AfterStatementHook func(ctx context.Context, stmtIdx int, stmt Statement, duration time.Duration) error
+	// StatementExecutionHook is called with executing set to true right before the statement at stmtIdx is sent to the
+	// database, i.e., after its timeouts are set, and with executing set to false once the database returns. Unlike the
+	// before and after statement hooks, no other queries run on the connection in between, so the hook can be used to
+	// track when the statement itself can be cancelled, e.g., via pg_cancel_backend
+	StatementExecutionHook func(stmtIdx int, executing bool)
+
+	applyOptions struct {
+		recheckSchemaHashBetweenStatements bool
+		// lockTimeout is only set on the session if setLockTimeout is true
+		setLockTimeout bool
+		lockTimeout    time.Duration
+		// allowedHazardTypes is only enforced if checkHazards is true
+		checkHazards         bool
+		allowedHazardTypes   map[MigrationHazardType]bool
+		beforeStatementHooks []BeforeStatementHook
+		afterStatementHooks  []AfterStatementHook
+		executionHooks       []StatementExecutionHook
+		dryRun               bool
+		progressLedger       *progressLedger
+		resume               bool
+		history              *migrationHistory
+		advisoryLock         *advisoryLockOptions
+		// lockTimeoutRetryPolicy is nil if statements that fail to acquire a lock are not retried
+		lockTimeoutRetryPolicy *LockTimeoutRetryPolicy
+		logger                 log.Logger
+		cleanupInvalidIndexes  bool
+	}
+
+	ApplyOpt func(opts *applyOptions)
+)
+
+// WithSchemaHashRecheckBetweenStatements configures the plan execution to also verify the schema hash between
+// transactions, such that concurrent DDL, e.g., from another deploy, aborts the migration with ErrSchemaChanged. The
+// schema is fetched after each transaction and before the next one, which adds two rounds of catalog queries per
+// transaction. Statements within a transaction are not rechecked, since the transaction holds the locks it acquired.
+// By default, the schema hash is only verified before the first statement
+func WithSchemaHashRecheckBetweenStatements() ApplyOpt {
+	return func(opts *applyOptions) {
+		opts.recheckSchemaHashBetweenStatements = true
+	}
+}
+
+// WithLockTimeout configures the plan execution to set the session-level lock_timeout, i.e., the max time a statement
+// waits to acquire a lock, for statements without a LockTimeout. A timeout of 0 disables the lock timeout. Without this
+// option, those statements use the session's lock_timeout from before the plan was applied
+func WithLockTimeout(lockTimeout time.Duration) ApplyOpt {
+	return func(opts *applyOptions) {
+		opts.setLockTimeout = true
+		opts.lockTimeout = lockTimeout
+	}
+}
+
+// WithAllowedHazards configures the plan execution to fail with ErrHazardsNotAllowed, before any statements are
+// executed, if the plan contains hazards whose types are not in the given list. Without this option, all hazards are
+// allowed
+func WithAllowedHazards(hazardTypes ...MigrationHazardType) ApplyOpt {
+	return func(opts *applyOptions) {
+		opts.checkHazards = true
+		if opts.allowedHazardTypes == nil {
+			opts.allowedHazardTypes = make(map[MigrationHazardType]bool)
+		}
+		for _, hazardType := range hazardTypes {
+			opts.allowedHazardTypes[hazardType] = true
+		}
+	}
+}
+
+// WithBeforeStatementHook configures the plan execution to call the hook before each statement is executed, e.g., to
+// log the statement. Hooks are called in the order they are added
+func WithBeforeStatementHook(hook BeforeStatementHook) ApplyOpt {
+	return func(opts *applyOptions) {
+		opts.beforeStatementHooks = append(opts.beforeStatementHooks, hook)
+	}
+}
+
+// WithAfterStatementHook configures the plan execution to call the hook after each statement is executed. Hooks are
+// called in the order they are added. A statement executed in a transaction is only committed once the rest of its
+// transaction succeeds, so the hook might be called for a statement that is later rolled back
+func WithAfterStatementHook(hook AfterStatementHook) ApplyOpt {
+	return func(opts *applyOptions) {
+		opts.afterStatementHooks = append(opts.afterStatementHooks, hook)
+	}
+}
+
+// WithStatementExecutionHook configures the plan execution to call the hook around the execution of each statement.
+// Hooks are called in the order they are added
+func WithStatementExecutionHook(hook StatementExecutionHook) ApplyOpt {
+	return func(opts *applyOptions) {
+		opts.executionHooks = append(opts.executionHooks, hook)
+	}
+}
+
+// WithDryRun configures the plan execution to verify the schema hash and the hazards and to call the before-statement
+// hooks, without executing any statements. The after-statement hooks are not called
+func WithDryRun() ApplyOpt {
+	return func(opts *applyOptions) {
+		opts.dryRun = true
+	}
+}
+
+// ApplyPlan executes the plan's statements against the database. The connection is expected to be connected to the
+// database the plan was generated for.
+//
+// Consecutive statements that can run in a transaction, i.e., that are not IsNonTransactional, are executed in a single
+// transaction, with the transaction-level statement_timeout and lock_timeout set to each statement's timeouts before it
+// is executed. If one of them fails, the whole transaction is rolled back. Non-transactional statements are executed
+// on their own, with the session-level timeouts set to the statement's timeouts, which are not reset afterwards.
+//
+// Before running any statements, the schema of the database is hashed and compared against the plan's
+// CurrentSchemaHash. If they differ, ErrSchemaChanged is returned and no statements are run. Use
+// WithSchemaHashRecheckBetweenStatements to also verify the schema between transactions.
+//
+// If a statement fails, the statements before its transaction will have been applied. With WithProgressLedger, the
+// plan can then be resumed from the failed transaction via WithResume.
+//
+// If ctx is cancelled, no further statements are started, and the transaction of the statement that was about to start
+// is rolled back. A statement that is already running is not interrupted, since cancelling a query via the driver might
+// close the connection, so it can't be rolled back or recorded. To stop it, cancel it from another connection, e.g.,
+// via pg_cancel_backend. Rolling back, cleaning up, recording the progress and the history and releasing the advisory
+// lock are done even if ctx is cancelled
+func ApplyPlan(ctx context.Context, conn *sql.Conn, plan Plan, opts ...ApplyOpt) (retErr error) {
+	applyOptions := &applyOptions{
+		logger: log.SimpleLogger(),
+	}
+	for _, opt := range opts {
+		opt(applyOptions)
+	}
+
+	if applyOptions.checkHazards {
+		if err := assertHazardsAllowed(plan, applyOptions.allowedHazardTypes); err != nil {
+			return err
+		}
+	}
+	if applyOptions.advisoryLock != nil {
+		if err := acquireAdvisoryLock(ctx, conn, *applyOptions.advisoryLock); err != nil {
+			return err
+		}
+		defer func() {
+			if err := releaseAdvisoryLock(withoutCancel(ctx), conn, applyOptions.advisoryLock.key); err != nil && retErr == nil {
+				retErr = err
+			}
+		}()
+	}
+
+	var planID string
+	if applyOptions.progressLedger != nil {
+		var err error
+		planID, err = getPlanID(plan)
+		if err != nil {
+			return fmt.Errorf("getting plan id: %w", err)
+		}
+	} else if applyOptions.resume {
+		return fmt.Errorf("resuming requires a progress ledger")
+	}
+
+	startIdx, expectedSchemaHash := 0, plan.CurrentSchemaHash
+	if applyOptions.resume {
+		var err error
+		startIdx, expectedSchemaHash, err = applyOptions.progressLedger.getResumePoint(ctx, conn, planID, plan)
+		if err != nil {
+			return fmt.Errorf("getting resume point: %w", err)
+		}
+	}
+
+	if applyOptions.dryRun {
+		if err := assertSchemaHash(ctx, conn, expectedSchemaHash); err != nil {
+			return fmt.Errorf("verifying schema: %w", err)
+		}
+		for i := startIdx; i < len(plan.Statements); i++ {
+			if err := runBeforeStatementHooks(ctx, applyOptions.beforeStatementHooks, i, plan.Statements[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var historyRecordID int64
+	if applyOptions.history != nil {
+		if err := applyOptions.history.createIfNotExists(ctx, conn); err != nil {
+			return err
+		}
+		var err error
+		historyRecordID, err = applyOptions.history.start(ctx, conn, plan, expectedSchemaHash, applyOptions)
+		if err != nil {
+			return err
+		}
+	}
+
+	applyErr := applyStatements(ctx, conn, plan, planID, startIdx, expectedSchemaHash, applyOptions)
+	if applyOptions.history != nil {
+		if err := applyOptions.history.finish(withoutCancel(ctx), conn, historyRecordID, applyErr); err != nil {
+			if applyErr != nil {
+				return fmt.Errorf("%w (%s)", applyErr, err)
+			}
+			return err
+		}
+	}
+	return applyErr
+}
+
+// applyStatements executes the plan's statements from startIdx onwards, verifying the schema hash before them
+func applyStatements(ctx context.Context, conn *sql.Conn, plan Plan, planID string, startIdx int, expectedSchemaHash string, applyOptions *applyOptions) error {
+	if applyOptions.progressLedger != nil && !applyOptions.resume {
+		if err := applyOptions.progressLedger.createIfNotExists(ctx, conn); err != nil {
+			return err
+		}
+		if err := applyOptions.progressLedger.start(ctx, conn, planID, plan); err != nil {
+			return fmt.Errorf("recording plan in progress ledger: %w", err)
+		}
+	}
+
+	// Statements without a lock timeout use the lock timeout configured via WithLockTimeout or otherwise the session's
+	// lock timeout from before the plan was applied
+	defaultLockTimeout := fmt.Sprintf("%dms", applyOptions.lockTimeout.Milliseconds())
+	if !applyOptions.setLockTimeout {
+		if err := conn.QueryRowContext(ctx, "SELECT current_setting('lock_timeout')").Scan(&defaultLockTimeout); err != nil {
+			return fmt.Errorf("getting lock timeout: %w", err)
+		}
+	}
+
+	for _, group := range groupStatements(plan.Statements, startIdx) {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("stopping before %s: %w", group, err)
+		}
+		var err error
+		expectedSchemaHash, err = applyStatementGroupWithRetries(ctx, conn, plan, planID, group, startIdx, expectedSchemaHash, defaultLockTimeout, applyOptions)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// statementGroup is a range of consecutive statements [startIdx, endIdx) that are executed together. Transactional
+// groups are executed in a single transaction, such that if one of the statements fails, the whole group is rolled
+// back. Statements that can't run in a transaction are executed in their own non-transactional group
+type statementGroup struct {
+	startIdx        int
+	endIdx          int
+	isTransactional bool
+}
+
+func (g statementGroup) String() string {
+	if g.endIdx-g.startIdx == 1 {
+		return fmt.Sprintf("statement %d", g.startIdx)
+	}
+	return fmt.Sprintf("statements %d to %d", g.startIdx, g.endIdx-1)
+}
+
+// groupStatements groups the statements from startIdx onwards into transactional groups of consecutive statements
+// that can run in a transaction and non-transactional groups of a single statement
+func groupStatements(stmts []Statement, startIdx int) []statementGroup {
+	var groups []statementGroup
+	for i := startIdx; i < len(stmts); i++ {
+		if stmts[i].IsNonTransactional {
+			groups = append(groups, statementGroup{startIdx: i, endIdx: i + 1})
+			continue
+		}
+		if len(groups) > 0 && groups[len(groups)-1].isTransactional && groups[len(groups)-1].endIdx == i {
+			groups[len(groups)-1].endIdx++
+			continue
+		}
+		groups = append(groups, statementGroup{startIdx: i, endIdx: i + 1, isTransactional: true})
+	}
+	return groups
+}
+
+// applyStatementGroup executes the group's statements, verifying the schema hash before them, and returns the schema
+// hash after the group. If the group is transactional, the statements and their progress ledger entries are committed
+// together, and if one of them fails, the group is rolled back and the failed statement is recorded in the ledger
+func applyStatementGroup(
+	ctx context.Context,
+	conn *sql.Conn,
+	plan Plan,
+	planID string,
+	group statementGroup,
+	applyStartIdx int,
+	expectedSchemaHash string,
+	defaultLockTimeout string,
+	applyOptions *applyOptions,
+) (string, error) {
+	if group.isTransactional {
+		if _, err := conn.ExecContext(ctx, "BEGIN"); err != nil {
+			return "", fmt.Errorf("beginning transaction for %s: %w", group, err)
+		}
+	}
+
+	schemaHash, failedStmtIdx, err := applyStatementGroupStatements(ctx, conn, plan, planID, group, applyStartIdx, expectedSchemaHash, defaultLockTimeout, applyOptions)
+	// Once the statements are executed, the transaction is committed or rolled back even if ctx is cancelled
+	ctx = withoutCancel(ctx)
+	if err == nil && group.isTransactional {
+		if _, commitErr := conn.ExecContext(ctx, "COMMIT"); commitErr != nil {
+			err = fmt.Errorf("committing transaction for %s: %w", group, commitErr)
+			failedStmtIdx = group.endIdx - 1
+		}
+	}
+	if err != nil {
+		if group.isTransactional {
+			if _, rollbackErr := conn.ExecContext(ctx, "ROLLBACK"); rollbackErr != nil {
+				return "", fmt.Errorf("%w (rolling back transaction for %s: %s)", err, group, rollbackErr)
+			}
+		}
+		if applyOptions.cleanupInvalidIndexes && failedStmtIdx >= 0 {
+			if droppedIndexName, dropped, cleanupErr := CleanupInvalidIndex(ctx, conn, plan.Statements[failedStmtIdx]); cleanupErr != nil {
+				err = fmt.Errorf("%w (cleaning up invalid index: %s)", err, cleanupErr)
+			} else if dropped {
+				applyOptions.logger.Errorf("statement %d failed and left behind the invalid index %s. Dropped the index",
+					failedStmtIdx, droppedIndexName)
+				err = fmt.Errorf("%w (dropped the invalid index %s left behind by the statement)", err, droppedIndexName)
+			}
+		}
+		if applyOptions.progressLedger != nil && failedStmtIdx >= 0 {
+			if ledgerErr := applyOptions.progressLedger.markFailed(ctx, conn, planID, failedStmtIdx, err); ledgerErr != nil {
+				return "", fmt.Errorf("%w (%s)", err, ledgerErr)
+			}
+		}
+		return "", err
+	}
+	return schemaHash, nil
+}
+
+// applyStatementGroupStatements executes the group's statements. If a statement fails, its index is returned alongside
+// the error. Otherwise, -1 is returned
+func applyStatementGroupStatements(
+	ctx context.Context,
+	conn *sql.Conn,
+	plan Plan,
+	planID string,
+	group statementGroup,
+	applyStartIdx int,
+	expectedSchemaHash string,
+	defaultLockTimeout string,
+	applyOptions *applyOptions,
+) (string, int, error) {
+	for i := group.startIdx; i < group.endIdx; i++ {
+		stmt := plan.Statements[i]
+		if err := ctx.Err(); err != nil {
+			return "", -1, fmt.Errorf("stopping before statement %d: %w", i, err)
+		}
+		if i == applyStartIdx || (i == group.startIdx && applyOptions.recheckSchemaHashBetweenStatements) {
+			if err := assertSchemaHash(ctx, conn, expectedSchemaHash); err != nil {
+				return "", -1, fmt.Errorf("verifying schema before statement %d: %w", i, err)
+			}
+		}
+		if err := runBeforeStatementHooks(ctx, applyOptions.beforeStatementHooks, i, stmt); err != nil {
+			return "", -1, err
+		}
+
+		// Once the statement is started, it and its bookkeeping run to completion even if ctx is cancelled
+		stmtCtx := withoutCancel(ctx)
+		if applyOptions.progressLedger != nil {
+			if err := applyOptions.progressLedger.markRunning(stmtCtx, conn, planID, i); err != nil {
+				return "", -1, err
+			}
+		}
+		start := time.Now()
+		onExecute := func(executing bool) {
+			for _, hook := range applyOptions.executionHooks {
+				hook(i, executing)
+			}
+		}
+		if err := executeStatementWithTimeouts(stmtCtx, conn, stmt, defaultLockTimeout, group.isTransactional, onExecute); err != nil {
+			return "", i, fmt.Errorf("statement %d: %w", i, err)
+		}
+		duration := time.Since(start)
+
+		// The schema is only hashed after the group's last statement. The progress ledger records the hash after each
+		// group, since a resumed plan always resumes from the start of a group and verifies the schema against it.
+		// Likewise, a group retried after a lock timeout verifies the schema against the hash after the previous group
+		schemaHashAfter := ""
+		if i == group.endIdx-1 && shouldHashSchemaAfterGroup(plan, group, applyOptions) {
+			var err error
+			schemaHashAfter, err = getSchemaHash(stmtCtx, conn)
+			if err != nil {
+				return "", -1, fmt.Errorf("getting schema hash after statement %d: %w", i, err)
+			}
+			expectedSchemaHash = schemaHashAfter
+		}
+		if applyOptions.progressLedger != nil {
+			if err := applyOptions.progressLedger.markSucceeded(stmtCtx, conn, planID, i, schemaHashAfter); err != nil {
+				return "", -1, err
+			}
+		}
+		for _, hook := range applyOptions.afterStatementHooks {
+			if err := hook(ctx, i, stmt, duration); err != nil {
+				return "", -1, fmt.Errorf("after statement %d hook: %w", i, err)
+			}
+		}
+	}
+	return expectedSchemaHash, -1, nil
+}
+
+// shouldHashSchemaAfterGroup returns true if the schema hash after the group is needed, i.e., to verify the schema
+// before the next group is executed or retried, or to record it in the progress ledger
+func shouldHashSchemaAfterGroup(plan Plan, group statementGroup, applyOptions *applyOptions) bool {
+	if applyOptions.progressLedger != nil {
+		return true
+	}
+	isLastGroup := group.endIdx == len(plan.Statements)
+	return !isLastGroup && (applyOptions.recheckSchemaHashBetweenStatements || applyOptions.lockTimeoutRetryPolicy != nil)
+}
+
+// executeStatementWithTimeouts sets the statement's timeouts and executes it. Within a transaction, the timeouts are
+// set at the transaction-level. Otherwise, they're set at the session-level, since statements that can't run in a
+// transaction, e.g., CREATE INDEX CONCURRENTLY, don't respect transaction-level timeouts. If onExecute is not nil, it is
+// called right before and after the statement itself is executed, i.e., not around setting the timeouts
+func executeStatementWithTimeouts(ctx context.Context, conn *sql.Conn, stmt Statement, defaultLockTimeout string, inTransaction bool, onExecute func(executing bool)) error {
+	scope := "SESSION"
+	if inTransaction {
+		scope = "LOCAL"
+	}
+	lockTimeout := defaultLockTimeout
+	if stmt.LockTimeout > 0 {
+		lockTimeout = fmt.Sprintf("%dms", stmt.LockTimeout.Milliseconds())
+	}
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf("SET %s statement_timeout = %d", scope, stmt.Timeout.Milliseconds())); err != nil {
+		return fmt.Errorf("setting statement timeout: %w", err)
+	}
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf("SET %s lock_timeout = '%s'", scope, lockTimeout)); err != nil {
+		return fmt.Errorf("setting lock timeout: %w", err)
+	}
+	if onExecute != nil {
+		onExecute(true)
+		defer onExecute(false)
+	}
+	if _, err := conn.ExecContext(ctx, stmt.ToSQL()); err != nil {
+		return fmt.Errorf("executing migration statement: %s: %w", stmt.ToSQL(), err)
+	}
+	return nil
+}
+
+func runBeforeStatementHooks(ctx context.Context, hooks []BeforeStatementHook, stmtIdx int, stmt Statement) error {
+	for _, hook := range hooks {
+		if err := hook(ctx, stmtIdx, stmt); err != nil {
+			return fmt.Errorf("before statement %d hook: %w", stmtIdx, err)
+		}
+	}
+	return nil
+}
+
+// assertHazardsAllowed returns ErrHazardsNotAllowed if any of the plan's statements have hazards whose types are not
+// allowed
+func assertHazardsAllowed(plan Plan, allowedHazardTypes map[MigrationHazardType]bool) error {
+	var disallowedHazardMsgs []string
+	for i, stmt := range plan.Statements {
+		var disallowedTypes []MigrationHazardType
+		for _, hazard := range stmt.Hazards {
+			if !allowedHazardTypes[hazard.Type] {
+				disallowedTypes = append(disallowedTypes, hazard.Type)
+			}
+		}
+		if len(disallowedTypes) > 0 {
+			disallowedHazardMsgs = append(disallowedHazardMsgs,
+				fmt.Sprintf("statement %d: %s", i, strings.Join(disallowedTypes, ", ")),
+			)
+		}
+	}
+	if len(disallowedHazardMsgs) > 0 {
+		return fmt.Errorf("%s: %w", strings.Join(disallowedHazardMsgs, "; "), ErrHazardsNotAllowed)
+	}
+	return nil
+}
+
+func assertSchemaHash(ctx context.Context, conn *sql.Conn, expectedHash string) error {
+	hash, err := getSchemaHash(ctx, conn)
+	if err != nil {
+		return err
+	}
+	if hash != expectedHash {
+		return fmt.Errorf("expected schema hash %q but found %q: %w", expectedHash, hash, ErrSchemaChanged)
+	}
+	return nil
+}
+
+func getSchemaHash(ctx context.Context, conn *sql.Conn) (string, error) {
+	currentSchema, err := schema.GetPublicSchema(ctx, conn)
+	if err != nil {
+		return "", fmt.Errorf("getting current schema: %w", err)
+	}
+	hash, err := currentSchema.Hash()
+	if err != nil {
+		return "", fmt.Errorf("hashing current schema: %w", err)
+	}
+	return hash, nil
+}
+
+// uncancellableContext carries the values of its parent but is never cancelled and has no deadline
+type uncancellableContext struct {
+	parent context.Context
+}
+
+func (uncancellableContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (uncancellableContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (uncancellableContext) Err() error {
+	return nil
+}
+
+func (c uncancellableContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
+
+// withoutCancel returns a context that is not cancelled when ctx is cancelled, such that the queries that clean up
+// after the plan execution, e.g., rolling back or recording the outcome, still run
+func withoutCancel(ctx context.Context) context.Context {
+	return uncancellableContext{parent: ctx}
+}
@@ -0,0 +1,792 @@
+package diff_test
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/stripe/pg-schema-diff/pkg/diff"
+)
+
+func (suite *simpleMigratorTestSuite) TestApplyPlan() {
+	suite.mustApplyDDLToTestDb([]string{`CREATE TABLE foobar(id INT PRIMARY KEY);`})
+
+	conn, poolCloser := suite.mustGetTestDBConn()
+	defer poolCloser.Close()
+	defer conn.Close()
+
+	tempDbFactory := suite.mustBuildTempDbFactory(context.Background())
+	defer tempDbFactory.Close()
+
+	plan, err := diff.GeneratePlan(context.Background(), conn, tempDbFactory, []string{`
+	CREATE TABLE foobar(
+	    id INT PRIMARY KEY,
+	    new_column VARCHAR(128)
+	);
+	CREATE INDEX new_column_idx ON foobar(new_column);
+	`})
+	suite.Require().NoError(err)
+
+	suite.Require().NoError(diff.ApplyPlan(context.Background(), conn, plan))
+	_, err = conn.ExecContext(context.Background(), "SELECT new_column FROM foobar;")
+	suite.NoError(err)
+}
+
+func (suite *simpleMigratorTestSuite) TestApplyPlanFailsIfSchemaChanged() {
+	suite.mustApplyDDLToTestDb([]string{`CREATE TABLE foobar(id INT PRIMARY KEY);`})
+
+	conn, poolCloser := suite.mustGetTestDBConn()
+	defer poolCloser.Close()
+	defer conn.Close()
+
+	tempDbFactory := suite.mustBuildTempDbFactory(context.Background())
+	defer tempDbFactory.Close()
+
+	plan, err := diff.GeneratePlan(context.Background(), conn, tempDbFactory, []string{`
+	CREATE TABLE foobar(
+	    id INT PRIMARY KEY,
+	    new_column VARCHAR(128)
+	);
+	`})
+	suite.Require().NoError(err)
+
+	suite.mustApplyDDLToTestDb([]string{`CREATE TABLE fizzbuzz(id INT PRIMARY KEY);`})
+	suite.ErrorIs(diff.ApplyPlan(context.Background(), conn, plan), diff.ErrSchemaChanged)
+
+	// No statements should have run
+	_, err = conn.ExecContext(context.Background(), "SELECT new_column FROM foobar;")
+	suite.Error(err)
+}
+
+func (suite *simpleMigratorTestSuite) TestApplyPlanFailsIfSchemaChangedBetweenStatements() {
+	suite.mustApplyDDLToTestDb([]string{`CREATE TABLE foobar(id INT PRIMARY KEY, content TEXT);`})
+
+	conn, poolCloser := suite.mustGetTestDBConn()
+	defer poolCloser.Close()
+	defer conn.Close()
+
+	tempDbFactory := suite.mustBuildTempDbFactory(context.Background())
+	defer tempDbFactory.Close()
+
+	// The index is built concurrently, so it is executed outside the transaction of the other statement and the schema
+	// is rechecked between them
+	plan, err := diff.GeneratePlan(context.Background(), conn, tempDbFactory, []string{`
+	CREATE TABLE foobar(
+	    id INT PRIMARY KEY,
+	    content TEXT
+	);
+	CREATE INDEX foobar_content_idx ON foobar(content);
+	CREATE TABLE fizzbuzz(id INT PRIMARY KEY);
+	`})
+	suite.Require().NoError(err)
+	suite.Require().Greater(len(plan.Statements), 1)
+
+	// Simulate a concurrent deploy changing the schema after the first statement
+	err = diff.ApplyPlan(context.Background(), conn, plan,
+		diff.WithSchemaHashRecheckBetweenStatements(),
+		diff.WithAfterStatementHook(func(_ context.Context, stmtIdx int, _ diff.Statement, _ time.Duration) error {
+			if stmtIdx == 0 {
+				suite.mustApplyDDLToTestDb([]string{`CREATE TABLE concurrent_table(id INT PRIMARY KEY);`})
+			}
+			return nil
+		}),
+	)
+	suite.ErrorIs(err, diff.ErrSchemaChanged)
+}
+
+func (suite *simpleMigratorTestSuite) TestApplyPlanHooksAndDryRun() {
+	suite.mustApplyDDLToTestDb([]string{`CREATE TABLE foobar(id INT PRIMARY KEY);`})
+
+	conn, poolCloser := suite.mustGetTestDBConn()
+	defer poolCloser.Close()
+	defer conn.Close()
+
+	tempDbFactory := suite.mustBuildTempDbFactory(context.Background())
+	defer tempDbFactory.Close()
+
+	plan, err := diff.GeneratePlan(context.Background(), conn, tempDbFactory, []string{`
+	CREATE TABLE foobar(
+	    id INT PRIMARY KEY,
+	    new_column VARCHAR(128)
+	);
+	`})
+	suite.Require().NoError(err)
+
+	var beforeStmtIdxs, afterStmtIdxs []int
+	hooks := []diff.ApplyOpt{
+		diff.WithBeforeStatementHook(func(_ context.Context, stmtIdx int, _ diff.Statement) error {
+			beforeStmtIdxs = append(beforeStmtIdxs, stmtIdx)
+			return nil
+		}),
+		diff.WithAfterStatementHook(func(_ context.Context, stmtIdx int, _ diff.Statement, _ time.Duration) error {
+			afterStmtIdxs = append(afterStmtIdxs, stmtIdx)
+			return nil
+		}),
+	}
+
+	suite.Require().NoError(diff.ApplyPlan(context.Background(), conn, plan, append(hooks, diff.WithDryRun())...))
+	suite.Equal([]int{0}, beforeStmtIdxs)
+	suite.Empty(afterStmtIdxs)
+	_, err = conn.ExecContext(context.Background(), "SELECT new_column FROM foobar;")
+	suite.Error(err)
+
+	beforeStmtIdxs = nil
+	suite.Require().NoError(diff.ApplyPlan(context.Background(), conn, plan, hooks...))
+	suite.Equal([]int{0}, beforeStmtIdxs)
+	suite.Equal([]int{0}, afterStmtIdxs)
+	_, err = conn.ExecContext(context.Background(), "SELECT new_column FROM foobar;")
+	suite.NoError(err)
+}
+
+func (suite *simpleMigratorTestSuite) TestApplyPlanStatementExecutionHook() {
+	suite.mustApplyDDLToTestDb([]string{`CREATE TABLE foobar(id INT PRIMARY KEY);`})
+
+	conn, poolCloser := suite.mustGetTestDBConn()
+	defer poolCloser.Close()
+	defer conn.Close()
+
+	tempDbFactory := suite.mustBuildTempDbFactory(context.Background())
+	defer tempDbFactory.Close()
+
+	plan, err := diff.GeneratePlan(context.Background(), conn, tempDbFactory, []string{`
+	CREATE TABLE foobar(
+	    id INT PRIMARY KEY,
+	    new_column VARCHAR(128)
+	);
+	`})
+	suite.Require().NoError(err)
+
+	var events []string
+	hooks := []diff.ApplyOpt{
+		diff.WithBeforeStatementHook(func(_ context.Context, stmtIdx int, _ diff.Statement) error {
+			events = append(events, fmt.Sprintf("before %d", stmtIdx))
+			return nil
+		}),
+		diff.WithStatementExecutionHook(func(stmtIdx int, executing bool) {
+			events = append(events, fmt.Sprintf("executing %d: %t", stmtIdx, executing))
+		}),
+		diff.WithAfterStatementHook(func(_ context.Context, stmtIdx int, _ diff.Statement, _ time.Duration) error {
+			events = append(events, fmt.Sprintf("after %d", stmtIdx))
+			return nil
+		}),
+	}
+
+	suite.Require().NoError(diff.ApplyPlan(context.Background(), conn, plan, append(hooks, diff.WithDryRun())...))
+	suite.Equal([]string{"before 0"}, events)
+
+	events = nil
+	suite.Require().NoError(diff.ApplyPlan(context.Background(), conn, plan, append(hooks, diff.WithProgressLedger())...))
+	suite.Equal([]string{"before 0", "executing 0: true", "executing 0: false", "after 0"}, events)
+}
+
+func (suite *simpleMigratorTestSuite) TestApplyPlanFailsIfHazardsNotAllowed() {
+	suite.mustApplyDDLToTestDb([]string{`CREATE TABLE foobar(id INT PRIMARY KEY, old_column VARCHAR(128));`})
+
+	conn, poolCloser := suite.mustGetTestDBConn()
+	defer poolCloser.Close()
+	defer conn.Close()
+
+	tempDbFactory := suite.mustBuildTempDbFactory(context.Background())
+	defer tempDbFactory.Close()
+
+	plan, err := diff.GeneratePlan(context.Background(), conn, tempDbFactory, []string{`
+	CREATE TABLE foobar(id INT PRIMARY KEY);
+	`})
+	suite.Require().NoError(err)
+
+	suite.ErrorIs(diff.ApplyPlan(context.Background(), conn, plan, diff.WithAllowedHazards()), diff.ErrHazardsNotAllowed)
+	_, err = conn.ExecContext(context.Background(), "SELECT old_column FROM foobar;")
+	suite.NoError(err)
+
+	suite.NoError(diff.ApplyPlan(context.Background(), conn, plan, diff.WithAllowedHazards(diff.MigrationHazardTypeDeletesData)))
+}
+
+func (suite *simpleMigratorTestSuite) TestApplyPlanWithLockTimeout() {
+	suite.mustApplyDDLToTestDb([]string{`CREATE TABLE foobar(id INT PRIMARY KEY);`})
+
+	conn, poolCloser := suite.mustGetTestDBConn()
+	defer poolCloser.Close()
+	defer conn.Close()
+
+	tempDbFactory := suite.mustBuildTempDbFactory(context.Background())
+	defer tempDbFactory.Close()
+
+	plan, err := diff.GeneratePlan(context.Background(), conn, tempDbFactory, []string{`
+	CREATE TABLE foobar(
+	    id INT PRIMARY KEY,
+	    new_column VARCHAR(128)
+	);
+	`})
+	suite.Require().NoError(err)
+
+	// Hold a lock on the table from another connection
+	lockingConn, lockingPoolCloser := suite.mustGetTestDBConn()
+	defer lockingPoolCloser.Close()
+	defer lockingConn.Close()
+	tx, err := lockingConn.BeginTx(context.Background(), nil)
+	suite.Require().NoError(err)
+	defer tx.Rollback()
+	_, err = tx.Exec("LOCK TABLE foobar IN ACCESS SHARE MODE")
+	suite.Require().NoError(err)
+
+	err = diff.ApplyPlan(context.Background(), conn, plan, diff.WithLockTimeout(100*time.Millisecond))
+	suite.ErrorContains(err, "lock timeout")
+}
+
+func (suite *simpleMigratorTestSuite) TestApplyPlanResume() {
+	suite.mustApplyDDLToTestDb([]string{`CREATE TABLE foobar(id INT PRIMARY KEY);`})
+
+	conn, poolCloser := suite.mustGetTestDBConn()
+	defer poolCloser.Close()
+	defer conn.Close()
+
+	tempDbFactory := suite.mustBuildTempDbFactory(context.Background())
+	defer tempDbFactory.Close()
+
+	plan, err := diff.GeneratePlan(context.Background(), conn, tempDbFactory, []string{`
+	CREATE TABLE foobar(
+	    id INT PRIMARY KEY,
+	    new_column VARCHAR(128)
+	);
+	CREATE TABLE fizzbuzz(id INT PRIMARY KEY);
+	`})
+	suite.Require().NoError(err)
+	// The marker table is outside the public schema, so creating it does not change the schema hash. The statement runs
+	// outside of a transaction, such that the first statement is committed before it fails
+	plan, err = plan.InsertStatement(1, diff.Statement{
+		DDL:                "SELECT * FROM resume_marker.marker",
+		Timeout:            time.Second,
+		IsNonTransactional: true,
+	})
+	suite.Require().NoError(err)
+
+	var executedStmtIdxs []int
+	recordStmtsOpt := diff.WithBeforeStatementHook(func(_ context.Context, stmtIdx int, _ diff.Statement) error {
+		executedStmtIdxs = append(executedStmtIdxs, stmtIdx)
+		return nil
+	})
+
+	suite.ErrorIs(diff.ApplyPlan(context.Background(), conn, plan, diff.WithResume(), diff.WithProgressLedger()), diff.ErrNoProgressRecorded)
+	suite.Error(diff.ApplyPlan(context.Background(), conn, plan, diff.WithProgressLedger(), recordStmtsOpt))
+	suite.Equal([]int{0, 1}, executedStmtIdxs)
+
+	incompletePlan, found, err := diff.GetIncompletePlanFromProgressLedger(context.Background(), conn)
+	suite.Require().NoError(err)
+	suite.Require().True(found)
+	suite.Equal(plan, incompletePlan)
+
+	suite.mustApplyDDLToTestDb([]string{`CREATE SCHEMA resume_marker; CREATE TABLE resume_marker.marker();`})
+	executedStmtIdxs = nil
+	suite.Require().NoError(diff.ApplyPlan(context.Background(), conn, incompletePlan, diff.WithProgressLedger(), diff.WithResume(), recordStmtsOpt))
+	// The first statement already succeeded, so it is skipped
+	var expectedStmtIdxs []int
+	for i := 1; i < len(plan.Statements); i++ {
+		expectedStmtIdxs = append(expectedStmtIdxs, i)
+	}
+	suite.Equal(expectedStmtIdxs, executedStmtIdxs)
+	_, err = conn.ExecContext(context.Background(), "SELECT new_column FROM foobar;")
+	suite.NoError(err)
+
+	_, found, err = diff.GetIncompletePlanFromProgressLedger(context.Background(), conn)
+	suite.Require().NoError(err)
+	suite.False(found)
+}
+
+func (suite *simpleMigratorTestSuite) TestApplyPlanWithHistory() {
+	suite.mustApplyDDLToTestDb([]string{`CREATE TABLE foobar(id INT PRIMARY KEY);`})
+
+	conn, poolCloser := suite.mustGetTestDBConn()
+	defer poolCloser.Close()
+	defer conn.Close()
+
+	tempDbFactory := suite.mustBuildTempDbFactory(context.Background())
+	defer tempDbFactory.Close()
+
+	plan, err := diff.GeneratePlan(context.Background(), conn, tempDbFactory, []string{`
+	CREATE TABLE foobar(
+	    id INT PRIMARY KEY,
+	    new_column VARCHAR(128)
+	);
+	`})
+	suite.Require().NoError(err)
+
+	records, err := diff.ListMigrationHistory(context.Background(), conn, 0)
+	suite.Require().NoError(err)
+	suite.Empty(records)
+
+	historyOpts := []diff.HistoryOpt{diff.WithHistorySchema("history_schema"), diff.WithHistoryOperator("some-operator")}
+	suite.Require().NoError(diff.ApplyPlan(context.Background(), conn, plan,
+		diff.WithHistory(historyOpts...),
+		diff.WithAllowedHazards(diff.MigrationHazardTypeIndexBuild, diff.MigrationHazardTypeDeletesData),
+	))
+	// The schema changed, so the plan fails
+	suite.ErrorIs(diff.ApplyPlan(context.Background(), conn, plan, diff.WithHistory(historyOpts...)), diff.ErrSchemaChanged)
+
+	records, err = diff.ListMigrationHistory(context.Background(), conn, 0, historyOpts...)
+	suite.Require().NoError(err)
+	suite.Require().Len(records, 2)
+
+	failedRecord, succeededRecord := records[0], records[1]
+	suite.Equal(plan, succeededRecord.Plan)
+	suite.Equal(diff.MigrationOutcomeSucceeded, succeededRecord.Outcome)
+	suite.Equal("some-operator", succeededRecord.Operator)
+	suite.Equal(plan.CurrentSchemaHash, succeededRecord.BeforeSchemaHash)
+	suite.NotEqual(plan.CurrentSchemaHash, succeededRecord.AfterSchemaHash)
+	suite.Equal([]diff.MigrationHazardType{diff.MigrationHazardTypeDeletesData, diff.MigrationHazardTypeIndexBuild}, succeededRecord.AllowedHazardTypes)
+	suite.False(succeededRecord.FinishedAt.Before(succeededRecord.StartedAt))
+	suite.Empty(succeededRecord.ErrorMessage)
+
+	suite.Equal(diff.MigrationOutcomeFailed, failedRecord.Outcome)
+	suite.Nil(failedRecord.AllowedHazardTypes)
+	suite.Contains(failedRecord.ErrorMessage, "schema changed")
+	suite.Equal(succeededRecord.AfterSchemaHash, failedRecord.AfterSchemaHash)
+
+	record, found, err := diff.GetMigrationHistoryRecord(context.Background(), conn, succeededRecord.ID, historyOpts...)
+	suite.Require().NoError(err)
+	suite.True(found)
+	suite.Equal(succeededRecord, record)
+}
+
+func (suite *simpleMigratorTestSuite) TestApplyPlanWithAdvisoryLock() {
+	suite.mustApplyDDLToTestDb([]string{`CREATE TABLE foobar(id INT PRIMARY KEY);`})
+
+	conn, poolCloser := suite.mustGetTestDBConn()
+	defer poolCloser.Close()
+	defer conn.Close()
+
+	tempDbFactory := suite.mustBuildTempDbFactory(context.Background())
+	defer tempDbFactory.Close()
+
+	plan, err := diff.GeneratePlan(context.Background(), conn, tempDbFactory, []string{`
+	CREATE TABLE foobar(
+	    id INT PRIMARY KEY,
+	    new_column VARCHAR(128)
+	);
+	`})
+	suite.Require().NoError(err)
+
+	// Hold the advisory lock from another connection, e.g., another apply
+	lockingConn, lockingPoolCloser := suite.mustGetTestDBConn()
+	defer lockingPoolCloser.Close()
+	defer lockingConn.Close()
+	_, err = lockingConn.ExecContext(context.Background(), "SELECT pg_advisory_lock($1)", diff.DefaultAdvisoryLockKey)
+	suite.Require().NoError(err)
+	var lockingPid int
+	suite.Require().NoError(lockingConn.QueryRowContext(context.Background(), "SELECT pg_backend_pid()").Scan(&lockingPid))
+
+	advisoryLockOpt := diff.WithAdvisoryLock(diff.WithAdvisoryLockWaitTimeout(200 * time.Millisecond))
+	err = diff.ApplyPlan(context.Background(), conn, plan, advisoryLockOpt)
+	suite.ErrorIs(err, diff.ErrAdvisoryLockNotAcquired)
+	suite.ErrorContains(err, fmt.Sprintf("pid %d", lockingPid))
+	_, err = conn.ExecContext(context.Background(), "SELECT new_column FROM foobar;")
+	suite.Error(err)
+
+	_, err = lockingConn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", diff.DefaultAdvisoryLockKey)
+	suite.Require().NoError(err)
+	suite.Require().NoError(diff.ApplyPlan(context.Background(), conn, plan, advisoryLockOpt))
+	_, err = conn.ExecContext(context.Background(), "SELECT new_column FROM foobar;")
+	suite.NoError(err)
+
+	// The lock is released once the plan is applied
+	var acquired bool
+	suite.Require().NoError(lockingConn.QueryRowContext(context.Background(), "SELECT pg_try_advisory_lock($1)", diff.DefaultAdvisoryLockKey).Scan(&acquired))
+	suite.True(acquired)
+}
+
+type recordingLogger struct {
+	msgs []string
+}
+
+func (l *recordingLogger) Errorf(msg string, args ...any) {
+	l.msgs = append(l.msgs, fmt.Sprintf(msg, args...))
+}
+
+func (suite *simpleMigratorTestSuite) TestApplyPlanRetriesLockTimeouts() {
+	suite.mustApplyDDLToTestDb([]string{`CREATE TABLE foobar(id INT PRIMARY KEY);`})
+
+	conn, poolCloser := suite.mustGetTestDBConn()
+	defer poolCloser.Close()
+	defer conn.Close()
+
+	tempDbFactory := suite.mustBuildTempDbFactory(context.Background())
+	defer tempDbFactory.Close()
+
+	plan, err := diff.GeneratePlan(context.Background(), conn, tempDbFactory, []string{`
+	CREATE TABLE foobar(
+	    id INT PRIMARY KEY,
+	    new_column VARCHAR(128)
+	);
+	`})
+	suite.Require().NoError(err)
+
+	// Hold a lock on the table from another connection, releasing it after the first few attempts
+	lockingConn, lockingPoolCloser := suite.mustGetTestDBConn()
+	defer lockingPoolCloser.Close()
+	defer lockingConn.Close()
+	tx, err := lockingConn.BeginTx(context.Background(), nil)
+	suite.Require().NoError(err)
+	defer tx.Rollback()
+	_, err = tx.Exec("LOCK TABLE foobar IN ACCESS SHARE MODE")
+	suite.Require().NoError(err)
+	releaseTimer := time.AfterFunc(500*time.Millisecond, func() {
+		_ = tx.Rollback()
+	})
+	defer releaseTimer.Stop()
+
+	logger := &recordingLogger{}
+	suite.Require().NoError(diff.ApplyPlan(context.Background(), conn, plan,
+		diff.WithLockTimeout(50*time.Millisecond),
+		diff.WithLockTimeoutRetries(diff.LockTimeoutRetryPolicy{
+			MaxRetries:     100,
+			InitialBackoff: 20 * time.Millisecond,
+			MaxBackoff:     50 * time.Millisecond,
+		}),
+		diff.WithApplyLogger(logger),
+	))
+	suite.NotEmpty(logger.msgs)
+	for _, msg := range logger.msgs {
+		suite.Contains(msg, "failed to acquire a lock")
+	}
+	_, err = conn.ExecContext(context.Background(), "SELECT new_column FROM foobar;")
+	suite.NoError(err)
+}
+
+func (suite *simpleMigratorTestSuite) TestApplyPlanRetriesLockTimeoutsOfLaterGroups() {
+	suite.mustApplyDDLToTestDb([]string{`CREATE TABLE foobar(id INT PRIMARY KEY);`})
+
+	conn, poolCloser := suite.mustGetTestDBConn()
+	defer poolCloser.Close()
+	defer conn.Close()
+
+	tempDbFactory := suite.mustBuildTempDbFactory(context.Background())
+	defer tempDbFactory.Close()
+
+	plan, err := diff.GeneratePlan(context.Background(), conn, tempDbFactory, []string{`
+	CREATE TABLE foobar(
+	    id INT PRIMARY KEY,
+	    new_column VARCHAR(128)
+	);
+	`})
+	suite.Require().NoError(err)
+	// The inserted statement changes the schema in its own group, such that the retried group must verify the schema
+	// against the hash after it rather than the plan's hash
+	plan, err = plan.InsertStatement(0, diff.Statement{
+		DDL:                "CREATE TABLE fizzbuzz(id INT PRIMARY KEY)",
+		Timeout:            time.Second,
+		IsNonTransactional: true,
+	})
+	suite.Require().NoError(err)
+
+	lockingConn, lockingPoolCloser := suite.mustGetTestDBConn()
+	defer lockingPoolCloser.Close()
+	defer lockingConn.Close()
+	tx, err := lockingConn.BeginTx(context.Background(), nil)
+	suite.Require().NoError(err)
+	defer tx.Rollback()
+	_, err = tx.Exec("LOCK TABLE foobar IN ACCESS SHARE MODE")
+	suite.Require().NoError(err)
+	releaseTimer := time.AfterFunc(500*time.Millisecond, func() {
+		_ = tx.Rollback()
+	})
+	defer releaseTimer.Stop()
+
+	logger := &recordingLogger{}
+	suite.Require().NoError(diff.ApplyPlan(context.Background(), conn, plan,
+		diff.WithLockTimeout(50*time.Millisecond),
+		diff.WithLockTimeoutRetries(diff.LockTimeoutRetryPolicy{
+			MaxRetries:     100,
+			InitialBackoff: 20 * time.Millisecond,
+			MaxBackoff:     50 * time.Millisecond,
+		}),
+		diff.WithApplyLogger(logger),
+	))
+	suite.NotEmpty(logger.msgs)
+	for _, msg := range logger.msgs {
+		// Only the group after the inserted statement is retried
+		suite.Regexp(`^statements? 1 `, msg)
+	}
+	_, err = conn.ExecContext(context.Background(), "SELECT new_column FROM foobar;")
+	suite.NoError(err)
+}
+
+func (suite *simpleMigratorTestSuite) TestApplyPlanGivesUpAfterMaxLockTimeoutRetries() {
+	suite.mustApplyDDLToTestDb([]string{`CREATE TABLE foobar(id INT PRIMARY KEY);`})
+
+	conn, poolCloser := suite.mustGetTestDBConn()
+	defer poolCloser.Close()
+	defer conn.Close()
+
+	tempDbFactory := suite.mustBuildTempDbFactory(context.Background())
+	defer tempDbFactory.Close()
+
+	plan, err := diff.GeneratePlan(context.Background(), conn, tempDbFactory, []string{`
+	CREATE TABLE foobar(
+	    id INT PRIMARY KEY,
+	    new_column VARCHAR(128)
+	);
+	`})
+	suite.Require().NoError(err)
+
+	lockingConn, lockingPoolCloser := suite.mustGetTestDBConn()
+	defer lockingPoolCloser.Close()
+	defer lockingConn.Close()
+	tx, err := lockingConn.BeginTx(context.Background(), nil)
+	suite.Require().NoError(err)
+	defer tx.Rollback()
+	_, err = tx.Exec("LOCK TABLE foobar IN ACCESS SHARE MODE")
+	suite.Require().NoError(err)
+
+	logger := &recordingLogger{}
+	err = diff.ApplyPlan(context.Background(), conn, plan,
+		diff.WithLockTimeout(50*time.Millisecond),
+		diff.WithLockTimeoutRetries(diff.LockTimeoutRetryPolicy{
+			MaxRetries:     2,
+			InitialBackoff: 10 * time.Millisecond,
+			MaxBackoff:     10 * time.Millisecond,
+		}),
+		diff.WithApplyLogger(logger),
+	)
+	suite.ErrorContains(err, "lock timeout")
+	// Every attempt is logged
+	suite.Len(logger.msgs, 3)
+}
+
+func (suite *simpleMigratorTestSuite) TestApplyPlanWithStatementLockTimeout() {
+	suite.mustApplyDDLToTestDb([]string{`CREATE TABLE foobar(id INT PRIMARY KEY);`})
+
+	conn, poolCloser := suite.mustGetTestDBConn()
+	defer poolCloser.Close()
+	defer conn.Close()
+
+	tempDbFactory := suite.mustBuildTempDbFactory(context.Background())
+	defer tempDbFactory.Close()
+
+	plan, err := diff.GeneratePlan(context.Background(), conn, tempDbFactory, []string{`
+	CREATE TABLE foobar(
+	    id INT PRIMARY KEY,
+	    new_column VARCHAR(128)
+	);
+	`})
+	suite.Require().NoError(err)
+	plan = plan.ApplyLockTimeoutModifier(regexp.MustCompile("new_column"), 100*time.Millisecond)
+
+	lockingConn, lockingPoolCloser := suite.mustGetTestDBConn()
+	defer lockingPoolCloser.Close()
+	defer lockingConn.Close()
+	tx, err := lockingConn.BeginTx(context.Background(), nil)
+	suite.Require().NoError(err)
+	defer tx.Rollback()
+	_, err = tx.Exec("LOCK TABLE foobar IN ACCESS SHARE MODE")
+	suite.Require().NoError(err)
+
+	// The statement's lock timeout takes priority over the default lock timeout, which is disabled
+	err = diff.ApplyPlan(context.Background(), conn, plan, diff.WithLockTimeout(0))
+	suite.ErrorContains(err, "lock timeout")
+}
+
+func (suite *simpleMigratorTestSuite) TestApplyPlanRollsBackFailedTransaction() {
+	suite.mustApplyDDLToTestDb([]string{`CREATE TABLE foobar(id INT PRIMARY KEY);`})
+
+	conn, poolCloser := suite.mustGetTestDBConn()
+	defer poolCloser.Close()
+	defer conn.Close()
+
+	tempDbFactory := suite.mustBuildTempDbFactory(context.Background())
+	defer tempDbFactory.Close()
+
+	plan, err := diff.GeneratePlan(context.Background(), conn, tempDbFactory, []string{`
+	CREATE TABLE foobar(
+	    id INT PRIMARY KEY,
+	    new_column VARCHAR(128)
+	);
+	`})
+	suite.Require().NoError(err)
+	plan, err = plan.InsertStatement(len(plan.Statements), diff.Statement{DDL: "SELECT 1/0", Timeout: time.Second})
+	suite.Require().NoError(err)
+	for _, stmt := range plan.Statements {
+		suite.Require().False(stmt.IsNonTransactional)
+	}
+
+	suite.ErrorContains(diff.ApplyPlan(context.Background(), conn, plan, diff.WithProgressLedger()), "division by zero")
+	// The failed statement rolls back the statements in its transaction
+	_, err = conn.ExecContext(context.Background(), "SELECT new_column FROM foobar;")
+	suite.Error(err)
+
+	statuses, err := diff.GetStatementStatusesFromProgressLedger(context.Background(), conn, plan)
+	suite.Require().NoError(err)
+	suite.Require().Len(statuses, len(plan.Statements))
+	for _, status := range statuses[:len(statuses)-1] {
+		suite.Equal(diff.StatementStatusPending, status)
+	}
+	suite.Equal(diff.StatementStatusFailed, statuses[len(statuses)-1])
+
+	incompletePlan, found, err := diff.GetIncompletePlanFromProgressLedger(context.Background(), conn)
+	suite.Require().NoError(err)
+	suite.Require().True(found)
+	suite.Equal(plan, incompletePlan)
+	var executedStmtIdxs []int
+	suite.ErrorContains(diff.ApplyPlan(context.Background(), conn, plan, diff.WithProgressLedger(), diff.WithResume(),
+		diff.WithBeforeStatementHook(func(_ context.Context, stmtIdx int, _ diff.Statement) error {
+			executedStmtIdxs = append(executedStmtIdxs, stmtIdx)
+			return nil
+		}),
+	), "division by zero")
+	// The resumed plan re-executes the whole transaction
+	suite.Require().NotEmpty(executedStmtIdxs)
+	suite.Equal(0, executedStmtIdxs[0])
+}
+
+func (suite *simpleMigratorTestSuite) TestApplyPlanCommitsBeforeTableRewrite() {
+	suite.mustApplyDDLToTestDb([]string{`CREATE TABLE foobar(id INT PRIMARY KEY, bar INT);`})
+
+	conn, poolCloser := suite.mustGetTestDBConn()
+	defer poolCloser.Close()
+	defer conn.Close()
+
+	tempDbFactory := suite.mustBuildTempDbFactory(context.Background())
+	defer tempDbFactory.Close()
+
+	plan, err := diff.GeneratePlan(context.Background(), conn, tempDbFactory, []string{`
+	CREATE TABLE foobar(
+	    id INT PRIMARY KEY,
+	    bar BIGINT
+	);
+	`})
+	suite.Require().NoError(err)
+	rewriteStmtIdx := -1
+	for i, stmt := range plan.Statements {
+		if strings.Contains(stmt.DDL, "SET DATA TYPE") {
+			rewriteStmtIdx = i
+		}
+	}
+	suite.Require().NotEqual(-1, rewriteStmtIdx)
+	suite.Require().True(plan.Statements[rewriteStmtIdx].IsNonTransactional)
+	// The ALTER acquires an ACCESS EXCLUSIVE lock on the table, which must not be held during the re-write
+	plan, err = plan.InsertStatement(rewriteStmtIdx, diff.Statement{
+		DDL:     "ALTER TABLE foobar ADD COLUMN new_column VARCHAR(128)",
+		Timeout: time.Second,
+	})
+	suite.Require().NoError(err)
+	rewriteStmtIdx++
+
+	otherConn, otherPoolCloser := suite.mustGetTestDBConn()
+	defer otherPoolCloser.Close()
+	defer otherConn.Close()
+	_, err = otherConn.ExecContext(context.Background(), "SET lock_timeout = '1s'")
+	suite.Require().NoError(err)
+
+	suite.Require().NoError(diff.ApplyPlan(context.Background(), conn, plan,
+		diff.WithBeforeStatementHook(func(ctx context.Context, stmtIdx int, _ diff.Statement) error {
+			if stmtIdx != rewriteStmtIdx {
+				return nil
+			}
+			// The ALTER is committed, so its column is visible and its lock is released
+			_, err := otherConn.ExecContext(ctx, "SELECT new_column FROM foobar")
+			return err
+		}),
+	))
+}
+
+func (suite *simpleMigratorTestSuite) TestApplyPlanStopsWhenContextCancelled() {
+	suite.mustApplyDDLToTestDb([]string{`CREATE TABLE foobar(id INT PRIMARY KEY);`})
+
+	conn, poolCloser := suite.mustGetTestDBConn()
+	defer poolCloser.Close()
+	defer conn.Close()
+
+	tempDbFactory := suite.mustBuildTempDbFactory(context.Background())
+	defer tempDbFactory.Close()
+
+	plan, err := diff.GeneratePlan(context.Background(), conn, tempDbFactory, []string{`
+	CREATE TABLE foobar(
+	    id INT PRIMARY KEY,
+	    new_column VARCHAR(128)
+	);
+	CREATE TABLE fizzbuzz(id INT PRIMARY KEY);
+	`})
+	suite.Require().NoError(err)
+	suite.Require().Greater(len(plan.Statements), 1)
+	for _, stmt := range plan.Statements {
+		suite.Require().False(stmt.IsNonTransactional)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var executedStmtIdxs []int
+	err = diff.ApplyPlan(ctx, conn, plan,
+		diff.WithProgressLedger(),
+		diff.WithHistory(),
+		diff.WithAdvisoryLock(),
+		diff.WithBeforeStatementHook(func(_ context.Context, stmtIdx int, _ diff.Statement) error {
+			executedStmtIdxs = append(executedStmtIdxs, stmtIdx)
+			return nil
+		}),
+		diff.WithAfterStatementHook(func(_ context.Context, _ int, _ diff.Statement, _ time.Duration) error {
+			cancel()
+			return nil
+		}),
+	)
+	suite.ErrorIs(err, context.Canceled)
+	// The statement running when the context is cancelled completes, but the next one is not started
+	suite.Equal([]int{0}, executedStmtIdxs)
+
+	// The transaction is rolled back, and the progress and the history are recorded
+	_, err = conn.ExecContext(context.Background(), "SELECT new_column FROM foobar;")
+	suite.Error(err)
+	statuses, err := diff.GetStatementStatusesFromProgressLedger(context.Background(), conn, plan)
+	suite.Require().NoError(err)
+	for _, status := range statuses {
+		suite.Equal(diff.StatementStatusPending, status)
+	}
+	records, err := diff.ListMigrationHistory(context.Background(), conn, 0)
+	suite.Require().NoError(err)
+	suite.Require().Len(records, 1)
+	suite.Equal(diff.MigrationOutcomeFailed, records[0].Outcome)
+
+	// The advisory lock is released
+	otherPool := suite.mustGetTestDBPool()
+	defer otherPool.Close()
+	var acquired bool
+	suite.Require().NoError(otherPool.QueryRow("SELECT pg_try_advisory_lock($1)", diff.DefaultAdvisoryLockKey).Scan(&acquired))
+	suite.True(acquired)
+}
+
+func (suite *simpleMigratorTestSuite) TestApplyPlanWithInvalidIndexCleanup() {
+	suite.mustApplyDDLToTestDb([]string{`
+	CREATE TABLE foobar(id INT PRIMARY KEY, foo INT);
+	INSERT INTO foobar VALUES (1, 1), (2, 1);
+	`})
+
+	conn, poolCloser := suite.mustGetTestDBConn()
+	defer poolCloser.Close()
+	defer conn.Close()
+
+	tempDbFactory := suite.mustBuildTempDbFactory(context.Background())
+	defer tempDbFactory.Close()
+
+	plan, err := diff.GeneratePlan(context.Background(), conn, tempDbFactory, []string{`
+	CREATE TABLE foobar(id INT PRIMARY KEY, foo INT);
+	CREATE UNIQUE INDEX foo_idx ON foobar(foo);
+	`})
+	suite.Require().NoError(err)
+
+	// The duplicate rows fail the concurrent build, which leaves behind an invalid index
+	suite.Error(diff.ApplyPlan(context.Background(), conn, plan))
+	var isValid bool
+	suite.Require().NoError(conn.QueryRowContext(context.Background(),
+		"SELECT indisvalid FROM pg_catalog.pg_index WHERE indexrelid = to_regclass('foo_idx')").Scan(&isValid))
+	suite.False(isValid)
+	_, err = conn.ExecContext(context.Background(), "DROP INDEX foo_idx")
+	suite.Require().NoError(err)
+
+	logger := &recordingLogger{}
+	err = diff.ApplyPlan(context.Background(), conn, plan, diff.WithInvalidIndexCleanup(), diff.WithApplyLogger(logger))
+	suite.ErrorContains(err, "dropped the invalid index")
+	suite.Len(logger.msgs, 1)
+	var indexExists bool
+	suite.Require().NoError(conn.QueryRowContext(context.Background(),
+		"SELECT to_regclass('foo_idx') IS NOT NULL").Scan(&indexExists))
+	suite.False(indexExists)
+}
@@ -17,10 +17,24 @@ import (
 
 type (
 	planOptions struct {
-		dataPackNewTables       bool
-		ignoreChangesToColOrder bool
-		logger                  log.Logger
-		validatePlan            bool
+		dataPackNewTables            bool
+		ignoreChangesToColOrder      bool
+		moveRowsFromDefaultPartition bool
+		rebuildInvalidIndexesInPlace bool
+		// rewriteTablesToReorderColumns and tableSizesInBytesByName are used to rewrite tables to change the
+		// ordering of their columns. The table sizes are fetched from the database during plan generation
+		rewriteTablesToReorderColumns bool
+		tableSizesInBytesByName       map[string]int64
+		renames                       []Rename
+		typeConversionRules           []TypeConversionRule
+		logger                        log.Logger
+		validatePlan                  bool
+		// binaryCoercibleCasts is used to identify column type changes that don't re-write the table. The casts are
+		// fetched from the database during plan generation
+		binaryCoercibleCasts map[binaryCoercibleCast]bool
+		// shadowColumnTypeChanges configures the plan generation to change the types of columns via shadow columns. The
+		// table sizes are fetched to derive the timeouts of the backfills
+		shadowColumnTypeChanges bool
 	}
 
 	PlanOpt func(opts *planOptions)
@@ -42,6 +56,72 @@ func WithRespectColumnOrder() PlanOpt {
 	}
 }
 
+// WithMoveRowsFromDefaultPartition configures the plan generation to move rows from an existing DEFAULT partition into
+// a new partition when the rows belong in the new partition. Without this option, attaching the new partition will fail
+// if the DEFAULT partition contains any rows that belong in it
+func WithMoveRowsFromDefaultPartition() PlanOpt {
+	return func(opts *planOptions) {
+		opts.moveRowsFromDefaultPartition = true
+	}
+}
+
+// WithRebuildInvalidIndexesInPlace configures the plan generation to rebuild invalid indexes in the current schema,
+// e.g., those left behind by a failed CREATE INDEX CONCURRENTLY, with REINDEX INDEX CONCURRENTLY instead of dropping
+// and re-creating them. Invalid indexes on partitioned tables are not rebuilt in place
+func WithRebuildInvalidIndexesInPlace() PlanOpt {
+	return func(opts *planOptions) {
+		opts.rebuildInvalidIndexesInPlace = true
+	}
+}
+
+// WithRewriteTablesToReorderColumns configures the plan generation to rewrite existing tables when the ordering of
+// their columns changes, e.g., to data pack them. The table is copied into a new table with the desired column
+// ordering, which is then swapped in. Writes to the table are locked out during the copy. Partitioned tables and
+// partitions are not rewritten.
+//
+// This option has no effect unless WithRespectColumnOrder is also used, since column ordering changes are ignored by
+// default
+func WithRewriteTablesToReorderColumns() PlanOpt {
+	return func(opts *planOptions) {
+		opts.rewriteTablesToReorderColumns = true
+	}
+}
+
+// WithRenames configures the plan generation to rename the given schema objects in place, rather than dropping and
+// re-creating them. For example, renaming a column preserves its data. Renames that have already been applied to the
+// current schema are skipped
+func WithRenames(renames ...Rename) PlanOpt {
+	return func(opts *planOptions) {
+		opts.renames = append(opts.renames, renames...)
+	}
+}
+
+// WithTypeConversionRules configures the plan generation to convert the values of columns whose types change using the
+// given rules, e.g., to convert text to jsonb via to_jsonb. Later rules take priority over earlier rules, but rules for
+// a specific column always take priority over rules for all columns
+func WithTypeConversionRules(rules ...TypeConversionRule) PlanOpt {
+	return func(opts *planOptions) {
+		opts.typeConversionRules = append(opts.typeConversionRules, rules...)
+	}
+}
+
+// WithShadowColumnTypeChanges configures the plan generation to change the types of columns without holding an ACCESS
+// EXCLUSIVE lock while the table is re-written. Instead, a shadow column with the new type is added and kept in sync
+// with the column by a trigger. Once the shadow column is backfilled in batches, it is swapped in for the column in a
+// short transaction, and the old column is dropped.
+//
+// Columns of partitioned tables or tables with triggers, and columns referenced by indexes or check constraints, are
+// changed in place.
+//
+// The shadow column is added as the last column of the table, so the changed column does not keep its position. As a
+// result, this option can't be used alongside WithRespectColumnOrder: GeneratePlan returns ErrNotImplemented if both
+// are used
+func WithShadowColumnTypeChanges() PlanOpt {
+	return func(opts *planOptions) {
+		opts.shadowColumnTypeChanges = true
+	}
+}
+
 // WithDoNotValidatePlan disables plan validation, where the migration plan is tested against a temporary database
 // instance
 func WithDoNotValidatePlan() PlanOpt {
@@ -79,6 +159,16 @@ func GeneratePlan(ctx context.Context, conn *sql.Conn, tempDbFactory tempdb.Fact
 	if err != nil {
 		return Plan{}, fmt.Errorf("getting current schema: %w", err)
 	}
+	if planOptions.rewriteTablesToReorderColumns || planOptions.shadowColumnTypeChanges {
+		planOptions.tableSizesInBytesByName, err = fetchTableSizesInBytes(ctx, conn)
+		if err != nil {
+			return Plan{}, fmt.Errorf("getting table sizes: %w", err)
+		}
+	}
+	planOptions.binaryCoercibleCasts, err = fetchBinaryCoercibleCasts(ctx, conn)
+	if err != nil {
+		return Plan{}, fmt.Errorf("getting binary coercible casts: %w", err)
+	}
 	newSchema, err := deriveSchemaFromDDLOnTempDb(ctx, planOptions.logger, tempDbFactory, newDDL)
 	if err != nil {
 		return Plan{}, fmt.Errorf("getting new schema: %w", err)
@@ -108,6 +198,32 @@ func GeneratePlan(ctx context.Context, conn *sql.Conn, tempDbFactory tempdb.Fact
 	return plan, nil
 }
 
+func fetchTableSizesInBytes(ctx context.Context, conn queries.DBTX) (map[string]int64, error) {
+	rawTableSizes, err := queries.New(conn).GetTableSizes(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("GetTableSizes: %w", err)
+	}
+
+	tableSizesInBytesByName := make(map[string]int64)
+	for _, rawTableSize := range rawTableSizes {
+		tableSizesInBytesByName[rawTableSize.TableName] = rawTableSize.SizeInBytes
+	}
+	return tableSizesInBytesByName, nil
+}
+
+func fetchBinaryCoercibleCasts(ctx context.Context, conn queries.DBTX) (map[binaryCoercibleCast]bool, error) {
+	rawCasts, err := queries.New(conn).GetBinaryCoercibleCasts(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("GetBinaryCoercibleCasts: %w", err)
+	}
+
+	binaryCoercibleCasts := make(map[binaryCoercibleCast]bool)
+	for _, rawCast := range rawCasts {
+		binaryCoercibleCasts[binaryCoercibleCast{sourceType: rawCast.SourceType, targetType: rawCast.TargetType}] = true
+	}
+	return binaryCoercibleCasts, nil
+}
+
 func deriveSchemaFromDDLOnTempDb(ctx context.Context, logger log.Logger, tempDbFactory tempdb.Factory, ddl []string) (schema.Schema, error) {
 	tempDb, dropTempDb, err := tempDbFactory.Create(ctx)
 	if err != nil {
@@ -129,6 +245,13 @@ func deriveSchemaFromDDLOnTempDb(ctx context.Context, logger log.Logger, tempDbF
 }
 
 func generateMigrationStatements(oldSchema, newSchema schema.Schema, planOptions *planOptions) ([]Statement, error) {
+	// The renames are applied to the old schema before diffing, such that the renamed objects are altered rather than
+	// dropped and re-created. All other statements reference the objects by their new names, so the renames run first
+	oldSchema, renameStatements, err := applyRenames(oldSchema, planOptions.renames)
+	if err != nil {
+		return nil, fmt.Errorf("applying renames: %w", err)
+	}
+
 	diff, _, err := buildSchemaDiff(oldSchema, newSchema)
 	if err != nil {
 		return nil, err
@@ -142,15 +265,21 @@ func generateMigrationStatements(oldSchema, newSchema schema.Schema, planOptions
 		}
 		diff = dataPackNewTables(diff)
 	}
+	if planOptions.shadowColumnTypeChanges && !planOptions.ignoreChangesToColOrder {
+		return nil, fmt.Errorf("changing column types via shadow columns while respecting column order: %w", ErrNotImplemented)
+	}
 	if planOptions.ignoreChangesToColOrder {
 		diff = removeChangesToColumnOrdering(diff)
 	}
+	if planOptions.rebuildInvalidIndexesInPlace {
+		diff = rebuildInvalidIndexesInPlace(diff)
+	}
 
-	statements, err := diff.resolveToSQL()
+	statements, err := diff.resolveToSQL(planOptions)
 	if err != nil {
 		return nil, fmt.Errorf("generating migration statements: %w", err)
 	}
-	return statements, nil
+	return append(renameStatements, statements...), nil
 }
 
 func assertValidPlan(ctx context.Context,
@@ -249,7 +378,7 @@ func executeStatements(ctx context.Context, conn queries.DBTX, statements []Stat
 		}
 		if _, err := conn.ExecContext(ctx, stmt.ToSQL()); err != nil {
 			// could the migration statement contain sensitive information?
-			return fmt.Errorf("executing migration statement: %s: %w", stmt, err)
+			return fmt.Errorf("executing migration statement: %s: %w", stmt.ToSQL(), err)
 		}
 	}
 	return nil
@@ -0,0 +1,304 @@
+package diff
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+const (
+	DefaultHistorySchema = "pgschemadiff_metadata"
+	DefaultHistoryTable  = "migration_history"
+)
+
+type MigrationOutcome = string
+
+const (
+	MigrationOutcomeRunning   MigrationOutcome = "RUNNING"
+	MigrationOutcomeSucceeded MigrationOutcome = "SUCCEEDED"
+	MigrationOutcomeFailed    MigrationOutcome = "FAILED"
+)
+
+type (
+	historyOptions struct {
+		schema   string
+		table    string
+		operator string
+	}
+
+	HistoryOpt func(opts *historyOptions)
+)
+
+// WithHistorySchema sets the schema containing the history table. The schema must not be the public schema, since the
+// public schema is hashed to verify the plan can be applied
+func WithHistorySchema(schema string) HistoryOpt {
+	return func(opts *historyOptions) {
+		opts.schema = schema
+	}
+}
+
+// WithHistoryTable sets the history table name
+func WithHistoryTable(table string) HistoryOpt {
+	return func(opts *historyOptions) {
+		opts.table = table
+	}
+}
+
+// WithHistoryOperator sets the operator recorded as having applied the plan, e.g., the name of the engineer or of the
+// deploy system. If not set, the Postgres session user is recorded
+func WithHistoryOperator(operator string) HistoryOpt {
+	return func(opts *historyOptions) {
+		opts.operator = operator
+	}
+}
+
+// WithHistory configures the plan execution to record the plan, the schema hashes before and after it was applied,
+// the allowed hazards, the operator, the timings and the outcome in a history table, which is created if it does not
+// exist. Dry runs are not recorded
+func WithHistory(opts ...HistoryOpt) ApplyOpt {
+	return func(applyOpts *applyOptions) {
+		history := buildMigrationHistory(opts)
+		applyOpts.history = &history
+	}
+}
+
+// MigrationHistoryRecord is a record of a plan applied with WithHistory
+type MigrationHistoryRecord struct {
+	ID   int64
+	Plan Plan
+	// BeforeSchemaHash is the hash the schema is expected to have before the first executed statement. When resuming a
+	// plan, this is the hash after the last statement that already succeeded
+	BeforeSchemaHash string
+	// AfterSchemaHash is the hash of the schema once the plan finished executing. It is empty if the plan is still
+	// running or the schema could not be hashed
+	AfterSchemaHash string
+	// AllowedHazardTypes is nil if all hazards were allowed
+	AllowedHazardTypes []MigrationHazardType
+	Operator           string
+	IsResume           bool
+	StartedAt          time.Time
+	// FinishedAt is the zero time if the plan is still running
+	FinishedAt   time.Time
+	Outcome      MigrationOutcome
+	ErrorMessage string
+}
+
+// ListMigrationHistory returns the records in the history table, most recently started first. If limit is greater than
+// 0, at most limit records are returned. Returns no records if the history table does not exist
+func ListMigrationHistory(ctx context.Context, conn *sql.Conn, limit int, opts ...HistoryOpt) ([]MigrationHistoryRecord, error) {
+	history := buildMigrationHistory(opts)
+	if exists, err := history.exists(ctx, conn); err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, nil
+	}
+
+	query := fmt.Sprintf("%s ORDER BY started_at DESC, id DESC", history.selectRecordsQuery())
+	var args []interface{}
+	if limit > 0 {
+		query += " LIMIT $1"
+		args = append(args, limit)
+	}
+	rows, err := conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying migration history: %w", err)
+	}
+	defer rows.Close()
+
+	var records []MigrationHistoryRecord
+	for rows.Next() {
+		record, err := scanMigrationHistoryRecord(rows)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating migration history: %w", err)
+	}
+	return records, nil
+}
+
+// GetMigrationHistoryRecord returns the record with the given id from the history table. Returns false if there is no
+// such record
+func GetMigrationHistoryRecord(ctx context.Context, conn *sql.Conn, id int64, opts ...HistoryOpt) (MigrationHistoryRecord, bool, error) {
+	history := buildMigrationHistory(opts)
+	if exists, err := history.exists(ctx, conn); err != nil {
+		return MigrationHistoryRecord{}, false, err
+	} else if !exists {
+		return MigrationHistoryRecord{}, false, nil
+	}
+
+	record, err := scanMigrationHistoryRecord(conn.QueryRowContext(ctx,
+		fmt.Sprintf("%s WHERE id = $1", history.selectRecordsQuery()), id,
+	))
+	if err == sql.ErrNoRows {
+		return MigrationHistoryRecord{}, false, nil
+	} else if err != nil {
+		return MigrationHistoryRecord{}, false, err
+	}
+	return record, true, nil
+}
+
+type migrationHistory struct {
+	historyOptions
+}
+
+func buildMigrationHistory(opts []HistoryOpt) migrationHistory {
+	options := historyOptions{
+		schema: DefaultHistorySchema,
+		table:  DefaultHistoryTable,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return migrationHistory{historyOptions: options}
+}
+
+func (h migrationHistory) sanitizedTableName() string {
+	return pgx.Identifier{h.schema, h.table}.Sanitize()
+}
+
+func (h migrationHistory) exists(ctx context.Context, conn *sql.Conn) (bool, error) {
+	var exists bool
+	if err := conn.QueryRowContext(ctx, "SELECT to_regclass($1) IS NOT NULL", h.sanitizedTableName()).Scan(&exists); err != nil {
+		return false, fmt.Errorf("checking if history table exists: %w", err)
+	}
+	return exists, nil
+}
+
+func (h migrationHistory) createIfNotExists(ctx context.Context, conn *sql.Conn) error {
+	if h.schema == "public" {
+		return fmt.Errorf("history schema must not be the public schema")
+	}
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf(`
+		CREATE SCHEMA IF NOT EXISTS %s;
+		CREATE TABLE IF NOT EXISTS %s(
+			id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+			plan JSONB NOT NULL,
+			before_schema_hash TEXT NOT NULL,
+			after_schema_hash TEXT,
+			allowed_hazards JSONB,
+			operator TEXT NOT NULL,
+			is_resume BOOLEAN NOT NULL,
+			started_at TIMESTAMPTZ NOT NULL DEFAULT current_timestamp,
+			finished_at TIMESTAMPTZ,
+			outcome TEXT NOT NULL,
+			error_message TEXT
+		);
+	`, pgx.Identifier{h.schema}.Sanitize(), h.sanitizedTableName())); err != nil {
+		return fmt.Errorf("creating history table: %w", err)
+	}
+	return nil
+}
+
+// start records the plan as running and returns the id of the record
+func (h migrationHistory) start(ctx context.Context, conn *sql.Conn, plan Plan, beforeSchemaHash string, applyOptions *applyOptions) (int64, error) {
+	planJSON, err := json.Marshal(plan)
+	if err != nil {
+		return 0, fmt.Errorf("marshalling plan: %w", err)
+	}
+	var allowedHazardsJSON interface{}
+	if applyOptions.checkHazards {
+		// Sort the allowed hazards such that the record is deterministic
+		allowedHazardTypes := []MigrationHazardType{}
+		for hazardType := range applyOptions.allowedHazardTypes {
+			allowedHazardTypes = append(allowedHazardTypes, hazardType)
+		}
+		sort.Strings(allowedHazardTypes)
+		marshalled, err := json.Marshal(allowedHazardTypes)
+		if err != nil {
+			return 0, fmt.Errorf("marshalling allowed hazards: %w", err)
+		}
+		allowedHazardsJSON = string(marshalled)
+	}
+
+	var id int64
+	if err := conn.QueryRowContext(ctx, fmt.Sprintf(`
+		INSERT INTO %s(plan, before_schema_hash, allowed_hazards, operator, is_resume, outcome)
+		VALUES ($1, $2, $3, COALESCE(NULLIF($4, ''), session_user), $5, $6)
+		RETURNING id
+	`, h.sanitizedTableName()),
+		string(planJSON), beforeSchemaHash, allowedHazardsJSON, h.operator, applyOptions.resume, MigrationOutcomeRunning,
+	).Scan(&id); err != nil {
+		return 0, fmt.Errorf("recording migration start: %w", err)
+	}
+	return id, nil
+}
+
+// finish records the outcome of the plan. The schema is hashed on a best-effort basis, since the plan might have
+// failed because of the connection
+func (h migrationHistory) finish(ctx context.Context, conn *sql.Conn, id int64, applyErr error) error {
+	var afterSchemaHash interface{}
+	if hash, err := getSchemaHash(ctx, conn); err == nil {
+		afterSchemaHash = hash
+	}
+	outcome := MigrationOutcomeSucceeded
+	var errorMessage interface{}
+	if applyErr != nil {
+		outcome = MigrationOutcomeFailed
+		errorMessage = applyErr.Error()
+	}
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf(`
+		UPDATE %s
+		SET after_schema_hash = $2, finished_at = current_timestamp, outcome = $3, error_message = $4
+		WHERE id = $1
+	`, h.sanitizedTableName()), id, afterSchemaHash, outcome, errorMessage); err != nil {
+		return fmt.Errorf("recording migration outcome: %w", err)
+	}
+	return nil
+}
+
+func (h migrationHistory) selectRecordsQuery() string {
+	return fmt.Sprintf(`
+		SELECT id, plan, before_schema_hash, after_schema_hash, allowed_hazards, operator, is_resume, started_at,
+			finished_at, outcome, error_message
+		FROM %s
+	`, h.sanitizedTableName())
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanMigrationHistoryRecord(row rowScanner) (MigrationHistoryRecord, error) {
+	var record MigrationHistoryRecord
+	var planJSON string
+	var afterSchemaHash, allowedHazardsJSON, errorMessage sql.NullString
+	var finishedAt sql.NullTime
+	if err := row.Scan(
+		&record.ID,
+		&planJSON,
+		&record.BeforeSchemaHash,
+		&afterSchemaHash,
+		&allowedHazardsJSON,
+		&record.Operator,
+		&record.IsResume,
+		&record.StartedAt,
+		&finishedAt,
+		&record.Outcome,
+		&errorMessage,
+	); err == sql.ErrNoRows {
+		return MigrationHistoryRecord{}, err
+	} else if err != nil {
+		return MigrationHistoryRecord{}, fmt.Errorf("scanning migration history record: %w", err)
+	}
+	if err := json.Unmarshal([]byte(planJSON), &record.Plan); err != nil {
+		return MigrationHistoryRecord{}, fmt.Errorf("unmarshalling plan: %w", err)
+	}
+	if allowedHazardsJSON.Valid {
+		record.AllowedHazardTypes = []MigrationHazardType{}
+		if err := json.Unmarshal([]byte(allowedHazardsJSON.String), &record.AllowedHazardTypes); err != nil {
+			return MigrationHistoryRecord{}, fmt.Errorf("unmarshalling allowed hazards: %w", err)
+		}
+	}
+	record.AfterSchemaHash = afterSchemaHash.String
+	record.FinishedAt = finishedAt.Time
+	record.ErrorMessage = errorMessage.String
+	return record, nil
+}
@@ -0,0 +1,340 @@
+package diff
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/mitchellh/hashstructure/v2"
+)
+
+const (
+	DefaultProgressLedgerSchema = "pgschemadiff_metadata"
+	DefaultProgressLedgerTable  = "migration_progress"
+)
+
+type StatementStatus = string
+
+const (
+	StatementStatusPending   StatementStatus = "PENDING"
+	StatementStatusRunning   StatementStatus = "RUNNING"
+	StatementStatusSucceeded StatementStatus = "SUCCEEDED"
+	StatementStatusFailed    StatementStatus = "FAILED"
+)
+
+// ErrNoProgressRecorded is returned when resuming a plan that has no progress recorded in the progress ledger
+var ErrNoProgressRecorded = fmt.Errorf("no progress recorded for plan")
+
+type (
+	progressLedgerOptions struct {
+		schema string
+		table  string
+	}
+
+	ProgressLedgerOpt func(opts *progressLedgerOptions)
+)
+
+// WithProgressLedgerSchema sets the schema containing the progress ledger table. The schema must not be the public
+// schema, since the public schema is hashed to verify the plan can be applied
+func WithProgressLedgerSchema(schema string) ProgressLedgerOpt {
+	return func(opts *progressLedgerOptions) {
+		opts.schema = schema
+	}
+}
+
+// WithProgressLedgerTable sets the progress ledger table name
+func WithProgressLedgerTable(table string) ProgressLedgerOpt {
+	return func(opts *progressLedgerOptions) {
+		opts.table = table
+	}
+}
+
+// WithProgressLedger configures the plan execution to record the status and timings of each of the plan's statements
+// in a progress ledger table, which is created if it does not exist. The progress ledger enables resuming the plan
+// via WithResume if one of its statements fails
+func WithProgressLedger(opts ...ProgressLedgerOpt) ApplyOpt {
+	return func(applyOpts *applyOptions) {
+		ledger := buildProgressLedger(opts)
+		applyOpts.progressLedger = &ledger
+	}
+}
+
+// WithResume configures the plan execution to skip the statements the progress ledger records as succeeded and to
+// continue from the first statement that did not succeed, e.g., the first statement of the transaction that failed. The
+// schema hash is verified against the hash recorded after the last succeeded statement. Requires WithProgressLedger.
+//
+// A statement that failed is re-executed. Statements that run in a transaction are rolled back alongside their progress
+// when the transaction fails, so they are safe to re-execute. Statements that can't run in a transaction, e.g., CREATE INDEX CONCURRENTLY, might leave
+// behind partial changes, such as an invalid index, which will cause the schema hash verification to fail with
+// ErrSchemaChanged until they are cleaned up. Likewise, if the process running the plan was killed while a statement
+// was running, the statement might have been applied without being recorded as succeeded
+func WithResume() ApplyOpt {
+	return func(opts *applyOptions) {
+		opts.resume = true
+	}
+}
+
+// GetIncompletePlanFromProgressLedger returns the most recently started plan recorded in the progress ledger that has
+// statements that did not succeed, such that it can be resumed via WithResume. Returns false if there is no such plan
+func GetIncompletePlanFromProgressLedger(ctx context.Context, conn *sql.Conn, opts ...ProgressLedgerOpt) (Plan, bool, error) {
+	ledger := buildProgressLedger(opts)
+	if exists, err := ledger.exists(ctx, conn); err != nil {
+		return Plan{}, false, err
+	} else if !exists {
+		return Plan{}, false, nil
+	}
+
+	var planID string
+	if err := conn.QueryRowContext(ctx, fmt.Sprintf(`
+		SELECT plan_id FROM %s
+		GROUP BY plan_id
+		HAVING BOOL_OR(status != '%s')
+		ORDER BY MAX(created_at) DESC
+		LIMIT 1
+	`, ledger.sanitizedTableName(), StatementStatusSucceeded)).Scan(&planID); err == sql.ErrNoRows {
+		return Plan{}, false, nil
+	} else if err != nil {
+		return Plan{}, false, fmt.Errorf("querying incomplete plan: %w", err)
+	}
+
+	entries, err := ledger.getEntries(ctx, conn, planID)
+	if err != nil {
+		return Plan{}, false, err
+	}
+	var plan Plan
+	for _, entry := range entries {
+		plan.CurrentSchemaHash = entry.currentSchemaHash
+		plan.Statements = append(plan.Statements, entry.statement)
+	}
+	return plan, true, nil
+}
+
+// GetStatementStatusesFromProgressLedger returns the status the progress ledger records for each of the plan's
+// statements, e.g., to report which statements completed after the plan failed. Statements that were rolled back with
+// their transaction are pending. Returns ErrNoProgressRecorded if the plan has no progress recorded
+func GetStatementStatusesFromProgressLedger(ctx context.Context, conn *sql.Conn, plan Plan, opts ...ProgressLedgerOpt) ([]StatementStatus, error) {
+	ledger := buildProgressLedger(opts)
+	if exists, err := ledger.exists(ctx, conn); err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, ErrNoProgressRecorded
+	}
+	planID, err := getPlanID(plan)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := ledger.getEntries(ctx, conn, planID)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, ErrNoProgressRecorded
+	}
+
+	var statuses []StatementStatus
+	for _, entry := range entries {
+		statuses = append(statuses, entry.status)
+	}
+	return statuses, nil
+}
+
+type (
+	progressLedger struct {
+		progressLedgerOptions
+	}
+
+	progressLedgerEntry struct {
+		currentSchemaHash string
+		statement         Statement
+		status            StatementStatus
+		schemaHashAfter   string
+	}
+)
+
+func buildProgressLedger(opts []ProgressLedgerOpt) progressLedger {
+	options := progressLedgerOptions{
+		schema: DefaultProgressLedgerSchema,
+		table:  DefaultProgressLedgerTable,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return progressLedger{progressLedgerOptions: options}
+}
+
+func (l progressLedger) sanitizedTableName() string {
+	return pgx.Identifier{l.schema, l.table}.Sanitize()
+}
+
+func (l progressLedger) exists(ctx context.Context, conn *sql.Conn) (bool, error) {
+	var exists bool
+	if err := conn.QueryRowContext(ctx, "SELECT to_regclass($1) IS NOT NULL", l.sanitizedTableName()).Scan(&exists); err != nil {
+		return false, fmt.Errorf("checking if progress ledger exists: %w", err)
+	}
+	return exists, nil
+}
+
+func (l progressLedger) createIfNotExists(ctx context.Context, conn *sql.Conn) error {
+	if l.schema == "public" {
+		return fmt.Errorf("progress ledger schema must not be the public schema")
+	}
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf(`
+		CREATE SCHEMA IF NOT EXISTS %s;
+		CREATE TABLE IF NOT EXISTS %s(
+			plan_id TEXT NOT NULL,
+			statement_idx INT NOT NULL,
+			current_schema_hash TEXT NOT NULL,
+			statement JSONB NOT NULL,
+			status TEXT NOT NULL,
+			schema_hash_after TEXT,
+			error_message TEXT,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT current_timestamp,
+			started_at TIMESTAMPTZ,
+			finished_at TIMESTAMPTZ,
+			PRIMARY KEY (plan_id, statement_idx)
+		);
+	`, pgx.Identifier{l.schema}.Sanitize(), l.sanitizedTableName())); err != nil {
+		return fmt.Errorf("creating progress ledger: %w", err)
+	}
+	return nil
+}
+
+// start records the plan's statements as pending, overwriting any progress previously recorded for the plan
+func (l progressLedger) start(ctx context.Context, conn *sql.Conn, planID string, plan Plan) (retErr error) {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("starting transaction: %w", err)
+	}
+	defer func() {
+		if retErr != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE plan_id = $1", l.sanitizedTableName()), planID); err != nil {
+		return fmt.Errorf("deleting previously recorded progress: %w", err)
+	}
+	for i, stmt := range plan.Statements {
+		stmtJSON, err := json.Marshal(stmt)
+		if err != nil {
+			return fmt.Errorf("marshalling statement %d: %w", i, err)
+		}
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(`
+			INSERT INTO %s(plan_id, statement_idx, current_schema_hash, statement, status)
+			VALUES ($1, $2, $3, $4, $5)
+		`, l.sanitizedTableName()), planID, i, plan.CurrentSchemaHash, string(stmtJSON), StatementStatusPending); err != nil {
+			return fmt.Errorf("recording statement %d: %w", i, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("committing transaction: %w", err)
+	}
+	return nil
+}
+
+func (l progressLedger) getEntries(ctx context.Context, conn *sql.Conn, planID string) ([]progressLedgerEntry, error) {
+	rows, err := conn.QueryContext(ctx, fmt.Sprintf(`
+		SELECT current_schema_hash, statement, status, COALESCE(schema_hash_after, '')
+		FROM %s
+		WHERE plan_id = $1
+		ORDER BY statement_idx
+	`, l.sanitizedTableName()), planID)
+	if err != nil {
+		return nil, fmt.Errorf("querying progress ledger: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []progressLedgerEntry
+	for rows.Next() {
+		var entry progressLedgerEntry
+		var stmtJSON string
+		if err := rows.Scan(&entry.currentSchemaHash, &stmtJSON, &entry.status, &entry.schemaHashAfter); err != nil {
+			return nil, fmt.Errorf("scanning progress ledger entry: %w", err)
+		}
+		if err := json.Unmarshal([]byte(stmtJSON), &entry.statement); err != nil {
+			return nil, fmt.Errorf("unmarshalling statement: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating progress ledger entries: %w", err)
+	}
+	return entries, nil
+}
+
+// getResumePoint returns the index of the first statement that did not succeed and the schema hash expected before
+// it is executed
+func (l progressLedger) getResumePoint(ctx context.Context, conn *sql.Conn, planID string, plan Plan) (int, string, error) {
+	if exists, err := l.exists(ctx, conn); err != nil {
+		return 0, "", err
+	} else if !exists {
+		return 0, "", ErrNoProgressRecorded
+	}
+	entries, err := l.getEntries(ctx, conn, planID)
+	if err != nil {
+		return 0, "", err
+	}
+	if len(entries) == 0 {
+		return 0, "", ErrNoProgressRecorded
+	}
+	if len(entries) != len(plan.Statements) {
+		return 0, "", fmt.Errorf("progress ledger has %d statements but the plan has %d", len(entries), len(plan.Statements))
+	}
+
+	expectedSchemaHash := plan.CurrentSchemaHash
+	for i, entry := range entries {
+		if entry.status != StatementStatusSucceeded {
+			return i, expectedSchemaHash, nil
+		}
+		if len(entry.schemaHashAfter) > 0 {
+			expectedSchemaHash = entry.schemaHashAfter
+		}
+	}
+	return len(entries), expectedSchemaHash, nil
+}
+
+func (l progressLedger) markRunning(ctx context.Context, conn *sql.Conn, planID string, stmtIdx int) error {
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf(`
+		UPDATE %s
+		SET status = $3, started_at = current_timestamp, finished_at = NULL, error_message = NULL
+		WHERE plan_id = $1 AND statement_idx = $2
+	`, l.sanitizedTableName()), planID, stmtIdx, StatementStatusRunning); err != nil {
+		return fmt.Errorf("recording statement %d as running: %w", stmtIdx, err)
+	}
+	return nil
+}
+
+// markSucceeded records the statement as succeeded. schemaHashAfter is empty if the schema was not hashed after the
+// statement, i.e., if the statement is not the last of its transaction
+func (l progressLedger) markSucceeded(ctx context.Context, conn *sql.Conn, planID string, stmtIdx int, schemaHashAfter string) error {
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf(`
+		UPDATE %s
+		SET status = $3, schema_hash_after = NULLIF($4, ''), finished_at = current_timestamp
+		WHERE plan_id = $1 AND statement_idx = $2
+	`, l.sanitizedTableName()), planID, stmtIdx, StatementStatusSucceeded, schemaHashAfter); err != nil {
+		return fmt.Errorf("recording statement %d as succeeded: %w", stmtIdx, err)
+	}
+	return nil
+}
+
+func (l progressLedger) markFailed(ctx context.Context, conn *sql.Conn, planID string, stmtIdx int, stmtErr error) error {
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf(`
+		UPDATE %s
+		SET status = $3, error_message = $4, finished_at = current_timestamp
+		WHERE plan_id = $1 AND statement_idx = $2
+	`, l.sanitizedTableName()), planID, stmtIdx, StatementStatusFailed, stmtErr.Error()); err != nil {
+		return fmt.Errorf("recording statement %d as failed: %w", stmtIdx, err)
+	}
+	return nil
+}
+
+// getPlanID returns an id that identifies the plan by its statements and the schema it expects
+func getPlanID(plan Plan) (string, error) {
+	hashVal, err := hashstructure.Hash(plan, hashstructure.FormatV2, nil)
+	if err != nil {
+		return "", fmt.Errorf("hashing plan: %w", err)
+	}
+	return fmt.Sprintf("%x", hashVal), nil
+}
@@ -106,6 +106,39 @@ func TestPlan_ApplyStatementTimeoutModifier(t *testing.T) {
 	}
 }
 
+func TestPlan_ApplyLockTimeoutModifier(t *testing.T) {
+	plan := diff.Plan{
+		Statements: []diff.Statement{
+			{
+				DDL:     "DROP INDEX CONCURRENTLY some_idx",
+				Timeout: 20 * time.Minute,
+			},
+			{
+				DDL:         "ALTER TABLE foobar ALTER COLUMN foo SET NOT NULL",
+				Timeout:     3 * time.Second,
+				LockTimeout: time.Second,
+			},
+		},
+		CurrentSchemaHash: "some-hash",
+	}
+	expectedPlan := diff.Plan{
+		Statements: []diff.Statement{
+			{
+				DDL:         "DROP INDEX CONCURRENTLY some_idx",
+				Timeout:     20 * time.Minute,
+				LockTimeout: time.Hour,
+			},
+			{
+				DDL:         "ALTER TABLE foobar ALTER COLUMN foo SET NOT NULL",
+				Timeout:     3 * time.Second,
+				LockTimeout: time.Second,
+			},
+		},
+		CurrentSchemaHash: "some-hash",
+	}
+	assert.Equal(t, expectedPlan, plan.ApplyLockTimeoutModifier(regexp.MustCompile("CONCURRENTLY"), time.Hour))
+}
+
 func TestPlan_InsertStatement(t *testing.T) {
 	var statementToInsert = diff.Statement{
 		DDL:     "some DDL",
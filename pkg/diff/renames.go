@@ -0,0 +1,491 @@
+package diff
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/stripe/pg-schema-diff/internal/schema"
+)
+
+type (
+	renameObjectType string
+
+	// Rename represents a schema object that has been renamed in the new schema. Renamed objects are renamed in place
+	// rather than dropped and re-created, e.g., renaming a column preserves its data. Use TableRename, ColumnRename and
+	// IndexRename to build a Rename
+	Rename struct {
+		objectType renameObjectType
+		// tableName is the name of the table in the new schema that owns the column. It is only set for column renames
+		tableName string
+		oldName   string
+		newName   string
+	}
+)
+
+const (
+	renameObjectTypeTable  renameObjectType = "table"
+	renameObjectTypeColumn renameObjectType = "column"
+	renameObjectTypeIndex  renameObjectType = "index"
+)
+
+var (
+	// sqlTokenRegex matches the tokens of a SQL expression that might be identifiers: quoted identifiers, string literals
+	// and words. String literals are matched, so they can be skipped
+	sqlTokenRegex = regexp.MustCompile(`"(?:[^"]|"")*"|'(?:[^']|'')*'|[A-Za-z_][A-Za-z0-9_$]*`)
+	// trailingIdentifierRegex matches the identifier at the end of a SQL expression, e.g., the qualifier before a "."
+	trailingIdentifierRegex = regexp.MustCompile(`("(?:[^"]|"")*"|[A-Za-z_][A-Za-z0-9_$]*)$`)
+	// simpleIdentifierRegex matches identifiers that Postgres does not quote when deparsing. Keywords are not accounted
+	// for
+	simpleIdentifierRegex = regexp.MustCompile(`^[a-z_][a-z0-9_$]*$`)
+
+	// indexDefStmtRegex splits the output of pg_get_indexdef into: the prefix, the index name, the "ON" clause, the
+	// optional schema qualifier of the table, the table name and the definition of the index
+	indexDefStmtRegex = regexp.MustCompile(`^(CREATE (?:UNIQUE )?INDEX )("(?:[^"]|"")*"|[^ "]+)( ON (?:ONLY )?)((?:"(?:[^"]|"")*"|[^ ".]+)\.)?("(?:[^"]|"")*"|[^ ".]+)( USING .*)$`)
+	// triggerDefStmtRegex splits the output of pg_get_triggerdef into: the prefix, the trigger name, the events up to
+	// and including the "ON" clause, the optional schema qualifier of the table, the table name and the remainder of
+	// the definition
+	triggerDefStmtRegex = regexp.MustCompile(`^(CREATE (?:CONSTRAINT )?TRIGGER )("(?:[^"]|"")*"|[^ "]+)( .*? ON )((?:"(?:[^"]|"")*"|[^ ".]+)\.)?("(?:[^"]|"")*"|[^ ".]+)( .*)$`)
+)
+
+// TableRename builds a rename of the table oldName in the current schema to newName
+func TableRename(oldName, newName string) Rename {
+	return Rename{objectType: renameObjectTypeTable, oldName: oldName, newName: newName}
+}
+
+// ColumnRename builds a rename of the column oldName to newName. tableName is the name of the table in the new schema,
+// i.e., after any table renames are applied
+func ColumnRename(tableName, oldName, newName string) Rename {
+	return Rename{objectType: renameObjectTypeColumn, tableName: tableName, oldName: oldName, newName: newName}
+}
+
+// IndexRename builds a rename of the index oldName in the current schema to newName
+func IndexRename(oldName, newName string) Rename {
+	return Rename{objectType: renameObjectTypeIndex, oldName: oldName, newName: newName}
+}
+
+func (r Rename) String() string {
+	if r.objectType == renameObjectTypeColumn {
+		return fmt.Sprintf("%s %s.%s to %s", r.objectType, r.tableName, r.oldName, r.newName)
+	}
+	return fmt.Sprintf("%s %s to %s", r.objectType, r.oldName, r.newName)
+}
+
+// applyRenames applies the renames to the schema, returning the renamed schema and the statements required to rename
+// the objects in the database. Table renames are applied first, then column renames and then index renames.
+//
+// A rename is skipped if it has already been applied to the schema, i.e., the object with the old name no longer
+// exists and the object with the new name does. This makes it safe to re-plan against a database that has already
+// been migrated
+func applyRenames(s schema.Schema, renames []Rename) (schema.Schema, []Statement, error) {
+	var stmts []Statement
+	for _, objectType := range []renameObjectType{renameObjectTypeTable, renameObjectTypeColumn, renameObjectTypeIndex} {
+		for _, rename := range renames {
+			if rename.objectType != objectType {
+				continue
+			}
+			var err error
+			var stmt *Statement
+			switch rename.objectType {
+			case renameObjectTypeTable:
+				s, stmt, err = applyTableRename(s, rename)
+			case renameObjectTypeColumn:
+				s, stmt, err = applyColumnRename(s, rename)
+			case renameObjectTypeIndex:
+				s, stmt, err = applyIndexRename(s, rename)
+			}
+			if err != nil {
+				return schema.Schema{}, nil, fmt.Errorf("applying rename of %s: %w", rename, err)
+			}
+			if stmt != nil {
+				stmts = append(stmts, *stmt)
+			}
+		}
+	}
+	return s, stmts, nil
+}
+
+func applyTableRename(s schema.Schema, rename Rename) (schema.Schema, *Statement, error) {
+	if skip, err := shouldSkipRename(s.Tables, rename); err != nil {
+		return schema.Schema{}, nil, err
+	} else if skip {
+		return s, nil, nil
+	}
+
+	var tables []schema.Table
+	for _, table := range s.Tables {
+		if table.Name == rename.oldName {
+			table.Name = rename.newName
+		}
+		if table.ParentTableName == rename.oldName {
+			table.ParentTableName = rename.newName
+		}
+		tables = append(tables, table)
+	}
+	s.Tables = tables
+
+	var indexes []schema.Index
+	for _, index := range s.Indexes {
+		if index.TableName == rename.oldName {
+			index.TableName = rename.newName
+			index.GetIndexDefStmt = schema.GetIndexDefStatement(renameTableInDefStmt(
+				indexDefStmtRegex, string(index.GetIndexDefStmt), rename.newName,
+			))
+		}
+		indexes = append(indexes, index)
+	}
+	s.Indexes = indexes
+
+	var triggers []schema.Trigger
+	for _, trigger := range s.Triggers {
+		if trigger.OwningTableUnescapedName == rename.oldName {
+			trigger.OwningTableUnescapedName = rename.newName
+			trigger.OwningTable.EscapedName = schema.EscapeIdentifier(rename.newName)
+			trigger.GetTriggerDefStmt = schema.GetTriggerDefStatement(renameTableInDefStmt(
+				triggerDefStmtRegex, string(trigger.GetTriggerDefStmt), rename.newName,
+			))
+		}
+		triggers = append(triggers, trigger)
+	}
+	s.Triggers = triggers
+
+	hazards := []MigrationHazard{{
+		Type: MigrationHazardTypeHasUntrackableDependencies,
+		Message: "Renaming the table will break any queries, functions or triggers that reference the table by " +
+			"its old name.",
+	}}
+	// Expressions can only reference other tables through functions, so only the functions are searched
+	if references := getFunctionsReferencingIdentifiers(s.Functions, rename.oldName); len(references) > 0 {
+		hazards = append(hazards, buildRenamedObjectReferencedHazard(rename, references))
+	}
+
+	return s, &Statement{
+		DDL:     fmt.Sprintf("%s RENAME TO %s", alterTablePrefix(rename.oldName), schema.EscapeIdentifier(rename.newName)),
+		Timeout: statementTimeoutDefault,
+		Hazards: hazards,
+	}, nil
+}
+
+func applyColumnRename(s schema.Schema, rename Rename) (schema.Schema, *Statement, error) {
+	tablesByName := buildSchemaObjMap(s.Tables)
+	table, ok := tablesByName[rename.tableName]
+	if !ok {
+		return schema.Schema{}, nil, fmt.Errorf("table %q not found", rename.tableName)
+	}
+	if table.IsPartition() {
+		return schema.Schema{}, nil, fmt.Errorf("renaming the columns of partitions: %w", ErrNotImplemented)
+	}
+	if skip, err := shouldSkipRename(table.Columns, rename); err != nil {
+		return schema.Schema{}, nil, err
+	} else if skip {
+		return s, nil, nil
+	}
+
+	// Renaming a column of a partitioned table also renames the column in all of its partitions
+	isRenamedTable := func(tableName string) bool {
+		return tableName == rename.tableName || tablesByName[tableName].ParentTableName == rename.tableName
+	}
+
+	var tables []schema.Table
+	for _, table := range s.Tables {
+		if !isRenamedTable(table.Name) {
+			tables = append(tables, table)
+			continue
+		}
+		var err error
+		var columns []schema.Column
+		for _, column := range table.Columns {
+			if column.Name == rename.oldName {
+				column.Name = rename.newName
+			}
+			// Defaults can't reference columns, but generated columns' expressions can
+			column.Default, err = renameIdentifierInSQL(column.Default, rename.oldName, rename.newName, table.Name)
+			if err != nil {
+				return schema.Schema{}, nil, fmt.Errorf("renaming column in the default of column %q: %w", column.Name, err)
+			}
+			columns = append(columns, column)
+		}
+		table.Columns = columns
+
+		var checkCons []schema.CheckConstraint
+		for _, checkCon := range table.CheckConstraints {
+			checkCon.Expression, err = renameIdentifierInSQL(checkCon.Expression, rename.oldName, rename.newName, table.Name)
+			if err != nil {
+				return schema.Schema{}, nil, fmt.Errorf("renaming column in check constraint %q: %w", checkCon.Name, err)
+			}
+			checkCons = append(checkCons, checkCon)
+		}
+		table.CheckConstraints = checkCons
+
+		table.PartitionKeyDef, err = renameIdentifierInSQL(table.PartitionKeyDef, rename.oldName, rename.newName, table.Name)
+		if err != nil {
+			return schema.Schema{}, nil, fmt.Errorf("renaming column in the partition key of table %q: %w", table.Name, err)
+		}
+		table.PartitionConstraintDef, err = renameIdentifierInSQL(table.PartitionConstraintDef, rename.oldName, rename.newName, table.Name)
+		if err != nil {
+			return schema.Schema{}, nil, fmt.Errorf("renaming column in the partition constraint of table %q: %w", table.Name, err)
+		}
+		tables = append(tables, table)
+	}
+	s.Tables = tables
+
+	var indexes []schema.Index
+	for _, index := range s.Indexes {
+		if isRenamedTable(index.TableName) {
+			var columns []string
+			for _, column := range index.Columns {
+				if column == rename.oldName {
+					column = rename.newName
+				}
+				columns = append(columns, column)
+			}
+			index.Columns = columns
+			indexDefStmt, err := renameIdentifierInDefStmt(
+				indexDefStmtRegex, string(index.GetIndexDefStmt), rename.oldName, rename.newName, index.TableName,
+			)
+			if err != nil {
+				return schema.Schema{}, nil, fmt.Errorf("renaming column in index %q: %w", index.Name, err)
+			}
+			index.GetIndexDefStmt = schema.GetIndexDefStatement(indexDefStmt)
+		}
+		indexes = append(indexes, index)
+	}
+	s.Indexes = indexes
+
+	var triggers []schema.Trigger
+	for _, trigger := range s.Triggers {
+		if isRenamedTable(trigger.OwningTableUnescapedName) {
+			// The trigger's WHEN condition references the columns via the old and new rows
+			triggerDefStmt, err := renameIdentifierInDefStmt(
+				triggerDefStmtRegex, string(trigger.GetTriggerDefStmt), rename.oldName, rename.newName,
+				trigger.OwningTableUnescapedName, "old", "new",
+			)
+			if err != nil {
+				return schema.Schema{}, nil, fmt.Errorf("renaming column in trigger %s: %w", trigger.EscapedName, err)
+			}
+			trigger.GetTriggerDefStmt = schema.GetTriggerDefStatement(triggerDefStmt)
+		}
+		triggers = append(triggers, trigger)
+	}
+	s.Triggers = triggers
+
+	hazards := []MigrationHazard{{
+		Type: MigrationHazardTypeHasUntrackableDependencies,
+		Message: "Renaming the column will break any queries, functions or triggers that reference the column by " +
+			"its old name.",
+	}}
+	// Column names are often shared across tables, so only functions that also reference the table are reported
+	if references := getFunctionsReferencingIdentifiers(s.Functions, rename.tableName, rename.oldName); len(references) > 0 {
+		hazards = append(hazards, buildRenamedObjectReferencedHazard(rename, references))
+	}
+
+	return s, &Statement{
+		DDL: fmt.Sprintf("%s RENAME COLUMN %s TO %s",
+			alterTablePrefix(rename.tableName),
+			schema.EscapeIdentifier(rename.oldName),
+			schema.EscapeIdentifier(rename.newName),
+		),
+		Timeout: statementTimeoutDefault,
+		Hazards: hazards,
+	}, nil
+}
+
+func applyIndexRename(s schema.Schema, rename Rename) (schema.Schema, *Statement, error) {
+	if skip, err := shouldSkipRename(s.Indexes, rename); err != nil {
+		return schema.Schema{}, nil, err
+	} else if skip {
+		return s, nil, nil
+	}
+
+	var indexes []schema.Index
+	for _, index := range s.Indexes {
+		if index.Name == rename.oldName {
+			index.Name = rename.newName
+			if index.ConstraintName == rename.oldName {
+				// Renaming an index also renames the constraint it backs
+				index.ConstraintName = rename.newName
+			}
+			index.GetIndexDefStmt = schema.GetIndexDefStatement(renameObjectInDefStmt(
+				indexDefStmtRegex, string(index.GetIndexDefStmt), rename.newName,
+			))
+		}
+		if index.ParentIdxName == rename.oldName {
+			index.ParentIdxName = rename.newName
+		}
+		indexes = append(indexes, index)
+	}
+	s.Indexes = indexes
+
+	return s, &Statement{
+		DDL:     fmt.Sprintf("ALTER INDEX %s RENAME TO %s", schema.EscapeIdentifier(rename.oldName), schema.EscapeIdentifier(rename.newName)),
+		Timeout: statementTimeoutDefault,
+	}, nil
+}
+
+// getFunctionsReferencingIdentifiers returns the names of the functions whose definitions might reference all the
+// identifiers. Function bodies are not parsed, so this is best-effort
+func getFunctionsReferencingIdentifiers(functions []schema.Function, names ...string) []string {
+	var functionNames []string
+	for _, function := range functions {
+		referencesAll := true
+		for _, name := range names {
+			if !sqlReferencesIdentifier(function.FunctionDef, name) {
+				referencesAll = false
+				break
+			}
+		}
+		if referencesAll {
+			functionNames = append(functionNames, function.GetFQEscapedName())
+		}
+	}
+	return functionNames
+}
+
+func buildRenamedObjectReferencedHazard(rename Rename, functionNames []string) MigrationHazard {
+	return MigrationHazard{
+		Type: MigrationHazardTypeHasUntrackableDependencies,
+		Message: fmt.Sprintf("The %s might be referenced by its old name in the following functions, which are not "+
+			"updated by the rename: %s. Change the functions in the new schema to reference the new name.",
+			rename.objectType, strings.Join(functionNames, ", ")),
+	}
+}
+
+// shouldSkipRename returns true if the rename has already been applied to the objects. It returns an error if the
+// rename can't be applied
+func shouldSkipRename[S schema.Object](objects []S, rename Rename) (bool, error) {
+	objectsByName := buildSchemaObjMap(objects)
+	_, hasOld := objectsByName[rename.oldName]
+	_, hasNew := objectsByName[rename.newName]
+	switch {
+	case hasOld && hasNew:
+		return false, fmt.Errorf("%s %q already exists", rename.objectType, rename.newName)
+	case hasOld:
+		return false, nil
+	case hasNew:
+		return true, nil
+	default:
+		return false, fmt.Errorf("%s %q not found", rename.objectType, rename.oldName)
+	}
+}
+
+// renameObjectInDefStmt renames the object defined by the statement, e.g., the index created by a CREATE INDEX
+// statement. If the statement can't be parsed, it is returned as-is
+func renameObjectInDefStmt(defStmtRegex *regexp.Regexp, defStmt, newName string) string {
+	matches := defStmtRegex.FindStringSubmatch(defStmt)
+	if len(matches) == 0 {
+		return defStmt
+	}
+	matches[2] = formatIdentifierLikePostgres(newName)
+	return strings.Join(matches[1:], "")
+}
+
+// renameTableInDefStmt renames the table the statement's object is defined on. If the statement can't be parsed,
+// it is returned as-is
+func renameTableInDefStmt(defStmtRegex *regexp.Regexp, defStmt, newTableName string) string {
+	matches := defStmtRegex.FindStringSubmatch(defStmt)
+	if len(matches) == 0 {
+		return defStmt
+	}
+	matches[5] = formatIdentifierLikePostgres(newTableName)
+	return strings.Join(matches[1:], "")
+}
+
+// renameIdentifierInDefStmt renames a column in the parts of the statement that define the object, i.e., excluding the
+// name of the object and the name of its table. See renameIdentifierInSQL for the qualifiers. If the statement can't be
+// parsed, it is returned as-is
+func renameIdentifierInDefStmt(defStmtRegex *regexp.Regexp, defStmt, oldName, newName string, qualifiers ...string) (string, error) {
+	matches := defStmtRegex.FindStringSubmatch(defStmt)
+	if len(matches) == 0 {
+		return defStmt, nil
+	}
+	for _, i := range []int{3, 6} {
+		renamed, err := renameIdentifierInSQL(matches[i], oldName, newName, qualifiers...)
+		if err != nil {
+			return "", err
+		}
+		matches[i] = renamed
+	}
+	return strings.Join(matches[1:], ""), nil
+}
+
+// renameIdentifierInSQL renames the references to a column in the SQL, which is expected to be deparsed by Postgres,
+// e.g., the output of pg_get_constraintdef. Unqualified references and references qualified by one of the qualifiers,
+// e.g., the column's table, are renamed. Identifiers with the same name that can't be the column, i.e., functions,
+// types and qualifiers, are left as-is. If the name is qualified by anything else, e.g., another table or a composite
+// value, it can't be resolved to the column, so ErrNotImplemented is returned
+func renameIdentifierInSQL(sql, oldName, newName string, qualifiers ...string) (string, error) {
+	sb := strings.Builder{}
+	lastEnd := 0
+	for _, loc := range sqlTokenRegex.FindAllStringIndex(sql, -1) {
+		if !isIdentifierToken(sql[loc[0]:loc[1]], oldName) {
+			continue
+		}
+		before := strings.TrimRight(sql[:loc[0]], " \t\n")
+		after := strings.TrimLeft(sql[loc[1]:], " \t\n")
+		if strings.HasPrefix(after, "(") || strings.HasPrefix(after, ".") || strings.HasSuffix(before, "::") {
+			continue
+		}
+		if strings.HasSuffix(before, ".") {
+			if isRenamed, err := isQualifiedReferenceToColumn(strings.TrimRight(strings.TrimSuffix(before, "."), " \t\n"), qualifiers); err != nil {
+				return "", fmt.Errorf("resolving %q in %q: %w", oldName, sql, err)
+			} else if !isRenamed {
+				continue
+			}
+		}
+		sb.WriteString(sql[lastEnd:loc[0]])
+		sb.WriteString(formatIdentifierLikePostgres(newName))
+		lastEnd = loc[1]
+	}
+	sb.WriteString(sql[lastEnd:])
+	return sb.String(), nil
+}
+
+// isQualifiedReferenceToColumn returns true if the qualifier at the end of the SQL preceding a "." is one of the
+// qualifiers of the column. It returns false if the qualified name is a type, e.g., ::public.foo, since it can't be the
+// column. Otherwise, the qualified name can't be resolved, and ErrNotImplemented is returned
+func isQualifiedReferenceToColumn(sqlBeforeDot string, qualifiers []string) (bool, error) {
+	qualifier := trailingIdentifierRegex.FindString(sqlBeforeDot)
+	if len(qualifier) == 0 {
+		return false, fmt.Errorf("field selection from an expression: %w", ErrNotImplemented)
+	}
+	if strings.HasSuffix(strings.TrimRight(strings.TrimSuffix(sqlBeforeDot, qualifier), " \t\n"), "::") {
+		return false, nil
+	}
+	for _, q := range qualifiers {
+		if isIdentifierToken(qualifier, q) {
+			return true, nil
+		}
+	}
+	return false, fmt.Errorf("qualified by %s: %w", qualifier, ErrNotImplemented)
+}
+
+// sqlReferencesIdentifier returns true if the SQL, which is expected to be deparsed by Postgres, might reference the
+// identifier. Like renameIdentifierInSQL, this is best-effort and might return false positives
+func sqlReferencesIdentifier(sql, name string) bool {
+	for _, token := range sqlTokenRegex.FindAllString(sql, -1) {
+		if isIdentifierToken(token, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// isIdentifierToken returns true if the token is the identifier, either quoted or, if it is a simple identifier,
+// unquoted
+func isIdentifierToken(token, name string) bool {
+	return token == quoteIdentifier(name) || (token == name && simpleIdentifierRegex.MatchString(name))
+}
+
+// formatIdentifierLikePostgres formats the identifier the way Postgres does when deparsing, i.e., it is only quoted
+// if it is not a simple identifier. Keywords are not accounted for
+func formatIdentifierLikePostgres(name string) string {
+	if simpleIdentifierRegex.MatchString(name) {
+		return name
+	}
+	return quoteIdentifier(name)
+}
+
+// quoteIdentifier quotes the identifier, escaping any double quotes within it
+func quoteIdentifier(name string) string {
+	return schema.EscapeIdentifier(strings.ReplaceAll(name, "\"", "\"\""))
+}
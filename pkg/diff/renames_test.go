@@ -0,0 +1,293 @@
+package diff
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/pg-schema-diff/internal/schema"
+)
+
+func TestGenerateMigrationStatementsWithRenames(t *testing.T) {
+	oldSchema := schema.Schema{
+		Name: "public",
+		Tables: []schema.Table{
+			{
+				Name: "foobar",
+				Columns: []schema.Column{
+					{Name: "id", Type: "integer"},
+					{Name: "foo", Type: "text", Collation: defaultCollation},
+				},
+				CheckConstraints: []schema.CheckConstraint{
+					{Name: "foo_check", Expression: "(length(foo) > 0)", IsValid: true, IsInheritable: true},
+				},
+			},
+		},
+		Indexes: []schema.Index{
+			{
+				TableName: "foobar",
+				Name:      "foobar_pkey", Columns: []string{"id"}, IsPk: true, IsUnique: true, ConstraintName: "foobar_pkey",
+				GetIndexDefStmt: "CREATE UNIQUE INDEX foobar_pkey ON public.foobar USING btree (id)",
+			},
+			{
+				TableName: "foobar",
+				Name:      "foo_idx", Columns: []string{"foo"},
+				GetIndexDefStmt: "CREATE INDEX foo_idx ON public.foobar USING btree (foo)",
+			},
+		},
+		Triggers: []schema.Trigger{
+			{
+				EscapedName:              "\"some_trigger\"",
+				OwningTable:              schema.SchemaQualifiedName{SchemaName: "public", EscapedName: "\"foobar\""},
+				OwningTableUnescapedName: "foobar",
+				Function:                 schema.SchemaQualifiedName{SchemaName: "public", EscapedName: "\"increment_version\"()"},
+				GetTriggerDefStmt:        "CREATE TRIGGER some_trigger BEFORE UPDATE OF foo ON public.foobar FOR EACH ROW WHEN ((old.foo IS DISTINCT FROM new.foo)) EXECUTE FUNCTION increment_version()",
+			},
+		},
+	}
+	newSchema := schema.Schema{
+		Name: "public",
+		Tables: []schema.Table{
+			{
+				Name: "fizzbuzz",
+				Columns: []schema.Column{
+					{Name: "id", Type: "integer"},
+					{Name: "Bar", Type: "text", Collation: defaultCollation},
+				},
+				CheckConstraints: []schema.CheckConstraint{
+					{Name: "foo_check", Expression: "(length(\"Bar\") > 0)", IsValid: true, IsInheritable: true},
+				},
+			},
+		},
+		Indexes: []schema.Index{
+			{
+				TableName: "fizzbuzz",
+				Name:      "foobar_pkey", Columns: []string{"id"}, IsPk: true, IsUnique: true, ConstraintName: "foobar_pkey",
+				GetIndexDefStmt: "CREATE UNIQUE INDEX foobar_pkey ON public.fizzbuzz USING btree (id)",
+			},
+			{
+				TableName: "fizzbuzz",
+				Name:      "bar_idx", Columns: []string{"Bar"},
+				GetIndexDefStmt: "CREATE INDEX bar_idx ON public.fizzbuzz USING btree (\"Bar\")",
+			},
+		},
+		Triggers: []schema.Trigger{
+			{
+				EscapedName:              "\"some_trigger\"",
+				OwningTable:              schema.SchemaQualifiedName{SchemaName: "public", EscapedName: "\"fizzbuzz\""},
+				OwningTableUnescapedName: "fizzbuzz",
+				Function:                 schema.SchemaQualifiedName{SchemaName: "public", EscapedName: "\"increment_version\"()"},
+				GetTriggerDefStmt:        "CREATE TRIGGER some_trigger BEFORE UPDATE OF \"Bar\" ON public.fizzbuzz FOR EACH ROW WHEN ((old.\"Bar\" IS DISTINCT FROM new.\"Bar\")) EXECUTE FUNCTION increment_version()",
+			},
+		},
+	}
+	planOptions := &planOptions{
+		ignoreChangesToColOrder: true,
+		renames: []Rename{
+			IndexRename("foo_idx", "bar_idx"),
+			ColumnRename("fizzbuzz", "foo", "Bar"),
+			TableRename("foobar", "fizzbuzz"),
+		},
+	}
+
+	stmts, err := generateMigrationStatements(oldSchema, newSchema, planOptions)
+	require.NoError(t, err)
+	assert.Equal(t, []Statement{
+		{
+			DDL:     "ALTER TABLE \"foobar\" RENAME TO \"fizzbuzz\"",
+			Timeout: statementTimeoutDefault,
+			Hazards: []MigrationHazard{{
+				Type: MigrationHazardTypeHasUntrackableDependencies,
+				Message: "Renaming the table will break any queries, functions or triggers that reference the table by " +
+					"its old name.",
+			}},
+		},
+		{
+			DDL:     "ALTER TABLE \"fizzbuzz\" RENAME COLUMN \"foo\" TO \"Bar\"",
+			Timeout: statementTimeoutDefault,
+			Hazards: []MigrationHazard{{
+				Type: MigrationHazardTypeHasUntrackableDependencies,
+				Message: "Renaming the column will break any queries, functions or triggers that reference the column by " +
+					"its old name.",
+			}},
+		},
+		{
+			DDL:     "ALTER INDEX \"foo_idx\" RENAME TO \"bar_idx\"",
+			Timeout: statementTimeoutDefault,
+		},
+	}, stmts)
+
+	// The renames have already been applied to the new schema, so they should be skipped
+	stmts, err = generateMigrationStatements(newSchema, newSchema, planOptions)
+	require.NoError(t, err)
+	assert.Empty(t, stmts)
+}
+
+func TestApplyRenamesErrors(t *testing.T) {
+	s := schema.Schema{
+		Name: "public",
+		Tables: []schema.Table{
+			{Name: "foo", Columns: []schema.Column{{Name: "id", Type: "integer"}}},
+			{Name: "bar", Columns: []schema.Column{{Name: "id", Type: "integer"}}},
+			{
+				Name:    "fizzbuzz",
+				Columns: []schema.Column{{Name: "id", Type: "integer"}, {Name: "pair", Type: "some_pair"}},
+				CheckConstraints: []schema.CheckConstraint{
+					{Name: "pair_check", Expression: "((pair).id > 0)", IsValid: true, IsInheritable: true},
+				},
+			},
+		},
+	}
+
+	for _, tc := range []struct {
+		name          string
+		rename        Rename
+		errorContains string
+	}{
+		{
+			name:          "Renamed object not found",
+			rename:        TableRename("fizz", "buzz"),
+			errorContains: "table \"fizz\" not found",
+		},
+		{
+			name:          "Renamed object conflicts with existing object",
+			rename:        TableRename("foo", "bar"),
+			errorContains: "table \"bar\" already exists",
+		},
+		{
+			name:          "Table of renamed column not found",
+			rename:        ColumnRename("fizz", "id", "other_id"),
+			errorContains: "table \"fizz\" not found",
+		},
+		{
+			name:          "Renamed column name used in an ambiguous context",
+			rename:        ColumnRename("fizzbuzz", "id", "other_id"),
+			errorContains: "renaming column in check constraint \"pair_check\"",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, _, err := applyRenames(s, []Rename{tc.rename})
+			assert.ErrorContains(t, err, tc.errorContains)
+		})
+	}
+}
+
+func TestApplyRenamesFlagsReferencingFunctions(t *testing.T) {
+	s := schema.Schema{
+		Name: "public",
+		Tables: []schema.Table{
+			{Name: "foobar", Columns: []schema.Column{{Name: "id", Type: "integer"}, {Name: "foo", Type: "text"}}},
+		},
+		Functions: []schema.Function{
+			{
+				SchemaQualifiedName: schema.SchemaQualifiedName{SchemaName: "public", EscapedName: "\"count_foo\"()"},
+				FunctionDef: "CREATE OR REPLACE FUNCTION public.count_foo()\n RETURNS bigint\n LANGUAGE sql\n" +
+					"AS $function$ SELECT count(foo) FROM foobar $function$\n",
+				Language: "sql",
+			},
+			{
+				SchemaQualifiedName: schema.SchemaQualifiedName{SchemaName: "public", EscapedName: "\"add\"(a integer, b integer)"},
+				FunctionDef: "CREATE OR REPLACE FUNCTION public.add(a integer, b integer)\n RETURNS integer\n LANGUAGE sql\n" +
+					"AS $function$ SELECT a + b $function$\n",
+				Language: "sql",
+			},
+		},
+	}
+
+	_, stmts, err := applyRenames(s, []Rename{
+		TableRename("foobar", "fizzbuzz"),
+		ColumnRename("fizzbuzz", "foo", "bar"),
+	})
+	require.NoError(t, err)
+	require.Len(t, stmts, 2)
+	assert.Contains(t, stmts[0].Hazards, MigrationHazard{
+		Type: MigrationHazardTypeHasUntrackableDependencies,
+		Message: "The table might be referenced by its old name in the following functions, which are not updated by " +
+			"the rename: \"public\".\"count_foo\"(). Change the functions in the new schema to reference the new name.",
+	})
+	// The function references the old name of the table, so it is not flagged for the column rename
+	assert.Len(t, stmts[1].Hazards, 1)
+}
+
+func TestRenameIdentifierInSQL(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		sql         string
+		oldName     string
+		newName     string
+		qualifiers  []string
+		expected    string
+		expectedErr error
+	}{
+		{
+			name:     "Simple identifier",
+			sql:      "((foo > 0) AND (foobar > 0) AND ('foo' <> ''::text))",
+			oldName:  "foo",
+			newName:  "bar",
+			expected: "((bar > 0) AND (foobar > 0) AND ('foo' <> ''::text))",
+		},
+		{
+			name:     "Renamed to identifier requiring quotes",
+			sql:      "(length(foo) > 0)",
+			oldName:  "foo",
+			newName:  "Bar",
+			expected: "(length(\"Bar\") > 0)",
+		},
+		{
+			name:     "Identifier containing quotes",
+			sql:      "(length(\"some\"\"foo\") > 0)",
+			oldName:  "some\"foo",
+			newName:  "other\"foo",
+			expected: "(length(\"other\"\"foo\") > 0)",
+		},
+		{
+			name:     "Function and type with the same name",
+			sql:      "((foo(foo) > 0) AND ((foo)::foo IS NOT NULL) AND ((foo)::public.foo IS NOT NULL))",
+			oldName:  "foo",
+			newName:  "bar",
+			expected: "((foo(bar) > 0) AND ((bar)::foo IS NOT NULL) AND ((bar)::public.foo IS NOT NULL))",
+		},
+		{
+			name:       "Qualified by the table and the old and new rows",
+			sql:        "((old.foo IS DISTINCT FROM new.foo) AND (foobar.foo > 0) AND (public.foobar.foo > 0))",
+			oldName:    "foo",
+			newName:    "bar",
+			qualifiers: []string{"foobar", "old", "new"},
+			expected:   "((old.bar IS DISTINCT FROM new.bar) AND (foobar.bar > 0) AND (public.foobar.bar > 0))",
+		},
+		{
+			name:       "Identifier used as a qualifier",
+			sql:        "(foo.id > 0)",
+			oldName:    "foo",
+			newName:    "bar",
+			qualifiers: []string{"foobar"},
+			expected:   "(foo.id > 0)",
+		},
+		{
+			name:        "Qualified by another table",
+			sql:         "(fizzbuzz.foo > 0)",
+			oldName:     "foo",
+			newName:     "bar",
+			qualifiers:  []string{"foobar"},
+			expectedErr: ErrNotImplemented,
+		},
+		{
+			name:        "Field of a composite value",
+			sql:         "((pair).foo > 0)",
+			oldName:     "foo",
+			newName:     "bar",
+			qualifiers:  []string{"foobar"},
+			expectedErr: ErrNotImplemented,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			renamed, err := renameIdentifierInSQL(tc.sql, tc.oldName, tc.newName, tc.qualifiers...)
+			if tc.expectedErr != nil {
+				assert.ErrorIs(t, err, tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, renamed)
+		})
+	}
+}
@@ -14,6 +14,7 @@ type schemaMigrationPlanTestCase struct {
 	name               string
 	oldSchema          schema.Schema
 	newSchema          schema.Schema
+	planOpts           []PlanOpt
 	expectedStatements []Statement
 	expectedDiffErrIs  error
 }
@@ -245,25 +246,33 @@ var (
 					Hazards: nil,
 				},
 				{
-					DDL:     "CREATE INDEX CONCURRENTLY new_foo_idx ON public.foobar USING btree (foo)",
-					Timeout: statementTimeoutConcurrentIndexBuild,
-					Hazards: []MigrationHazard{buildIndexBuildHazard()},
+					DDL:                "CREATE INDEX CONCURRENTLY new_foo_idx ON public.foobar USING btree (foo)",
+					Timeout:            statementTimeoutConcurrentIndexBuild,
+					LockTimeout:        statementTimeoutConcurrentIndexBuild,
+					IsNonTransactional: true,
+					Hazards:            []MigrationHazard{buildIndexBuildHazard()},
 				},
 				{
-					DDL:     "CREATE INDEX CONCURRENTLY replaced_with_same_name_idx ON ONLY public.foobar USING btree (bar)",
-					Timeout: statementTimeoutConcurrentIndexBuild,
-					Hazards: []MigrationHazard{buildIndexBuildHazard()},
+					DDL:                "CREATE INDEX CONCURRENTLY replaced_with_same_name_idx ON ONLY public.foobar USING btree (bar)",
+					Timeout:            statementTimeoutConcurrentIndexBuild,
+					LockTimeout:        statementTimeoutConcurrentIndexBuild,
+					IsNonTransactional: true,
+					Hazards:            []MigrationHazard{buildIndexBuildHazard()},
 				},
 				{
-					DDL:     "DROP INDEX CONCURRENTLY \"foo_idx\"",
-					Timeout: statementTimeoutConcurrentIndexDrop,
+					DDL:                "DROP INDEX CONCURRENTLY \"foo_idx\"",
+					Timeout:            statementTimeoutConcurrentIndexDrop,
+					LockTimeout:        statementTimeoutConcurrentIndexDrop,
+					IsNonTransactional: true,
 					Hazards: []MigrationHazard{
 						{Type: "INDEX_DROPPED", Message: "Dropping this index means queries that use this index might perform worse because they will no longer will be able to leverage it."},
 					},
 				},
 				{
-					DDL:     "DROP INDEX CONCURRENTLY \"replaced_with_same_name_id_00010203-0405-4607-8809-0a0b0c0d0e0f\"",
-					Timeout: statementTimeoutConcurrentIndexDrop,
+					DDL:                "DROP INDEX CONCURRENTLY \"replaced_with_same_name_id_00010203-0405-4607-8809-0a0b0c0d0e0f\"",
+					Timeout:            statementTimeoutConcurrentIndexDrop,
+					LockTimeout:        statementTimeoutConcurrentIndexDrop,
+					IsNonTransactional: true,
 					Hazards: []MigrationHazard{
 						{Type: "INDEX_DROPPED", Message: "Dropping this index means queries that use this index might perform worse because they will no longer will be able to leverage it."},
 					},
@@ -317,9 +326,11 @@ var (
 			},
 			expectedStatements: []Statement{
 				{
-					DDL:     "DROP INDEX CONCURRENTLY \"some_idx\"",
-					Timeout: statementTimeoutConcurrentIndexDrop,
-					Hazards: []MigrationHazard{buildIndexDroppedQueryPerfHazard()},
+					DDL:                "DROP INDEX CONCURRENTLY \"some_idx\"",
+					Timeout:            statementTimeoutConcurrentIndexDrop,
+					LockTimeout:        statementTimeoutConcurrentIndexDrop,
+					IsNonTransactional: true,
+					Hazards:            []MigrationHazard{buildIndexDroppedQueryPerfHazard()},
 				},
 				{
 					DDL:     "ALTER TABLE \"foobar\" DROP COLUMN \"bar\"",
@@ -384,14 +395,18 @@ var (
 					Timeout: statementTimeoutDefault,
 				},
 				{
-					DDL:     "CREATE INDEX CONCURRENTLY some_idx ON public.foobar USING btree (foo, bar)",
-					Timeout: statementTimeoutConcurrentIndexBuild,
-					Hazards: []MigrationHazard{buildIndexBuildHazard()},
+					DDL:                "CREATE INDEX CONCURRENTLY some_idx ON public.foobar USING btree (foo, bar)",
+					Timeout:            statementTimeoutConcurrentIndexBuild,
+					LockTimeout:        statementTimeoutConcurrentIndexBuild,
+					IsNonTransactional: true,
+					Hazards:            []MigrationHazard{buildIndexBuildHazard()},
 				},
 				{
-					DDL:     "DROP INDEX CONCURRENTLY \"some_idx_10111213-1415-4617-9819-1a1b1c1d1e1f\"",
-					Timeout: statementTimeoutConcurrentIndexDrop,
-					Hazards: []MigrationHazard{buildIndexDroppedQueryPerfHazard()},
+					DDL:                "DROP INDEX CONCURRENTLY \"some_idx_10111213-1415-4617-9819-1a1b1c1d1e1f\"",
+					Timeout:            statementTimeoutConcurrentIndexDrop,
+					LockTimeout:        statementTimeoutConcurrentIndexDrop,
+					IsNonTransactional: true,
+					Hazards:            []MigrationHazard{buildIndexDroppedQueryPerfHazard()},
 				},
 			},
 		},
@@ -565,14 +580,18 @@ var (
 				{
 					DDL:     "CREATE INDEX new_some_idx ON ONLY public.foobar USING btree (foo, bar)",
 					Timeout: statementTimeoutDefault,
+					Hazards: []MigrationHazard{migrationHazardIndexBuiltOnOnlyPartitionedTable},
 				},
 				{
 					DDL:     "CREATE INDEX replaced_with_same_name_idx ON ONLY public.foobar USING btree (bar, foo)",
 					Timeout: statementTimeoutDefault,
+					Hazards: []MigrationHazard{migrationHazardIndexBuiltOnOnlyPartitionedTable},
 				},
 				{
-					DDL:     "CREATE INDEX CONCURRENTLY foobar_1_replaced_with_same_name_idx ON public.foobar USING btree (bar, foo)",
-					Timeout: statementTimeoutConcurrentIndexDrop,
+					DDL:                "CREATE INDEX CONCURRENTLY foobar_1_replaced_with_same_name_idx ON public.foobar USING btree (bar, foo)",
+					Timeout:            statementTimeoutConcurrentIndexDrop,
+					LockTimeout:        statementTimeoutConcurrentIndexDrop,
+					IsNonTransactional: true,
 					Hazards: []MigrationHazard{
 						buildIndexBuildHazard(),
 					},
@@ -583,8 +602,10 @@ var (
 					Hazards: nil,
 				},
 				{
-					DDL:     "CREATE INDEX CONCURRENTLY new_foobar_1_some_idx ON public.foobar_1 USING btree (foo, bar)",
-					Timeout: statementTimeoutConcurrentIndexBuild,
+					DDL:                "CREATE INDEX CONCURRENTLY new_foobar_1_some_idx ON public.foobar_1 USING btree (foo, bar)",
+					Timeout:            statementTimeoutConcurrentIndexBuild,
+					LockTimeout:        statementTimeoutConcurrentIndexBuild,
+					IsNonTransactional: true,
 					Hazards: []MigrationHazard{
 						buildIndexBuildHazard(),
 					},
@@ -595,15 +616,19 @@ var (
 					Hazards: nil,
 				},
 				{
-					DDL:     "CREATE INDEX CONCURRENTLY new_foobar_1_some_local_idx ON public.foobar_1 USING btree (foo, bar, id)",
-					Timeout: statementTimeoutConcurrentIndexBuild,
+					DDL:                "CREATE INDEX CONCURRENTLY new_foobar_1_some_local_idx ON public.foobar_1 USING btree (foo, bar, id)",
+					Timeout:            statementTimeoutConcurrentIndexBuild,
+					LockTimeout:        statementTimeoutConcurrentIndexBuild,
+					IsNonTransactional: true,
 					Hazards: []MigrationHazard{
 						buildIndexBuildHazard(),
 					},
 				},
 				{
-					DDL:     "CREATE INDEX CONCURRENTLY foobar_2_replaced_with_same_name_idx ON public.foobar_2 USING btree (bar, foo)",
-					Timeout: statementTimeoutConcurrentIndexBuild,
+					DDL:                "CREATE INDEX CONCURRENTLY foobar_2_replaced_with_same_name_idx ON public.foobar_2 USING btree (bar, foo)",
+					Timeout:            statementTimeoutConcurrentIndexBuild,
+					LockTimeout:        statementTimeoutConcurrentIndexBuild,
+					IsNonTransactional: true,
 					Hazards: []MigrationHazard{
 						buildIndexBuildHazard(),
 					},
@@ -614,8 +639,10 @@ var (
 					Hazards: nil,
 				},
 				{
-					DDL:     "CREATE INDEX CONCURRENTLY new_foobar_2_some_idx ON public.foobar_2 USING btree (foo, bar)",
-					Timeout: statementTimeoutConcurrentIndexBuild,
+					DDL:                "CREATE INDEX CONCURRENTLY new_foobar_2_some_idx ON public.foobar_2 USING btree (foo, bar)",
+					Timeout:            statementTimeoutConcurrentIndexBuild,
+					LockTimeout:        statementTimeoutConcurrentIndexBuild,
+					IsNonTransactional: true,
 					Hazards: []MigrationHazard{
 						buildIndexBuildHazard(),
 					},
@@ -626,8 +653,10 @@ var (
 					Hazards: nil,
 				},
 				{
-					DDL:     "DROP INDEX CONCURRENTLY \"foobar_1_some_local_idx\"",
-					Timeout: statementTimeoutConcurrentIndexDrop,
+					DDL:                "DROP INDEX CONCURRENTLY \"foobar_1_some_local_idx\"",
+					Timeout:            statementTimeoutConcurrentIndexDrop,
+					LockTimeout:        statementTimeoutConcurrentIndexDrop,
+					IsNonTransactional: true,
 					Hazards: []MigrationHazard{
 						buildIndexDroppedQueryPerfHazard(),
 					},
@@ -745,8 +774,10 @@ var (
 			},
 			expectedStatements: []Statement{
 				{
-					DDL:     "DROP INDEX CONCURRENTLY \"foobar_1_some_local_idx\"",
-					Timeout: statementTimeoutConcurrentIndexDrop,
+					DDL:                "DROP INDEX CONCURRENTLY \"foobar_1_some_local_idx\"",
+					Timeout:            statementTimeoutConcurrentIndexDrop,
+					LockTimeout:        statementTimeoutConcurrentIndexDrop,
+					IsNonTransactional: true,
 					Hazards: []MigrationHazard{
 						buildIndexDroppedQueryPerfHazard(),
 					},
@@ -856,8 +887,10 @@ var (
 					Timeout: statementTimeoutDefault,
 				},
 				{
-					DDL:     "CREATE INDEX CONCURRENTLY foobar_1_some_idx ON public.foobar_1 USING btree (foo, bar)",
-					Timeout: statementTimeoutConcurrentIndexBuild,
+					DDL:                "CREATE INDEX CONCURRENTLY foobar_1_some_idx ON public.foobar_1 USING btree (foo, bar)",
+					Timeout:            statementTimeoutConcurrentIndexBuild,
+					LockTimeout:        statementTimeoutConcurrentIndexBuild,
+					IsNonTransactional: true,
 					Hazards: []MigrationHazard{
 						buildIndexBuildHazard(),
 					},
@@ -867,8 +900,10 @@ var (
 					Timeout: statementTimeoutDefault,
 				},
 				{
-					DDL:     "DROP INDEX CONCURRENTLY \"foobar_1_some_idx_50515253-5455-4657-9859-5a5b5c5d5e5f\"",
-					Timeout: statementTimeoutConcurrentIndexDrop,
+					DDL:                "DROP INDEX CONCURRENTLY \"foobar_1_some_idx_50515253-5455-4657-9859-5a5b5c5d5e5f\"",
+					Timeout:            statementTimeoutConcurrentIndexDrop,
+					LockTimeout:        statementTimeoutConcurrentIndexDrop,
+					IsNonTransactional: true,
 					Hazards: []MigrationHazard{
 						buildIndexDroppedQueryPerfHazard(),
 					},
@@ -934,23 +969,1114 @@ var (
 			},
 			expectedStatements: []Statement{
 				{
-					DDL:     "ALTER TABLE \"foobar\" VALIDATE CONSTRAINT \"id_check\"",
+					DDL:                "ALTER TABLE \"foobar\" VALIDATE CONSTRAINT \"id_check\"",
+					Timeout:            statementTimeoutConstraintValidation,
+					LockTimeout:        statementTimeoutConstraintValidation,
+					IsNonTransactional: true,
+					Hazards:            []MigrationHazard{migrationHazardCheckConstraintValidation},
+				},
+			},
+		},
+		{
+			name: "Invalid check constraint altered and validated if expression changes",
+			oldSchema: schema.Schema{
+				Tables: []schema.Table{
+					{
+						Name: "foobar",
+						Columns: []schema.Column{
+							{Name: "id", Type: "integer"},
+						},
+						CheckConstraints: []schema.CheckConstraint{
+							{Name: "id_check", Expression: "(id > 0)", IsInheritable: true},
+						},
+					},
+				},
+			},
+			newSchema: schema.Schema{
+				Tables: []schema.Table{
+					{
+						Name: "foobar",
+						Columns: []schema.Column{
+							{Name: "id", Type: "integer"},
+						},
+						CheckConstraints: []schema.CheckConstraint{
+							{Name: "id_check", Expression: "(id < 0)", IsInheritable: true, IsValid: true},
+						},
+					},
+				},
+			},
+			expectedStatements: []Statement{
+				{
+					DDL:     "ALTER TABLE \"foobar\" ADD CONSTRAINT \"id_check_60616263-6465-4667-a869-6a6b6c6d6e6f\" CHECK((id < 0)) NOT VALID",
+					Timeout: statementTimeoutDefault,
+					Hazards: []MigrationHazard{migrationHazardCheckConstraintAddedNotValid},
+				},
+				{
+					DDL:                "ALTER TABLE \"foobar\" VALIDATE CONSTRAINT \"id_check_60616263-6465-4667-a869-6a6b6c6d6e6f\"",
+					Timeout:            statementTimeoutConstraintValidation,
+					LockTimeout:        statementTimeoutConstraintValidation,
+					IsNonTransactional: true,
+					Hazards:            []MigrationHazard{migrationHazardCheckConstraintValidation},
+				},
+				{
+					DDL:     "ALTER TABLE \"foobar\" DROP CONSTRAINT \"id_check\"",
+					Timeout: statementTimeoutDefault,
+				},
+				{
+					DDL:     "ALTER TABLE \"foobar\" RENAME CONSTRAINT \"id_check_60616263-6465-4667-a869-6a6b6c6d6e6f\" TO \"id_check\"",
+					Timeout: statementTimeoutDefault,
+				},
+			},
+		},
+		{
+			name: "BIGINT to TIMESTAMP type conversion",
+			oldSchema: schema.Schema{
+				Tables: []schema.Table{
+					{
+						Name: "foobar",
+						Columns: []schema.Column{
+							{Name: "baz", Type: "bigint"},
+						},
+						CheckConstraints: nil,
+					},
+				},
+				Indexes: nil,
+			},
+			newSchema: schema.Schema{
+				Tables: []schema.Table{
+					{
+						Name: "foobar",
+						Columns: []schema.Column{
+							{Name: "baz", Type: "timestamp without time zone", Default: "current_timestamp"},
+						},
+						CheckConstraints: nil,
+					},
+				},
+				Indexes: nil,
+			},
+			expectedStatements: []Statement{
+				{
+					DDL:                "ALTER TABLE \"foobar\" ALTER COLUMN \"baz\" SET DATA TYPE timestamp without time zone using to_timestamp(\"baz\" / 1000)",
+					Timeout:            statementTimeoutDefault,
+					IsNonTransactional: true,
+					Hazards: []MigrationHazard{{
+						Type: MigrationHazardTypeAcquiresAccessExclusiveLock,
+						Message: "This will completely lock the table while the data is being " +
+							"re-written for a duration of time that scales with the size of your " +
+							"data. The values previously stored as BIGINT will be translated into a " +
+							"TIMESTAMP value via the PostgreSQL to_timestamp() function. This " +
+							"translation will assume that the values stored in BIGINT represent a " +
+							"millisecond epoch value.",
+					}},
+				},
+				{
+					DDL:                "ANALYZE \"foobar\" (\"baz\")",
+					Timeout:            statementTimeoutAnalyzeColumn,
+					LockTimeout:        statementTimeoutAnalyzeColumn,
+					IsNonTransactional: true,
+					Hazards:            []MigrationHazard{buildAnalyzeColumnMigrationHazard()},
+				},
+				{
+					DDL:     "ALTER TABLE \"foobar\" ALTER COLUMN \"baz\" SET DEFAULT current_timestamp",
+					Timeout: statementTimeoutDefault,
+				},
+			},
+		},
+		{
+			name: "Collation migration and Type Migration",
+			oldSchema: schema.Schema{
+				Tables: []schema.Table{
+					{
+						Name: "foobar",
+						Columns: []schema.Column{
+							{Name: "migrate_to_c_coll", Type: "text", Collation: defaultCollation},
+							{Name: "migrate_type", Type: "text", Collation: defaultCollation},
+						},
+						CheckConstraints: nil,
+					},
+				},
+			},
+			newSchema: schema.Schema{
+				Tables: []schema.Table{
+					{
+						Name: "foobar",
+						Columns: []schema.Column{
+							{Name: "migrate_to_c_coll", Type: "text", Collation: cCollation},
+							{Name: "migrate_type", Type: "character varying(255)", Collation: defaultCollation},
+						},
+						CheckConstraints: nil,
+					},
+				},
+			},
+			expectedStatements: []Statement{
+				{
+					DDL:                "ALTER TABLE \"foobar\" ALTER COLUMN \"migrate_to_c_coll\" SET DATA TYPE text COLLATE \"pg_catalog\".\"C\" using \"migrate_to_c_coll\"::text",
+					Timeout:            statementTimeoutDefault,
+					IsNonTransactional: true,
+					Hazards:            []MigrationHazard{buildColumnTypeChangeHazard()},
+				},
+				{
+					DDL:                "ANALYZE \"foobar\" (\"migrate_to_c_coll\")",
+					Timeout:            statementTimeoutAnalyzeColumn,
+					LockTimeout:        statementTimeoutAnalyzeColumn,
+					IsNonTransactional: true,
+					Hazards:            []MigrationHazard{buildAnalyzeColumnMigrationHazard()},
+				},
+				{
+					DDL:                "ALTER TABLE \"foobar\" ALTER COLUMN \"migrate_type\" SET DATA TYPE character varying(255) COLLATE \"pg_catalog\".\"default\" using \"migrate_type\"::character varying(255)",
+					Timeout:            statementTimeoutDefault,
+					IsNonTransactional: true,
+					Hazards:            []MigrationHazard{buildColumnTypeChangeHazard()},
+				},
+				{
+					DDL:                "ANALYZE \"foobar\" (\"migrate_type\")",
+					Timeout:            statementTimeoutAnalyzeColumn,
+					LockTimeout:        statementTimeoutAnalyzeColumn,
+					IsNonTransactional: true,
+					Hazards:            []MigrationHazard{buildAnalyzeColumnMigrationHazard()},
+				},
+			},
+		},
+		{
+			name: "New partition with existing DEFAULT partition",
+			oldSchema: schema.Schema{
+				Tables: []schema.Table{
+					{
+						Name: "foobar",
+						Columns: []schema.Column{
+							{Name: "id", Type: "integer"},
+							{Name: "foo", Type: "text", Collation: defaultCollation},
+						},
+						CheckConstraints: nil,
+						PartitionKeyDef:  "PARTITION BY LIST(foo)",
+					},
+					{
+						ParentTableName: "foobar",
+						Name:            "foobar_default",
+						Columns: []schema.Column{
+							{Name: "id", Type: "integer"},
+							{Name: "foo", Type: "text", Collation: defaultCollation},
+						},
+						CheckConstraints: nil,
+						ForValues:        "DEFAULT",
+					},
+				},
+			},
+			newSchema: schema.Schema{
+				Tables: []schema.Table{
+					{
+						Name: "foobar",
+						Columns: []schema.Column{
+							{Name: "id", Type: "integer"},
+							{Name: "foo", Type: "text", Collation: defaultCollation},
+						},
+						CheckConstraints: nil,
+						PartitionKeyDef:  "PARTITION BY LIST(foo)",
+					},
+					{
+						ParentTableName: "foobar",
+						Name:            "foobar_default",
+						Columns: []schema.Column{
+							{Name: "id", Type: "integer"},
+							{Name: "foo", Type: "text", Collation: defaultCollation},
+						},
+						CheckConstraints: nil,
+						ForValues:        "DEFAULT",
+					},
+					{
+						ParentTableName: "foobar",
+						Name:            "foobar_1",
+						Columns: []schema.Column{
+							{Name: "id", Type: "integer"},
+							{Name: "foo", Type: "text", Collation: defaultCollation},
+						},
+						CheckConstraints:       nil,
+						ForValues:              "FOR VALUES IN ('some_val')",
+						PartitionConstraintDef: "((foo IS NOT NULL) AND (foo = 'some_val'::text))",
+					},
+				},
+			},
+			expectedStatements: []Statement{
+				{
+					DDL:     "CREATE TABLE \"foobar_1\" (\n\t\"id\" integer NOT NULL,\n\t\"foo\" text COLLATE \"pg_catalog\".\"default\" NOT NULL\n)",
+					Timeout: statementTimeoutDefault,
+				},
+				{
+					DDL:     "ALTER TABLE \"foobar_default\" ADD CONSTRAINT \"foobar_default_excludes_fo_70717273-7475-4677-b879-7a7b7c7d7e7f\" CHECK(NOT (((foo IS NOT NULL) AND (foo = 'some_val'::text)))) NOT VALID",
+					Timeout: statementTimeoutDefault,
+				},
+				{
+					DDL:                "ALTER TABLE \"foobar_default\" VALIDATE CONSTRAINT \"foobar_default_excludes_fo_70717273-7475-4677-b879-7a7b7c7d7e7f\"",
+					Timeout:            statementTimeoutDefaultPartitionScan,
+					LockTimeout:        statementTimeoutDefaultPartitionScan,
+					IsNonTransactional: true,
+					Hazards:            []MigrationHazard{buildValidateDefaultPartitionConstraintHazard()},
+				},
+				{
+					DDL:     "ALTER TABLE \"foobar\" ATTACH PARTITION \"foobar_1\" FOR VALUES IN ('some_val')",
+					Timeout: statementTimeoutDefault,
+					Hazards: []MigrationHazard{buildAttachPartitionWithDefaultPartitionHazard()},
+				},
+				{
+					DDL:     "ALTER TABLE \"foobar_default\" DROP CONSTRAINT \"foobar_default_excludes_fo_70717273-7475-4677-b879-7a7b7c7d7e7f\"",
+					Timeout: statementTimeoutDefault,
+				},
+			},
+		},
+		{
+			name:     "New partition with existing DEFAULT partition and moving rows",
+			planOpts: []PlanOpt{WithMoveRowsFromDefaultPartition()},
+			oldSchema: schema.Schema{
+				Tables: []schema.Table{
+					{
+						Name: "foobar",
+						Columns: []schema.Column{
+							{Name: "id", Type: "integer"},
+							{Name: "foo", Type: "text", Collation: defaultCollation},
+						},
+						CheckConstraints: nil,
+						PartitionKeyDef:  "PARTITION BY LIST(foo)",
+					},
+					{
+						ParentTableName: "foobar",
+						Name:            "foobar_default",
+						Columns: []schema.Column{
+							{Name: "id", Type: "integer"},
+							{Name: "foo", Type: "text", Collation: defaultCollation},
+						},
+						CheckConstraints: nil,
+						ForValues:        "DEFAULT",
+					},
+				},
+			},
+			newSchema: schema.Schema{
+				Tables: []schema.Table{
+					{
+						Name: "foobar",
+						Columns: []schema.Column{
+							{Name: "id", Type: "integer"},
+							{Name: "foo", Type: "text", Collation: defaultCollation},
+						},
+						CheckConstraints: nil,
+						PartitionKeyDef:  "PARTITION BY LIST(foo)",
+					},
+					{
+						ParentTableName: "foobar",
+						Name:            "foobar_default",
+						Columns: []schema.Column{
+							{Name: "id", Type: "integer"},
+							{Name: "foo", Type: "text", Collation: defaultCollation},
+						},
+						CheckConstraints: nil,
+						ForValues:        "DEFAULT",
+					},
+					{
+						ParentTableName: "foobar",
+						Name:            "foobar_1",
+						Columns: []schema.Column{
+							{Name: "id", Type: "integer"},
+							{Name: "foo", Type: "text", Collation: defaultCollation},
+						},
+						CheckConstraints:       nil,
+						ForValues:              "FOR VALUES IN ('some_val')",
+						PartitionConstraintDef: "((foo IS NOT NULL) AND (foo = 'some_val'::text))",
+					},
+				},
+			},
+			expectedStatements: []Statement{
+				{
+					DDL:     "CREATE TABLE \"foobar_1\" (\n\t\"id\" integer NOT NULL,\n\t\"foo\" text COLLATE \"pg_catalog\".\"default\" NOT NULL\n)",
+					Timeout: statementTimeoutDefault,
+				},
+				{
+					DDL:                "WITH moved_rows AS (DELETE FROM \"foobar_default\" WHERE ((foo IS NOT NULL) AND (foo = 'some_val'::text)) RETURNING *) INSERT INTO \"foobar_1\" (\"id\", \"foo\") SELECT \"id\", \"foo\" FROM moved_rows",
+					Timeout:            statementTimeoutDefaultPartitionScan,
+					LockTimeout:        lockTimeoutDefault,
+					IsNonTransactional: true,
+					Hazards: []MigrationHazard{
+						{
+							Type: MigrationHazardTypeImpactsDatabasePerformance,
+							Message: "Moving rows will scan the DEFAULT partition \"foobar_default\", deleting the rows that belong in the " +
+								"new partition and inserting them into the new partition. The deleted rows will be locked until the " +
+								"move completes, and any triggers on the tables will fire.",
+						},
+					},
+				},
+				{
+					DDL:     "ALTER TABLE \"foobar_default\" ADD CONSTRAINT \"foobar_default_excludes_fo_80818283-8485-4687-8889-8a8b8c8d8e8f\" CHECK(NOT (((foo IS NOT NULL) AND (foo = 'some_val'::text)))) NOT VALID",
+					Timeout: statementTimeoutDefault,
+				},
+				{
+					DDL:                "ALTER TABLE \"foobar_default\" VALIDATE CONSTRAINT \"foobar_default_excludes_fo_80818283-8485-4687-8889-8a8b8c8d8e8f\"",
+					Timeout:            statementTimeoutDefaultPartitionScan,
+					LockTimeout:        statementTimeoutDefaultPartitionScan,
+					IsNonTransactional: true,
+					Hazards:            []MigrationHazard{buildValidateDefaultPartitionConstraintHazard()},
+				},
+				{
+					DDL:     "ALTER TABLE \"foobar\" ATTACH PARTITION \"foobar_1\" FOR VALUES IN ('some_val')",
+					Timeout: statementTimeoutDefault,
+					Hazards: []MigrationHazard{buildAttachPartitionWithDefaultPartitionHazard()},
+				},
+				{
+					DDL:     "ALTER TABLE \"foobar_default\" DROP CONSTRAINT \"foobar_default_excludes_fo_80818283-8485-4687-8889-8a8b8c8d8e8f\"",
+					Timeout: statementTimeoutDefault,
+				},
+			},
+		},
+		{
+			name: "Add primary key to existing partitioned table",
+			oldSchema: schema.Schema{
+				Tables: []schema.Table{
+					{
+						Name: "foobar",
+						Columns: []schema.Column{
+							{Name: "id", Type: "integer"},
+							{Name: "foo", Type: "text", Collation: defaultCollation},
+						},
+						CheckConstraints: nil,
+						PartitionKeyDef:  "PARTITION BY LIST(foo)",
+					},
+					{
+						ParentTableName: "foobar",
+						Name:            "foobar_1",
+						Columns: []schema.Column{
+							{Name: "id", Type: "integer"},
+							{Name: "foo", Type: "text", Collation: defaultCollation},
+						},
+						CheckConstraints: nil,
+						ForValues:        "FOR VALUES IN ('some_val')",
+					},
+				},
+			},
+			newSchema: schema.Schema{
+				Tables: []schema.Table{
+					{
+						Name: "foobar",
+						Columns: []schema.Column{
+							{Name: "id", Type: "integer"},
+							{Name: "foo", Type: "text", Collation: defaultCollation},
+						},
+						CheckConstraints: nil,
+						PartitionKeyDef:  "PARTITION BY LIST(foo)",
+					},
+					{
+						ParentTableName: "foobar",
+						Name:            "foobar_1",
+						Columns: []schema.Column{
+							{Name: "id", Type: "integer"},
+							{Name: "foo", Type: "text", Collation: defaultCollation},
+						},
+						CheckConstraints: nil,
+						ForValues:        "FOR VALUES IN ('some_val')",
+					},
+				},
+				Indexes: []schema.Index{
+					{
+						TableName: "foobar",
+						Name:      "foobar_pkey", Columns: []string{"foo", "id"}, IsPk: true, IsUnique: true, ConstraintName: "foobar_pkey",
+						GetIndexDefStmt: "CREATE UNIQUE INDEX foobar_pkey ON ONLY public.foobar USING btree (foo, id)",
+					},
+					{
+						TableName: "foobar_1",
+						Name:      "foobar_1_pkey", Columns: []string{"foo", "id"}, IsPk: true, IsUnique: true, ConstraintName: "foobar_1_pkey", ParentIdxName: "foobar_pkey",
+						GetIndexDefStmt: "CREATE UNIQUE INDEX foobar_1_pkey ON public.foobar_1 USING btree (foo, id)",
+					},
+				},
+			},
+			expectedStatements: []Statement{
+				{
+					DDL:     "ALTER TABLE ONLY \"foobar\" ADD CONSTRAINT \"foobar_pkey\" PRIMARY KEY (\"foo\", \"id\")",
+					Timeout: statementTimeoutDefault,
+					Hazards: []MigrationHazard{migrationHazardPkAddedToPartitionedTable},
+				},
+				{
+					DDL:                "CREATE UNIQUE INDEX CONCURRENTLY foobar_1_pkey ON public.foobar_1 USING btree (foo, id)",
+					Timeout:            statementTimeoutConcurrentIndexBuild,
+					LockTimeout:        statementTimeoutConcurrentIndexBuild,
+					IsNonTransactional: true,
+					Hazards:            []MigrationHazard{buildIndexBuildHazard()},
+				},
+				{
+					DDL:     "ALTER TABLE \"foobar_1\" ADD CONSTRAINT \"foobar_1_pkey\" PRIMARY KEY USING INDEX \"foobar_1_pkey\"",
+					Timeout: statementTimeoutDefault,
+				},
+				{
+					DDL:     "ALTER INDEX \"foobar_pkey\" ATTACH PARTITION \"foobar_1_pkey\"",
+					Timeout: statementTimeoutDefault,
+				},
+			},
+		},
+		{
+			name: "Add primary key to existing partitioned table with multiple partitions",
+			oldSchema: schema.Schema{
+				Tables: []schema.Table{
+					{
+						Name: "foobar",
+						Columns: []schema.Column{
+							{Name: "id", Type: "integer"},
+							{Name: "foo", Type: "text", Collation: defaultCollation},
+						},
+						CheckConstraints: nil,
+						PartitionKeyDef:  "PARTITION BY LIST(foo)",
+					},
+					{
+						ParentTableName: "foobar",
+						Name:            "foobar_1",
+						Columns: []schema.Column{
+							{Name: "id", Type: "integer"},
+							{Name: "foo", Type: "text", Collation: defaultCollation},
+						},
+						CheckConstraints: nil,
+						ForValues:        "FOR VALUES IN ('some_val')",
+					},
+					{
+						ParentTableName: "foobar",
+						Name:            "foobar_2",
+						Columns: []schema.Column{
+							{Name: "id", Type: "integer"},
+							{Name: "foo", Type: "text", Collation: defaultCollation},
+						},
+						CheckConstraints: nil,
+						ForValues:        "FOR VALUES IN ('other_val')",
+					},
+				},
+			},
+			newSchema: schema.Schema{
+				Tables: []schema.Table{
+					{
+						Name: "foobar",
+						Columns: []schema.Column{
+							{Name: "id", Type: "integer"},
+							{Name: "foo", Type: "text", Collation: defaultCollation},
+						},
+						CheckConstraints: nil,
+						PartitionKeyDef:  "PARTITION BY LIST(foo)",
+					},
+					{
+						ParentTableName: "foobar",
+						Name:            "foobar_1",
+						Columns: []schema.Column{
+							{Name: "id", Type: "integer"},
+							{Name: "foo", Type: "text", Collation: defaultCollation},
+						},
+						CheckConstraints: nil,
+						ForValues:        "FOR VALUES IN ('some_val')",
+					},
+					{
+						ParentTableName: "foobar",
+						Name:            "foobar_2",
+						Columns: []schema.Column{
+							{Name: "id", Type: "integer"},
+							{Name: "foo", Type: "text", Collation: defaultCollation},
+						},
+						CheckConstraints: nil,
+						ForValues:        "FOR VALUES IN ('other_val')",
+					},
+				},
+				Indexes: []schema.Index{
+					{
+						TableName: "foobar",
+						Name:      "foobar_pkey", Columns: []string{"foo", "id"}, IsPk: true, IsUnique: true, ConstraintName: "foobar_pkey",
+						GetIndexDefStmt: "CREATE UNIQUE INDEX foobar_pkey ON ONLY public.foobar USING btree (foo, id)",
+					},
+					{
+						TableName: "foobar_1",
+						Name:      "foobar_1_pkey", Columns: []string{"foo", "id"}, IsPk: true, IsUnique: true, ConstraintName: "foobar_1_pkey", ParentIdxName: "foobar_pkey",
+						GetIndexDefStmt: "CREATE UNIQUE INDEX foobar_1_pkey ON public.foobar_1 USING btree (foo, id)",
+					},
+					{
+						TableName: "foobar_2",
+						Name:      "foobar_2_pkey", Columns: []string{"foo", "id"}, IsPk: true, IsUnique: true, ConstraintName: "foobar_2_pkey", ParentIdxName: "foobar_pkey",
+						GetIndexDefStmt: "CREATE UNIQUE INDEX foobar_2_pkey ON public.foobar_2 USING btree (foo, id)",
+					},
+				},
+			},
+			expectedStatements: []Statement{
+				{
+					DDL:     "ALTER TABLE ONLY \"foobar\" ADD CONSTRAINT \"foobar_pkey\" PRIMARY KEY (\"foo\", \"id\")",
+					Timeout: statementTimeoutDefault,
+					Hazards: []MigrationHazard{migrationHazardPkAddedToPartitionedTable},
+				},
+				{
+					DDL:                "CREATE UNIQUE INDEX CONCURRENTLY foobar_1_pkey ON public.foobar_1 USING btree (foo, id)",
+					Timeout:            statementTimeoutConcurrentIndexBuild,
+					LockTimeout:        statementTimeoutConcurrentIndexBuild,
+					IsNonTransactional: true,
+					Hazards:            []MigrationHazard{buildIndexBuildHazard()},
+				},
+				{
+					DDL:     "ALTER TABLE \"foobar_1\" ADD CONSTRAINT \"foobar_1_pkey\" PRIMARY KEY USING INDEX \"foobar_1_pkey\"",
+					Timeout: statementTimeoutDefault,
+				},
+				{
+					DDL:     "ALTER INDEX \"foobar_pkey\" ATTACH PARTITION \"foobar_1_pkey\"",
+					Timeout: statementTimeoutDefault,
+				},
+				{
+					DDL:                "CREATE UNIQUE INDEX CONCURRENTLY foobar_2_pkey ON public.foobar_2 USING btree (foo, id)",
+					Timeout:            statementTimeoutConcurrentIndexBuild,
+					LockTimeout:        statementTimeoutConcurrentIndexBuild,
+					IsNonTransactional: true,
+					Hazards:            []MigrationHazard{buildIndexBuildHazard()},
+				},
+				{
+					DDL:     "ALTER TABLE \"foobar_2\" ADD CONSTRAINT \"foobar_2_pkey\" PRIMARY KEY USING INDEX \"foobar_2_pkey\"",
+					Timeout: statementTimeoutDefault,
+				},
+				{
+					DDL:     "ALTER INDEX \"foobar_pkey\" ATTACH PARTITION \"foobar_2_pkey\"",
+					Timeout: statementTimeoutDefault,
+				},
+			},
+		},
+		{
+			name: "Add index to existing partitioned table",
+			oldSchema: schema.Schema{
+				Tables: []schema.Table{
+					{
+						Name: "foobar",
+						Columns: []schema.Column{
+							{Name: "id", Type: "integer"},
+							{Name: "foo", Type: "text", Collation: defaultCollation},
+						},
+						CheckConstraints: nil,
+						PartitionKeyDef:  "PARTITION BY LIST(foo)",
+					},
+					{
+						ParentTableName: "foobar",
+						Name:            "foobar_1",
+						Columns: []schema.Column{
+							{Name: "id", Type: "integer"},
+							{Name: "foo", Type: "text", Collation: defaultCollation},
+						},
+						CheckConstraints: nil,
+						ForValues:        "FOR VALUES IN ('some_val')",
+					},
+					{
+						ParentTableName: "foobar",
+						Name:            "foobar_2",
+						Columns: []schema.Column{
+							{Name: "id", Type: "integer"},
+							{Name: "foo", Type: "text", Collation: defaultCollation},
+						},
+						CheckConstraints: nil,
+						ForValues:        "FOR VALUES IN ('some_other_val')",
+					},
+				},
+			},
+			newSchema: schema.Schema{
+				Tables: []schema.Table{
+					{
+						Name: "foobar",
+						Columns: []schema.Column{
+							{Name: "id", Type: "integer"},
+							{Name: "foo", Type: "text", Collation: defaultCollation},
+						},
+						CheckConstraints: nil,
+						PartitionKeyDef:  "PARTITION BY LIST(foo)",
+					},
+					{
+						ParentTableName: "foobar",
+						Name:            "foobar_1",
+						Columns: []schema.Column{
+							{Name: "id", Type: "integer"},
+							{Name: "foo", Type: "text", Collation: defaultCollation},
+						},
+						CheckConstraints: nil,
+						ForValues:        "FOR VALUES IN ('some_val')",
+					},
+					{
+						ParentTableName: "foobar",
+						Name:            "foobar_2",
+						Columns: []schema.Column{
+							{Name: "id", Type: "integer"},
+							{Name: "foo", Type: "text", Collation: defaultCollation},
+						},
+						CheckConstraints: nil,
+						ForValues:        "FOR VALUES IN ('some_other_val')",
+					},
+				},
+				Indexes: []schema.Index{
+					{
+						TableName: "foobar",
+						Name:      "some_idx", Columns: []string{"id"},
+						GetIndexDefStmt: "CREATE INDEX some_idx ON ONLY public.foobar USING btree (id)",
+					},
+					{
+						TableName: "foobar_1",
+						Name:      "foobar_1_id_idx", Columns: []string{"id"}, ParentIdxName: "some_idx",
+						GetIndexDefStmt: "CREATE INDEX foobar_1_id_idx ON public.foobar_1 USING btree (id)",
+					},
+					{
+						TableName: "foobar_2",
+						Name:      "foobar_2_id_idx", Columns: []string{"id"}, ParentIdxName: "some_idx",
+						GetIndexDefStmt: "CREATE INDEX foobar_2_id_idx ON public.foobar_2 USING btree (id)",
+					},
+				},
+			},
+			expectedStatements: []Statement{
+				{
+					DDL:     "CREATE INDEX some_idx ON ONLY public.foobar USING btree (id)",
+					Timeout: statementTimeoutDefault,
+					Hazards: []MigrationHazard{migrationHazardIndexBuiltOnOnlyPartitionedTable},
+				},
+				{
+					DDL:                "CREATE INDEX CONCURRENTLY foobar_1_id_idx ON public.foobar_1 USING btree (id)",
+					Timeout:            statementTimeoutConcurrentIndexBuild,
+					LockTimeout:        statementTimeoutConcurrentIndexBuild,
+					IsNonTransactional: true,
+					Hazards:            []MigrationHazard{buildIndexBuildHazard()},
+				},
+				{
+					DDL:     "ALTER INDEX \"some_idx\" ATTACH PARTITION \"foobar_1_id_idx\"",
 					Timeout: statementTimeoutDefault,
-					Hazards: nil,
+				},
+				{
+					DDL:                "CREATE INDEX CONCURRENTLY foobar_2_id_idx ON public.foobar_2 USING btree (id)",
+					Timeout:            statementTimeoutConcurrentIndexBuild,
+					LockTimeout:        statementTimeoutConcurrentIndexBuild,
+					IsNonTransactional: true,
+					Hazards:            []MigrationHazard{buildIndexBuildHazard()},
+				},
+				{
+					DDL:     "ALTER INDEX \"some_idx\" ATTACH PARTITION \"foobar_2_id_idx\"",
+					Timeout: statementTimeoutDefault,
+				},
+			},
+		},
+		{
+			name:     "Rewrite table to reorder columns",
+			planOpts: []PlanOpt{WithRewriteTablesToReorderColumns()},
+			oldSchema: schema.Schema{
+				Tables: []schema.Table{
+					{
+						Name: "foobar",
+						Columns: []schema.Column{
+							{Name: "id", Type: "integer"},
+							{Name: "foo", Type: "text", Collation: defaultCollation},
+						},
+						CheckConstraints: []schema.CheckConstraint{
+							{Name: "id_check", Expression: "(id > 0)", IsValid: true, IsInheritable: true},
+						},
+					},
+				},
+				Indexes: []schema.Index{
+					{
+						TableName: "foobar",
+						Name:      "foobar_pkey", Columns: []string{"id"}, IsPk: true, IsUnique: true, ConstraintName: "foobar_pkey",
+						GetIndexDefStmt: "CREATE UNIQUE INDEX foobar_pkey ON public.foobar USING btree (id)",
+					},
+				},
+			},
+			newSchema: schema.Schema{
+				Tables: []schema.Table{
+					{
+						Name: "foobar",
+						Columns: []schema.Column{
+							{Name: "bar", Type: "bigint", IsNullable: true},
+							{Name: "id", Type: "integer"},
+							{Name: "foo", Type: "text", Collation: defaultCollation},
+						},
+						CheckConstraints: []schema.CheckConstraint{
+							{Name: "id_check", Expression: "(id > 0)", IsValid: true, IsInheritable: true},
+						},
+					},
+				},
+				Indexes: []schema.Index{
+					{
+						TableName: "foobar",
+						Name:      "foobar_pkey", Columns: []string{"id"}, IsPk: true, IsUnique: true, ConstraintName: "foobar_pkey",
+						GetIndexDefStmt: "CREATE UNIQUE INDEX foobar_pkey ON public.foobar USING btree (id)",
+					},
+				},
+			},
+			expectedStatements: []Statement{
+				{
+					DDL:     "ALTER TABLE \"foobar\" ADD COLUMN \"bar\" bigint",
+					Timeout: statementTimeoutDefault,
+				},
+				{
+					DDL:     "CREATE TABLE \"foobar_90919293-9495-4697-9899-9a9b9c9d9e9f\" (\n\t\"bar\" bigint,\n\t\"id\" integer NOT NULL,\n\t\"foo\" text COLLATE \"pg_catalog\".\"default\" NOT NULL\n)",
+					Timeout: statementTimeoutDefault,
+				},
+				{
+					DDL: "DO $pgschemadiff$\n" +
+						"DECLARE\n" +
+						"\tseq_name TEXT;\n" +
+						"\tcolumn_name TEXT;\n" +
+						"BEGIN\n" +
+						"\tLOCK TABLE \"foobar\" IN SHARE MODE;\n" +
+						"\tINSERT INTO \"foobar_90919293-9495-4697-9899-9a9b9c9d9e9f\" (\"bar\", \"id\", \"foo\") SELECT \"bar\", \"id\", \"foo\" FROM \"foobar\";\n" +
+						"\tCREATE UNIQUE INDEX \"foobar_pkey_a0a1a2a3-a4a5-46a7-a8a9-aaabacadaeaf\" ON \"foobar_90919293-9495-4697-9899-9a9b9c9d9e9f\" USING btree (id);\n" +
+						"\tALTER TABLE \"foobar_90919293-9495-4697-9899-9a9b9c9d9e9f\" ADD CONSTRAINT \"foobar_pkey_a0a1a2a3-a4a5-46a7-a8a9-aaabacadaeaf\" PRIMARY KEY USING INDEX \"foobar_pkey_a0a1a2a3-a4a5-46a7-a8a9-aaabacadaeaf\";\n" +
+						"\tALTER TABLE \"foobar_90919293-9495-4697-9899-9a9b9c9d9e9f\" ADD CONSTRAINT \"id_check\" CHECK((id > 0));\n" +
+						"\tLOCK TABLE \"foobar\" IN ACCESS EXCLUSIVE MODE;\n" +
+						"\tFOR seq_name, column_name IN\n" +
+						"\t\tSELECT dep.objid::regclass::TEXT, a.attname::TEXT\n" +
+						"\t\tFROM pg_catalog.pg_depend dep\n" +
+						"\t\t\tJOIN pg_catalog.pg_class seq ON seq.oid = dep.objid AND seq.relkind = 'S'\n" +
+						"\t\t\tJOIN pg_catalog.pg_attribute a ON a.attrelid = dep.refobjid AND a.attnum = dep.refobjsubid\n" +
+						"\t\tWHERE dep.classid = 'pg_catalog.pg_class'::regclass\n" +
+						"\t\t\tAND dep.refobjid = '\"foobar\"'::regclass\n" +
+						"\t\t\tAND dep.deptype = 'a'\n" +
+						"\tLOOP\n" +
+						"\t\tEXECUTE pg_catalog.format('ALTER SEQUENCE %s OWNED BY %I.%I', seq_name, 'foobar_90919293-9495-4697-9899-9a9b9c9d9e9f', column_name);\n" +
+						"\tEND LOOP;\n" +
+						"\tDROP TABLE \"foobar\";\n" +
+						"\tALTER TABLE \"foobar_90919293-9495-4697-9899-9a9b9c9d9e9f\" RENAME TO \"foobar\";\n" +
+						"\tALTER INDEX \"foobar_pkey_a0a1a2a3-a4a5-46a7-a8a9-aaabacadaeaf\" RENAME TO \"foobar_pkey\";\n" +
+						"END\n" +
+						"$pgschemadiff$",
+					Timeout:            statementTimeoutTableRewriteBase,
+					LockTimeout:        lockTimeoutDefault,
+					IsNonTransactional: true,
+					Hazards: []MigrationHazard{
+						{
+							Type: MigrationHazardTypeAcquiresShareLock,
+							Message: "This will lock out writes to the table while all of its rows are copied into a new table " +
+								"with the desired column ordering and the new table's indexes and constraints are built. " +
+								"The duration scales with the size of the table.",
+						},
+						{
+							Type: MigrationHazardTypeAcquiresAccessExclusiveLock,
+							Message: "This will briefly lock out all accesses to the table while the new table is swapped in. " +
+								"Upgrading the lock might deadlock with transactions that read and then write to the table, " +
+								"in which case the rewrite is rolled back.",
+						},
+						{
+							Type: MigrationHazardTypeImpactsDatabasePerformance,
+							Message: "Copying the table and building its indexes consumes a non-trivial amount of CPU and IO, " +
+								"and the database needs enough disk space to hold a second copy of the table.",
+						},
+						{
+							Type: MigrationHazardTypeHasUntrackableDependencies,
+							Message: "The old table is dropped and replaced by a copy. The copy is owned by the user running the " +
+								"migration and does not carry over the old table's grants, row level security setting or policies. " +
+								"Objects that depend on the old table, e.g., views, foreign keys or identity columns, cause the rewrite to fail.",
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "Table not rewritten when column ordering changes are ignored",
+			planOpts: []PlanOpt{WithRewriteTablesToReorderColumns(), func(opts *planOptions) {
+				opts.ignoreChangesToColOrder = true
+			}},
+			oldSchema: schema.Schema{
+				Tables: []schema.Table{
+					{
+						Name: "foobar",
+						Columns: []schema.Column{
+							{Name: "id", Type: "integer"},
+							{Name: "foo", Type: "text", Collation: defaultCollation},
+						},
+						CheckConstraints: []schema.CheckConstraint{
+							{Name: "id_check", Expression: "(id > 0)", IsValid: true, IsInheritable: true},
+						},
+					},
+				},
+				Indexes: []schema.Index{
+					{
+						TableName: "foobar",
+						Name:      "foobar_pkey", Columns: []string{"id"}, IsPk: true, IsUnique: true, ConstraintName: "foobar_pkey",
+						GetIndexDefStmt: "CREATE UNIQUE INDEX foobar_pkey ON public.foobar USING btree (id)",
+					},
+				},
+			},
+			newSchema: schema.Schema{
+				Tables: []schema.Table{
+					{
+						Name: "foobar",
+						Columns: []schema.Column{
+							{Name: "bar", Type: "bigint", IsNullable: true},
+							{Name: "id", Type: "integer"},
+							{Name: "foo", Type: "text", Collation: defaultCollation},
+						},
+						CheckConstraints: []schema.CheckConstraint{
+							{Name: "id_check", Expression: "(id > 0)", IsValid: true, IsInheritable: true},
+						},
+					},
+				},
+				Indexes: []schema.Index{
+					{
+						TableName: "foobar",
+						Name:      "foobar_pkey", Columns: []string{"id"}, IsPk: true, IsUnique: true, ConstraintName: "foobar_pkey",
+						GetIndexDefStmt: "CREATE UNIQUE INDEX foobar_pkey ON public.foobar USING btree (id)",
+					},
+				},
+			},
+			expectedStatements: []Statement{
+				{
+					DDL:     "ALTER TABLE \"foobar\" ADD COLUMN \"bar\" bigint",
+					Timeout: statementTimeoutDefault,
+				},
+			},
+		},
+		{
+			name: "Check constraint re-validated when the function it calls changes",
+			oldSchema: schema.Schema{
+				Tables: []schema.Table{
+					{
+						Name: "foobar",
+						Columns: []schema.Column{
+							{Name: "id", Type: "integer"},
+						},
+						CheckConstraints: []schema.CheckConstraint{
+							{
+								Name: "id_check", Expression: "is_positive(id)", IsValid: true, IsInheritable: true,
+								DependsOnFunctions: []schema.SchemaQualifiedName{{SchemaName: "public", EscapedName: "\"is_positive\"(a integer)"}},
+							},
+						},
+					},
+				},
+				Functions: []schema.Function{
+					{
+						SchemaQualifiedName: schema.SchemaQualifiedName{SchemaName: "public", EscapedName: "\"is_positive\"(a integer)"},
+						FunctionDef:         "CREATE OR REPLACE FUNCTION public.is_positive(a integer) RETURNS boolean LANGUAGE sql RETURN (a > 0)",
+						Language:            "sql",
+					},
+				},
+			},
+			newSchema: schema.Schema{
+				Tables: []schema.Table{
+					{
+						Name: "foobar",
+						Columns: []schema.Column{
+							{Name: "id", Type: "integer"},
+						},
+						CheckConstraints: []schema.CheckConstraint{
+							{
+								Name: "id_check", Expression: "is_positive(id)", IsValid: true, IsInheritable: true,
+								DependsOnFunctions: []schema.SchemaQualifiedName{{SchemaName: "public", EscapedName: "\"is_positive\"(a integer)"}},
+							},
+						},
+					},
+				},
+				Functions: []schema.Function{
+					{
+						SchemaQualifiedName: schema.SchemaQualifiedName{SchemaName: "public", EscapedName: "\"is_positive\"(a integer)"},
+						FunctionDef:         "CREATE OR REPLACE FUNCTION public.is_positive(a integer) RETURNS boolean LANGUAGE sql RETURN (a >= 1)",
+						Language:            "sql",
+					},
+				},
+			},
+			expectedStatements: []Statement{
+				{
+					DDL:     "CREATE OR REPLACE FUNCTION public.is_positive(a integer) RETURNS boolean LANGUAGE sql RETURN (a >= 1)",
+					Timeout: statementTimeoutDefault,
+				},
+				{
+					DDL:     "ALTER TABLE \"foobar\" ADD CONSTRAINT \"id_check_b0b1b2b3-b4b5-46b7-b8b9-babbbcbdbebf\" CHECK(is_positive(id)) NOT VALID",
+					Timeout: statementTimeoutDefault,
+					Hazards: []MigrationHazard{migrationHazardCheckConstraintDependsOnFunctions, migrationHazardCheckConstraintAddedNotValid},
+				},
+				{
+					DDL:                "ALTER TABLE \"foobar\" VALIDATE CONSTRAINT \"id_check_b0b1b2b3-b4b5-46b7-b8b9-babbbcbdbebf\"",
+					Timeout:            statementTimeoutConstraintValidation,
+					LockTimeout:        statementTimeoutConstraintValidation,
+					IsNonTransactional: true,
+					Hazards:            []MigrationHazard{migrationHazardCheckConstraintValidation},
+				},
+				{
+					DDL:     "ALTER TABLE \"foobar\" DROP CONSTRAINT \"id_check\"",
+					Timeout: statementTimeoutDefault,
+				},
+				{
+					DDL:     "ALTER TABLE \"foobar\" RENAME CONSTRAINT \"id_check_b0b1b2b3-b4b5-46b7-b8b9-babbbcbdbebf\" TO \"id_check\"",
+					Timeout: statementTimeoutDefault,
+				},
+			},
+		},
+		{
+			name: "Set NOT NULL online",
+			oldSchema: schema.Schema{
+				Tables: []schema.Table{
+					{
+						Name: "foobar",
+						Columns: []schema.Column{
+							{Name: "id", Type: "integer", IsNullable: true},
+						},
+					},
+				},
+			},
+			newSchema: schema.Schema{
+				Tables: []schema.Table{
+					{
+						Name: "foobar",
+						Columns: []schema.Column{
+							{Name: "id", Type: "integer"},
+						},
+					},
+				},
+			},
+			expectedStatements: []Statement{
+				{
+					DDL:     "ALTER TABLE \"foobar\" ADD CONSTRAINT \"id_not_null_c0c1c2c3-c4c5-46c7-88c9-cacbcccdcecf\" CHECK(\"id\" IS NOT NULL) NOT VALID",
+					Timeout: statementTimeoutDefault,
+				},
+				{
+					DDL:                "ALTER TABLE \"foobar\" VALIDATE CONSTRAINT \"id_not_null_c0c1c2c3-c4c5-46c7-88c9-cacbcccdcecf\"",
+					Timeout:            statementTimeoutConstraintValidation,
+					LockTimeout:        statementTimeoutConstraintValidation,
+					IsNonTransactional: true,
+				},
+				{
+					DDL:     "ALTER TABLE \"foobar\" ALTER COLUMN \"id\" SET NOT NULL",
+					Timeout: statementTimeoutDefault,
+					Hazards: []MigrationHazard{{
+						Type: MigrationHazardTypeAcquiresAccessExclusiveLock,
+						Message: "Marking a column as not null briefly acquires an ACCESS EXCLUSIVE lock. The table is not " +
+							"scanned because the validated check constraint proves the column has no nulls.",
+					}},
+				},
+				{
+					DDL:     "ALTER TABLE \"foobar\" DROP CONSTRAINT \"id_not_null_c0c1c2c3-c4c5-46c7-88c9-cacbcccdcecf\"",
+					Timeout: statementTimeoutDefault,
+				},
+			},
+		},
+		{
+			name: "Check constraint added to existing table as NOT VALID then validated",
+			oldSchema: schema.Schema{
+				Tables: []schema.Table{
+					{
+						Name: "foobar",
+						Columns: []schema.Column{
+							{Name: "id", Type: "integer"},
+						},
+					},
+				},
+			},
+			newSchema: schema.Schema{
+				Tables: []schema.Table{
+					{
+						Name: "foobar",
+						Columns: []schema.Column{
+							{Name: "id", Type: "integer"},
+						},
+						CheckConstraints: []schema.CheckConstraint{
+							{Name: "id_check", Expression: "(id > 0)", IsInheritable: true, IsValid: true},
+						},
+					},
+				},
+			},
+			expectedStatements: []Statement{
+				{
+					DDL:     "ALTER TABLE \"foobar\" ADD CONSTRAINT \"id_check\" CHECK((id > 0)) NOT VALID",
+					Timeout: statementTimeoutDefault,
+					Hazards: []MigrationHazard{migrationHazardCheckConstraintAddedNotValid},
+				},
+				{
+					DDL:                "ALTER TABLE \"foobar\" VALIDATE CONSTRAINT \"id_check\"",
+					Timeout:            statementTimeoutConstraintValidation,
+					LockTimeout:        statementTimeoutConstraintValidation,
+					IsNonTransactional: true,
+					Hazards:            []MigrationHazard{migrationHazardCheckConstraintValidation},
+				},
+			},
+		},
+		{
+			name: "Binary-coercible type changes don't re-write the table",
+			oldSchema: schema.Schema{
+				Tables: []schema.Table{
+					{
+						Name: "foobar",
+						Columns: []schema.Column{
+							{Name: "foo", Type: "character varying(50)", Collation: defaultCollation},
+							{Name: "bar", Type: "numeric(10,2)"},
+						},
+					},
+				},
+			},
+			newSchema: schema.Schema{
+				Tables: []schema.Table{
+					{
+						Name: "foobar",
+						Columns: []schema.Column{
+							{Name: "foo", Type: "text", Collation: defaultCollation},
+							{Name: "bar", Type: "numeric(12,2)"},
+						},
+					},
+				},
+			},
+			planOpts: []PlanOpt{func(opts *planOptions) {
+				opts.binaryCoercibleCasts = map[binaryCoercibleCast]bool{
+					{sourceType: "character varying", targetType: "text"}: true,
+				}
+			}},
+			expectedStatements: []Statement{
+				{
+					DDL:     "ALTER TABLE \"foobar\" ALTER COLUMN \"foo\" SET DATA TYPE text COLLATE \"pg_catalog\".\"default\" using \"foo\"::text",
+					Timeout: statementTimeoutDefault,
+					Hazards: []MigrationHazard{buildColumnTypeChangeWithoutRewriteHazard()},
+				},
+				{
+					DDL:     "ALTER TABLE \"foobar\" ALTER COLUMN \"bar\" SET DATA TYPE numeric(12,2) using \"bar\"::numeric(12,2)",
+					Timeout: statementTimeoutDefault,
+					Hazards: []MigrationHazard{buildColumnTypeChangeWithoutRewriteHazard()},
+				},
+			},
+		},
+		{
+			name: "Type conversion rule",
+			oldSchema: schema.Schema{
+				Tables: []schema.Table{
+					{
+						Name: "foobar",
+						Columns: []schema.Column{
+							{Name: "foo", Type: "text", Collation: defaultCollation},
+						},
+					},
+				},
+			},
+			newSchema: schema.Schema{
+				Tables: []schema.Table{
+					{
+						Name: "foobar",
+						Columns: []schema.Column{
+							{Name: "foo", Type: "jsonb"},
+						},
+					},
+				},
+			},
+			planOpts: []PlanOpt{WithTypeConversionRules(TypeConversionRule{
+				OldType:    "text",
+				NewType:    "jsonb",
+				TableName:  "foobar",
+				ColumnName: "foo",
+				Using:      "to_jsonb({column})",
+			})},
+			expectedStatements: []Statement{
+				{
+					DDL:                "ALTER TABLE \"foobar\" ALTER COLUMN \"foo\" SET DATA TYPE jsonb using to_jsonb(\"foo\")",
+					Timeout:            statementTimeoutDefault,
+					IsNonTransactional: true,
+					Hazards: []MigrationHazard{{
+						Type: MigrationHazardTypeAcquiresAccessExclusiveLock,
+						Message: "This will completely lock the table while the data is being re-written. The values " +
+							"will be converted using the expression: to_jsonb(\"foo\")",
+					}},
+				},
+				{
+					DDL:                "ANALYZE \"foobar\" (\"foo\")",
+					Timeout:            statementTimeoutAnalyzeColumn,
+					LockTimeout:        statementTimeoutAnalyzeColumn,
+					IsNonTransactional: true,
+					Hazards:            []MigrationHazard{buildAnalyzeColumnMigrationHazard()},
 				},
 			},
 		},
 		{
-			name: "Invalid check constraint re-created if expression changes",
+			name: "Shadow column type changes",
 			oldSchema: schema.Schema{
 				Tables: []schema.Table{
 					{
 						Name: "foobar",
 						Columns: []schema.Column{
 							{Name: "id", Type: "integer"},
-						},
-						CheckConstraints: []schema.CheckConstraint{
-							{Name: "id_check", Expression: "(id > 0)", IsInheritable: true},
+							{Name: "foo", Type: "integer", Default: "0"},
 						},
 					},
 				},
@@ -961,86 +2087,333 @@ var (
 						Name: "foobar",
 						Columns: []schema.Column{
 							{Name: "id", Type: "integer"},
-						},
-						CheckConstraints: []schema.CheckConstraint{
-							{Name: "id_check", Expression: "(id < 0)", IsInheritable: true, IsValid: true},
+							{Name: "foo", Type: "bigint", Default: "0"},
 						},
 					},
 				},
 			},
+			planOpts: []PlanOpt{WithShadowColumnTypeChanges()},
 			expectedStatements: []Statement{
 				{
-					DDL:     "ALTER TABLE \"foobar\" DROP CONSTRAINT \"id_check\"",
+					DDL:     "ALTER TABLE \"foobar\" ADD COLUMN \"foo_shadow_d0d1d2d3-d4d5-46d7-98d9-dadbdcdddedf\" bigint",
+					Timeout: statementTimeoutDefault,
+					Hazards: []MigrationHazard{{
+						Type: MigrationHazardTypeAcquiresAccessExclusiveLock,
+						Message: "Adding the shadow column briefly acquires an ACCESS EXCLUSIVE lock. The table is not " +
+							"re-written because the shadow column has no default.",
+					}},
+				},
+				{
+					DDL: "CREATE FUNCTION \"foo_sync_e0e1e2e3-e4e5-46e7-a8e9-eaebecedeeef\"() RETURNS TRIGGER LANGUAGE plpgsql AS $pgschemadiff$\n" +
+						"BEGIN\n" +
+						"\tNEW.\"foo_shadow_d0d1d2d3-d4d5-46d7-98d9-dadbdcdddedf\" := NEW.\"foo\"::bigint;\n" +
+						"\tRETURN NEW;\n" +
+						"END\n" +
+						"$pgschemadiff$",
+					Timeout: statementTimeoutDefault,
+				},
+				{
+					DDL:     "CREATE TRIGGER \"foo_sync_e0e1e2e3-e4e5-46e7-a8e9-eaebecedeeef\" BEFORE INSERT OR UPDATE ON \"foobar\" FOR EACH ROW EXECUTE FUNCTION \"foo_sync_e0e1e2e3-e4e5-46e7-a8e9-eaebecedeeef\"()",
+					Timeout: statementTimeoutDefault,
+					Hazards: []MigrationHazard{
+						{
+							Type:    MigrationHazardTypeAcquiresShareLock,
+							Message: "Creating the trigger briefly acquires a SHARE ROW EXCLUSIVE lock, which locks out writes to the table.",
+						},
+						{
+							Type: MigrationHazardTypeImpactsDatabasePerformance,
+							Message: "Until the shadow column is swapped in, every insert and update of the table will also " +
+								"compute the value of the shadow column, which slows down writes.",
+						},
+					},
+				},
+				{
+					DDL: "DO $pgschemadiff$\n" +
+						"DECLARE\n" +
+						"\tnum_pages BIGINT := pg_catalog.pg_relation_size('\"foobar\"') / pg_catalog.current_setting('block_size')::BIGINT;\n" +
+						"\tbatch_start_page BIGINT := 0;\n" +
+						"BEGIN\n" +
+						"\tWHILE batch_start_page <= num_pages LOOP\n" +
+						"\t\tUPDATE \"foobar\" SET \"foo_shadow_d0d1d2d3-d4d5-46d7-98d9-dadbdcdddedf\" = \"foo\"::bigint\n" +
+						"\t\t\tWHERE ctid >= format('(%s,0)', batch_start_page)::TID AND ctid < format('(%s,0)', batch_start_page + 1000)::TID;\n" +
+						"\t\tCOMMIT;\n" +
+						"\t\tbatch_start_page := batch_start_page + 1000;\n" +
+						"\tEND LOOP;\n" +
+						"END\n" +
+						"$pgschemadiff$",
+					Timeout:            statementTimeoutTableRewriteBase,
+					LockTimeout:        lockTimeoutDefault,
+					IsNonTransactional: true,
+					Hazards: []MigrationHazard{{
+						Type: MigrationHazardTypeImpactsDatabasePerformance,
+						Message: "Every row of the table is updated to backfill the shadow column, which puts increased " +
+							"load on the database and leaves behind dead rows. The rows are updated in batches, each " +
+							"committed separately, so reads and writes are not locked out, but writes to the rows of the " +
+							"current batch will wait for the batch to commit. The batches are found via TID range scans, " +
+							"which require Postgres 14 or above. On older versions, every batch scans the whole table.",
+					}},
+				},
+				{
+					DDL:     "ALTER TABLE \"foobar\" ADD CONSTRAINT \"foo_not_null_00010203-0405-4607-8809-0a0b0c0d0e0f\" CHECK(\"foo_shadow_d0d1d2d3-d4d5-46d7-98d9-dadbdcdddedf\" IS NOT NULL) NOT VALID",
+					Timeout: statementTimeoutDefault,
+					Hazards: []MigrationHazard{migrationHazardCheckConstraintAddedNotValid},
+				},
+				{
+					DDL:                "ALTER TABLE \"foobar\" VALIDATE CONSTRAINT \"foo_not_null_00010203-0405-4607-8809-0a0b0c0d0e0f\"",
+					Timeout:            statementTimeoutConstraintValidation,
+					LockTimeout:        statementTimeoutConstraintValidation,
+					IsNonTransactional: true,
+					Hazards:            []MigrationHazard{migrationHazardCheckConstraintValidation},
+				},
+				{
+					DDL: "DO $pgschemadiff$\n" +
+						"BEGIN\n" +
+						"\tLOCK TABLE \"foobar\" IN ACCESS EXCLUSIVE MODE;\n" +
+						"\tDROP TRIGGER \"foo_sync_e0e1e2e3-e4e5-46e7-a8e9-eaebecedeeef\" ON \"foobar\";\n" +
+						"\tALTER TABLE \"foobar\" RENAME COLUMN \"foo\" TO \"foo_old_f0f1f2f3-f4f5-46f7-b8f9-fafbfcfdfeff\";\n" +
+						"\tALTER TABLE \"foobar\" ALTER COLUMN \"foo_old_f0f1f2f3-f4f5-46f7-b8f9-fafbfcfdfeff\" DROP NOT NULL;\n" +
+						"\tALTER TABLE \"foobar\" RENAME COLUMN \"foo_shadow_d0d1d2d3-d4d5-46d7-98d9-dadbdcdddedf\" TO \"foo\";\n" +
+						"\tALTER TABLE \"foobar\" ALTER COLUMN \"foo\" SET NOT NULL;\n" +
+						"\tALTER TABLE \"foobar\" DROP CONSTRAINT \"foo_not_null_00010203-0405-4607-8809-0a0b0c0d0e0f\";\n" +
+						"\tALTER TABLE \"foobar\" ALTER COLUMN \"foo\" SET DEFAULT 0;\n" +
+						"END\n" +
+						"$pgschemadiff$",
+					Timeout: statementTimeoutDefault,
+					Hazards: []MigrationHazard{
+						{
+							Type: MigrationHazardTypeAcquiresAccessExclusiveLock,
+							Message: "Swapping the shadow column in for the column briefly acquires an ACCESS EXCLUSIVE " +
+								"lock. The table is not scanned.",
+						},
+						{
+							Type: MigrationHazardTypeHasUntrackableDependencies,
+							Message: "Objects that depend on the column and are not tracked, e.g., views or foreign keys, " +
+								"will continue to reference the old column, which will cause dropping the old column to fail.",
+						},
+					},
+				},
+				{
+					DDL:     "ALTER TABLE \"foobar\" DROP COLUMN \"foo_old_f0f1f2f3-f4f5-46f7-b8f9-fafbfcfdfeff\"",
 					Timeout: statementTimeoutDefault,
+					Hazards: []MigrationHazard{{
+						Type: MigrationHazardTypeAcquiresAccessExclusiveLock,
+						Message: "Dropping the old column briefly acquires an ACCESS EXCLUSIVE lock. Its values have " +
+							"already been converted into the new column.",
+					}},
 				},
 				{
-					DDL:     "ALTER TABLE \"foobar\" ADD CONSTRAINT \"id_check\" CHECK((id < 0))",
+					DDL:     "DROP FUNCTION \"foo_sync_e0e1e2e3-e4e5-46e7-a8e9-eaebecedeeef\"()",
 					Timeout: statementTimeoutDefault,
 				},
+				{
+					DDL:                "ANALYZE \"foobar\" (\"foo\")",
+					Timeout:            statementTimeoutAnalyzeColumn,
+					LockTimeout:        statementTimeoutAnalyzeColumn,
+					IsNonTransactional: true,
+					Hazards:            []MigrationHazard{buildAnalyzeColumnMigrationHazard()},
+				},
 			},
 		},
 		{
-			name: "BIGINT to TIMESTAMP type conversion",
+			name: "Add column with volatile default",
 			oldSchema: schema.Schema{
 				Tables: []schema.Table{
 					{
 						Name: "foobar",
 						Columns: []schema.Column{
-							{Name: "baz", Type: "bigint"},
+							{Name: "id", Type: "integer"},
 						},
-						CheckConstraints: nil,
 					},
 				},
-				Indexes: nil,
 			},
 			newSchema: schema.Schema{
 				Tables: []schema.Table{
 					{
 						Name: "foobar",
 						Columns: []schema.Column{
-							{Name: "baz", Type: "timestamp without time zone", Default: "current_timestamp"},
+							{Name: "id", Type: "integer"},
+							{Name: "foo", Type: "uuid", Default: "gen_random_uuid()", IsDefaultVolatile: true},
 						},
-						CheckConstraints: nil,
 					},
 				},
-				Indexes: nil,
 			},
 			expectedStatements: []Statement{
 				{
-					DDL:     "ALTER TABLE \"foobar\" ALTER COLUMN \"baz\" SET DATA TYPE timestamp without time zone using to_timestamp(\"baz\" / 1000)",
+					DDL:     "ALTER TABLE \"foobar\" ADD COLUMN \"foo\" uuid, ALTER COLUMN \"foo\" SET DEFAULT gen_random_uuid()",
 					Timeout: statementTimeoutDefault,
 					Hazards: []MigrationHazard{{
 						Type: MigrationHazardTypeAcquiresAccessExclusiveLock,
-						Message: "This will completely lock the table while the data is being " +
-							"re-written for a duration of time that scales with the size of your " +
-							"data. The values previously stored as BIGINT will be translated into a " +
-							"TIMESTAMP value via the PostgreSQL to_timestamp() function. This " +
-							"translation will assume that the values stored in BIGINT represent a " +
-							"millisecond epoch value.",
+						Message: "Adding the column briefly acquires an ACCESS EXCLUSIVE lock. The table is not re-written " +
+							"because the volatile default only applies to new rows.",
+					}},
+				},
+				{
+					DDL: "DO $pgschemadiff$\n" +
+						"DECLARE\n" +
+						"\tnum_pages BIGINT := pg_catalog.pg_relation_size('\"foobar\"') / pg_catalog.current_setting('block_size')::BIGINT;\n" +
+						"\tbatch_start_page BIGINT := 0;\n" +
+						"BEGIN\n" +
+						"\tWHILE batch_start_page <= num_pages LOOP\n" +
+						"\t\tUPDATE \"foobar\" SET \"foo\" = DEFAULT\n" +
+						"\t\t\tWHERE (\"foo\" IS NULL) AND ctid >= format('(%s,0)', batch_start_page)::TID AND ctid < format('(%s,0)', batch_start_page + 1000)::TID;\n" +
+						"\t\tCOMMIT;\n" +
+						"\t\tbatch_start_page := batch_start_page + 1000;\n" +
+						"\tEND LOOP;\n" +
+						"END\n" +
+						"$pgschemadiff$",
+					Timeout:            statementTimeoutTableRewriteBase,
+					LockTimeout:        lockTimeoutDefault,
+					IsNonTransactional: true,
+					Hazards: []MigrationHazard{{
+						Type: MigrationHazardTypeImpactsDatabasePerformance,
+						Message: "Every existing row of the table is updated to backfill the column's default, which puts " +
+							"increased load on the database and leaves behind dead rows. The rows are updated in batches, " +
+							"each committed separately, so reads and writes are not locked out. Nulls explicitly written to " +
+							"the column before the backfill completes will be overwritten. The batches are found via TID " +
+							"range scans, which require Postgres 14 or above. On older versions, every batch scans the " +
+							"whole table.",
 					}},
 				},
 				{
-					DDL:     "ANALYZE \"foobar\" (\"baz\")",
-					Timeout: statementTimeoutAnalyzeColumn,
-					Hazards: []MigrationHazard{buildAnalyzeColumnMigrationHazard()},
+					DDL:     "ALTER TABLE \"foobar\" ADD CONSTRAINT \"foo_not_null_10111213-1415-4617-9819-1a1b1c1d1e1f\" CHECK(\"foo\" IS NOT NULL) NOT VALID",
+					Timeout: statementTimeoutDefault,
+				},
+				{
+					DDL:                "ALTER TABLE \"foobar\" VALIDATE CONSTRAINT \"foo_not_null_10111213-1415-4617-9819-1a1b1c1d1e1f\"",
+					Timeout:            statementTimeoutConstraintValidation,
+					LockTimeout:        statementTimeoutConstraintValidation,
+					IsNonTransactional: true,
 				},
 				{
-					DDL:     "ALTER TABLE \"foobar\" ALTER COLUMN \"baz\" SET DEFAULT current_timestamp",
+					DDL:     "ALTER TABLE \"foobar\" ALTER COLUMN \"foo\" SET NOT NULL",
+					Timeout: statementTimeoutDefault,
+					Hazards: []MigrationHazard{{
+						Type: MigrationHazardTypeAcquiresAccessExclusiveLock,
+						Message: "Marking a column as not null briefly acquires an ACCESS EXCLUSIVE lock. The table is " +
+							"not scanned because the validated check constraint proves the column has no nulls.",
+					}},
+				},
+				{
+					DDL:     "ALTER TABLE \"foobar\" DROP CONSTRAINT \"foo_not_null_10111213-1415-4617-9819-1a1b1c1d1e1f\"",
 					Timeout: statementTimeoutDefault,
 				},
 			},
 		},
 		{
-			name: "Collation migration and Type Migration",
+			name: "Add NOT NULL column without default",
 			oldSchema: schema.Schema{
 				Tables: []schema.Table{
 					{
 						Name: "foobar",
 						Columns: []schema.Column{
-							{Name: "migrate_to_c_coll", Type: "text", Collation: defaultCollation},
-							{Name: "migrate_type", Type: "text", Collation: defaultCollation},
+							{Name: "id", Type: "integer"},
+						},
+					},
+				},
+			},
+			newSchema: schema.Schema{
+				Tables: []schema.Table{
+					{
+						Name: "foobar",
+						Columns: []schema.Column{
+							{Name: "id", Type: "integer"},
+							{Name: "foo", Type: "integer"},
+						},
+					},
+				},
+			},
+			expectedStatements: []Statement{
+				{
+					DDL: "DO $pgschemadiff$\n" +
+						"BEGIN\n" +
+						"\tIF EXISTS (SELECT 1 FROM \"foobar\") THEN\n" +
+						"\t\tRAISE EXCEPTION 'Cannot add NOT NULL column \"foo\" without a default to non-empty table \"foobar\"';\n" +
+						"\tEND IF;\n" +
+						"END\n" +
+						"$pgschemadiff$",
+					Timeout: statementTimeoutDefault,
+					Hazards: []MigrationHazard{{
+						Type: MigrationHazardTypeFailsOnExistingData,
+						Message: "This statement fails the migration if the table has any rows, since the column is NOT NULL and " +
+							"has no default to fill the existing rows with. Add a default to the column if the table might have " +
+							"rows.",
+					}},
+				},
+				{
+					DDL:     "ALTER TABLE \"foobar\" ADD COLUMN \"foo\" integer NOT NULL",
+					Timeout: statementTimeoutDefault,
+				},
+			},
+		},
+		{
+			name: "Remove column default and drop the function it depends on",
+			oldSchema: schema.Schema{
+				Tables: []schema.Table{
+					{
+						Name: "foobar",
+						Columns: []schema.Column{
+							{
+								Name:    "foo",
+								Type:    "integer",
+								Default: "one()",
+								DependsOnFunctions: []schema.SchemaQualifiedName{
+									{SchemaName: "public", EscapedName: "\"one\"()"},
+								},
+							},
+						},
+					},
+				},
+				Functions: []schema.Function{
+					{
+						SchemaQualifiedName: schema.SchemaQualifiedName{SchemaName: "public", EscapedName: "\"one\"()"},
+						FunctionDef:         "CREATE OR REPLACE FUNCTION public.one() RETURNS integer LANGUAGE sql IMMUTABLE RETURN 1",
+						Language:            "sql",
+					},
+				},
+			},
+			newSchema: schema.Schema{
+				Tables: []schema.Table{
+					{
+						Name: "foobar",
+						Columns: []schema.Column{
+							{Name: "foo", Type: "integer"},
+						},
+					},
+				},
+			},
+			expectedStatements: []Statement{
+				{
+					DDL:     "ALTER TABLE \"foobar\" ALTER COLUMN \"foo\" DROP DEFAULT",
+					Timeout: statementTimeoutDefault,
+				},
+				{
+					DDL:     "DROP FUNCTION \"public\".\"one\"()",
+					Timeout: statementTimeoutDefault,
+				},
+			},
+		},
+		{
+			name: "Add primary key with nullable columns to existing partitioned table",
+			oldSchema: schema.Schema{
+				Tables: []schema.Table{
+					{
+						Name: "foobar",
+						Columns: []schema.Column{
+							{Name: "id", Type: "integer", IsNullable: true},
+							{Name: "foo", Type: "text", Collation: defaultCollation, IsNullable: true},
+						},
+						CheckConstraints: nil,
+						PartitionKeyDef:  "PARTITION BY LIST(foo)",
+					},
+					{
+						ParentTableName: "foobar",
+						Name:            "foobar_1",
+						Columns: []schema.Column{
+							{Name: "id", Type: "integer", IsNullable: true},
+							{Name: "foo", Type: "text", Collation: defaultCollation, IsNullable: true},
 						},
 						CheckConstraints: nil,
+						ForValues:        "FOR VALUES IN ('some_val')",
 					},
 				},
 			},
@@ -1049,33 +2422,120 @@ var (
 					{
 						Name: "foobar",
 						Columns: []schema.Column{
-							{Name: "migrate_to_c_coll", Type: "text", Collation: cCollation},
-							{Name: "migrate_type", Type: "character varying(255)", Collation: defaultCollation},
+							{Name: "id", Type: "integer"},
+							{Name: "foo", Type: "text", Collation: defaultCollation},
+						},
+						CheckConstraints: nil,
+						PartitionKeyDef:  "PARTITION BY LIST(foo)",
+					},
+					{
+						ParentTableName: "foobar",
+						Name:            "foobar_1",
+						Columns: []schema.Column{
+							{Name: "id", Type: "integer"},
+							{Name: "foo", Type: "text", Collation: defaultCollation},
 						},
 						CheckConstraints: nil,
+						ForValues:        "FOR VALUES IN ('some_val')",
+					},
+				},
+				Indexes: []schema.Index{
+					{
+						TableName: "foobar",
+						Name:      "foobar_pkey", Columns: []string{"foo", "id"}, IsPk: true, IsUnique: true, ConstraintName: "foobar_pkey",
+						GetIndexDefStmt: "CREATE UNIQUE INDEX foobar_pkey ON ONLY public.foobar USING btree (foo, id)",
+					},
+					{
+						TableName: "foobar_1",
+						Name:      "foobar_1_pkey", Columns: []string{"foo", "id"}, IsPk: true, IsUnique: true, ConstraintName: "foobar_1_pkey", ParentIdxName: "foobar_pkey",
+						GetIndexDefStmt: "CREATE UNIQUE INDEX foobar_1_pkey ON public.foobar_1 USING btree (foo, id)",
 					},
 				},
 			},
+			// Marking the partitioned table's columns as NOT NULL recurses to the partitions, so the primary key added
+			// to ONLY the partitioned table does not fail on the partitions' nullable columns
 			expectedStatements: []Statement{
 				{
-					DDL:     "ALTER TABLE \"foobar\" ALTER COLUMN \"migrate_to_c_coll\" SET DATA TYPE text COLLATE \"pg_catalog\".\"C\" using \"migrate_to_c_coll\"::text",
+					DDL:     "ALTER TABLE \"foobar\" ALTER COLUMN \"id\" SET NOT NULL",
+					Timeout: statementTimeoutDefault,
+					Hazards: []MigrationHazard{{
+						Type:    MigrationHazardTypeAcquiresAccessExclusiveLock,
+						Message: "Marking a column as not null requires a full table scan, which will lock out writes",
+					}},
+				},
+				{
+					DDL:     "ALTER TABLE \"foobar\" ALTER COLUMN \"foo\" SET NOT NULL",
+					Timeout: statementTimeoutDefault,
+					Hazards: []MigrationHazard{{
+						Type:    MigrationHazardTypeAcquiresAccessExclusiveLock,
+						Message: "Marking a column as not null requires a full table scan, which will lock out writes",
+					}},
+				},
+				{
+					DDL:     "ALTER TABLE ONLY \"foobar\" ADD CONSTRAINT \"foobar_pkey\" PRIMARY KEY (\"foo\", \"id\")",
+					Timeout: statementTimeoutDefault,
+					Hazards: []MigrationHazard{migrationHazardPkAddedToPartitionedTable},
+				},
+				{
+					DDL:     "ALTER TABLE \"foobar_1\" ADD CONSTRAINT \"id_not_null_20212223-2425-4627-a829-2a2b2c2d2e2f\" CHECK(\"id\" IS NOT NULL) NOT VALID",
+					Timeout: statementTimeoutDefault,
+				},
+				{
+					DDL:                "ALTER TABLE \"foobar_1\" VALIDATE CONSTRAINT \"id_not_null_20212223-2425-4627-a829-2a2b2c2d2e2f\"",
+					Timeout:            statementTimeoutConstraintValidation,
+					LockTimeout:        statementTimeoutConstraintValidation,
+					IsNonTransactional: true,
+				},
+				{
+					DDL:     "ALTER TABLE \"foobar_1\" ALTER COLUMN \"id\" SET NOT NULL",
+					Timeout: statementTimeoutDefault,
+					Hazards: []MigrationHazard{{
+						Type: MigrationHazardTypeAcquiresAccessExclusiveLock,
+						Message: "Marking a column as not null briefly acquires an ACCESS EXCLUSIVE lock. The table is " +
+							"not scanned because the validated check constraint proves the column has no nulls.",
+					}},
+				},
+				{
+					DDL:     "ALTER TABLE \"foobar_1\" DROP CONSTRAINT \"id_not_null_20212223-2425-4627-a829-2a2b2c2d2e2f\"",
 					Timeout: statementTimeoutDefault,
-					Hazards: []MigrationHazard{buildColumnTypeChangeHazard()},
 				},
 				{
-					DDL:     "ANALYZE \"foobar\" (\"migrate_to_c_coll\")",
-					Timeout: statementTimeoutAnalyzeColumn,
-					Hazards: []MigrationHazard{buildAnalyzeColumnMigrationHazard()},
+					DDL:     "ALTER TABLE \"foobar_1\" ADD CONSTRAINT \"foo_not_null_30313233-3435-4637-b839-3a3b3c3d3e3f\" CHECK(\"foo\" IS NOT NULL) NOT VALID",
+					Timeout: statementTimeoutDefault,
+				},
+				{
+					DDL:                "ALTER TABLE \"foobar_1\" VALIDATE CONSTRAINT \"foo_not_null_30313233-3435-4637-b839-3a3b3c3d3e3f\"",
+					Timeout:            statementTimeoutConstraintValidation,
+					LockTimeout:        statementTimeoutConstraintValidation,
+					IsNonTransactional: true,
+				},
+				{
+					DDL:     "ALTER TABLE \"foobar_1\" ALTER COLUMN \"foo\" SET NOT NULL",
+					Timeout: statementTimeoutDefault,
+					Hazards: []MigrationHazard{{
+						Type: MigrationHazardTypeAcquiresAccessExclusiveLock,
+						Message: "Marking a column as not null briefly acquires an ACCESS EXCLUSIVE lock. The table is " +
+							"not scanned because the validated check constraint proves the column has no nulls.",
+					}},
+				},
+				{
+					DDL:     "ALTER TABLE \"foobar_1\" DROP CONSTRAINT \"foo_not_null_30313233-3435-4637-b839-3a3b3c3d3e3f\"",
+					Timeout: statementTimeoutDefault,
 				},
 				{
-					DDL:     "ALTER TABLE \"foobar\" ALTER COLUMN \"migrate_type\" SET DATA TYPE character varying(255) COLLATE \"pg_catalog\".\"default\" using \"migrate_type\"::character varying(255)",
+					DDL:                "CREATE UNIQUE INDEX CONCURRENTLY foobar_1_pkey ON public.foobar_1 USING btree (foo, id)",
+					Timeout:            statementTimeoutConcurrentIndexBuild,
+					LockTimeout:        statementTimeoutConcurrentIndexBuild,
+					IsNonTransactional: true,
+					Hazards:            []MigrationHazard{buildIndexBuildHazard()},
+				},
+				{
+					DDL:     "ALTER TABLE \"foobar_1\" ADD CONSTRAINT \"foobar_1_pkey\" PRIMARY KEY USING INDEX \"foobar_1_pkey\"",
 					Timeout: statementTimeoutDefault,
-					Hazards: []MigrationHazard{buildColumnTypeChangeHazard()},
 				},
 				{
-					DDL:     "ANALYZE \"foobar\" (\"migrate_type\")",
-					Timeout: statementTimeoutAnalyzeColumn,
-					Hazards: []MigrationHazard{buildAnalyzeColumnMigrationHazard()},
+					DDL:     "ALTER INDEX \"foobar_pkey\" ATTACH PARTITION \"foobar_1_pkey\"",
+					Timeout: statementTimeoutDefault,
 				},
 			},
 		},
@@ -1105,7 +2565,14 @@ func TestSchemaMigrationPlanTest(t *testing.T) {
 			} else {
 				require.NoError(t, err)
 			}
-			stmts, err := schemaSQLGenerator{}.Alter(schemaDiff)
+			planOptions := &planOptions{}
+			for _, opt := range testCase.planOpts {
+				opt(planOptions)
+			}
+			if planOptions.ignoreChangesToColOrder {
+				schemaDiff = removeChangesToColumnOrdering(schemaDiff)
+			}
+			stmts, err := newSchemaSQLGenerator(planOptions).Alter(schemaDiff)
 			require.NoError(t, err)
 			assert.Equal(t, testCase.expectedStatements, stmts, "actual:\n %# v", pretty.Formatter(stmts))
 		})
@@ -1129,6 +2596,15 @@ func buildColumnTypeChangeHazard() MigrationHazard {
 	}
 }
 
+func buildColumnTypeChangeWithoutRewriteHazard() MigrationHazard {
+	return MigrationHazard{
+		Type: MigrationHazardTypeAcquiresAccessExclusiveLock,
+		Message: "This will briefly lock the table while the column's type is changed. The table will not be " +
+			"re-written because the new type is binary-coercible from the old type. The column's statistics " +
+			"are removed and not re-generated by the migration, so consider analyzing the column afterwards.",
+	}
+}
+
 func buildAnalyzeColumnMigrationHazard() MigrationHazard {
 	return MigrationHazard{
 		Type: MigrationHazardTypeImpactsDatabasePerformance,
@@ -1161,3 +2637,21 @@ func buildIndexDroppedAcquiresLockHazard() MigrationHazard {
 		Message: "Index drops will lock out all accesses to the table. They should be fast",
 	}
 }
+
+func buildValidateDefaultPartitionConstraintHazard() MigrationHazard {
+	return MigrationHazard{
+		Type: MigrationHazardTypeImpactsDatabasePerformance,
+		Message: "Validating the constraint will scan the DEFAULT partition \"foobar_default\". It won't lock out " +
+			"reads or writes, but it may take a while and consume database resources. The validation will " +
+			"fail if any rows in the DEFAULT partition belong in the new partition.",
+	}
+}
+
+func buildAttachPartitionWithDefaultPartitionHazard() MigrationHazard {
+	return MigrationHazard{
+		Type: MigrationHazardTypeAcquiresAccessExclusiveLock,
+		Message: "Attaching the partition acquires an ACCESS EXCLUSIVE lock on the DEFAULT partition \"foobar_default\". " +
+			"The lock should be brief because the DEFAULT partition has a validated constraint excluding the new " +
+			"partition's values, so it does not need to be scanned.",
+	}
+}
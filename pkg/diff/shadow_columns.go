@@ -0,0 +1,230 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/stripe/pg-schema-diff/internal/schema"
+)
+
+// canChangeTypeViaShadowColumn returns true if the type of the column can be changed via a shadow column. The old
+// column is dropped once the shadow column is swapped in, so the column can't be referenced by any other schema
+// objects. Partitioned tables are not supported, nor are tables with triggers, since their functions might modify the
+// column after the shadow column has been synced
+func (t *tableSQLVertexGenerator) canChangeTypeViaShadowColumn(diff tableDiff, colDiff columnDiff) bool {
+	oldColumn, newColumn := colDiff.old, colDiff.new
+	if diff.new.IsPartitioned() {
+		return false
+	}
+	if strings.EqualFold(oldColumn.Type, newColumn.Type) &&
+		oldColumn.Collation.GetFQEscapedName() == newColumn.Collation.GetFQEscapedName() {
+		return false
+	}
+	if oldColumn.Collation.GetFQEscapedName() == newColumn.Collation.GetFQEscapedName() &&
+		isTypeChangeWithoutRewrite(oldColumn.Type, newColumn.Type, t.binaryCoercibleCasts) {
+		// The table is not re-written anyways
+		return false
+	}
+	if strings.Contains(oldColumn.Default, "nextval(") {
+		// The column might own the sequence, which would be dropped alongside the old column
+		return false
+	}
+	if len(t.triggersInOldSchemaByTableName[diff.new.Name]) > 0 || len(t.triggersInNewSchemaByTableName[diff.new.Name]) > 0 {
+		return false
+	}
+
+	for _, idx := range append(
+		append([]schema.Index(nil), t.indexesInOldSchemaByTableName[diff.new.Name]...),
+		t.indexesInNewSchemaByTableName[diff.new.Name]...,
+	) {
+		if sqlReferencesIdentifier(string(idx.GetIndexDefStmt), newColumn.Name) {
+			return false
+		}
+	}
+	for _, con := range append(append([]schema.CheckConstraint(nil), diff.old.CheckConstraints...), diff.new.CheckConstraints...) {
+		if sqlReferencesIdentifier(con.Expression, newColumn.Name) {
+			return false
+		}
+	}
+	return true
+}
+
+// changeTypeViaShadowColumn generates the statements to change the type of a column without re-writing the table under
+// an ACCESS EXCLUSIVE lock:
+//  1. A shadow column with the new type is added
+//  2. A trigger keeps the shadow column in sync with the column as rows are inserted and updated
+//  3. The shadow column of the existing rows is backfilled in batches
+//  4. The shadow column is swapped in for the column in a short transaction
+//  5. The old column and the trigger's function are dropped
+func (csg *columnSQLGenerator) changeTypeViaShadowColumn(oldColumn, newColumn schema.Column) ([]Statement, error) {
+	shadowColumnName, err := generateNonConflictingName(newColumn.Name + "_shadow")
+	if err != nil {
+		return nil, fmt.Errorf("generating shadow column name: %w", err)
+	}
+	syncFunctionName, err := generateNonConflictingName(newColumn.Name + "_sync")
+	if err != nil {
+		return nil, fmt.Errorf("generating sync function name: %w", err)
+	}
+	oldColumnName, err := generateNonConflictingName(newColumn.Name + "_old")
+	if err != nil {
+		return nil, fmt.Errorf("generating old column name: %w", err)
+	}
+
+	escapedTableName := schema.EscapeIdentifier(csg.tableName)
+	escapedShadowColumnName := schema.EscapeIdentifier(shadowColumnName)
+	escapedSyncFunctionName := schema.EscapeIdentifier(syncFunctionName)
+	convertedValue := func(columnRef string) string {
+		if rule, ok := findTypeConversionRule(csg.typeConversionRules, csg.tableName, oldColumn, newColumn); ok {
+			return strings.ReplaceAll(rule.Using, TypeConversionColumnPlaceholder, columnRef)
+		}
+		return fmt.Sprintf("%s::%s", columnRef, newColumn.Type)
+	}
+
+	shadowColumn := newColumn
+	shadowColumn.Name = shadowColumnName
+	shadowColumn.IsNullable = true
+	shadowColumn.Default = ""
+	stmts := []Statement{
+		{
+			DDL:     fmt.Sprintf("%s ADD COLUMN %s", alterTablePrefix(csg.tableName), buildColumnDefinition(shadowColumn)),
+			Timeout: statementTimeoutDefault,
+			Hazards: []MigrationHazard{{
+				Type: MigrationHazardTypeAcquiresAccessExclusiveLock,
+				Message: "Adding the shadow column briefly acquires an ACCESS EXCLUSIVE lock. The table is not re-written " +
+					"because the shadow column has no default.",
+			}},
+		},
+		{
+			DDL: fmt.Sprintf("CREATE FUNCTION %s() RETURNS TRIGGER LANGUAGE plpgsql AS $pgschemadiff$\n"+
+				"BEGIN\n"+
+				"\tNEW.%s := %s;\n"+
+				"\tRETURN NEW;\n"+
+				"END\n"+
+				"$pgschemadiff$",
+				escapedSyncFunctionName,
+				escapedShadowColumnName,
+				convertedValue("NEW."+schema.EscapeIdentifier(oldColumn.Name)),
+			),
+			Timeout: statementTimeoutDefault,
+		},
+		{
+			DDL: fmt.Sprintf("CREATE TRIGGER %s BEFORE INSERT OR UPDATE ON %s FOR EACH ROW EXECUTE FUNCTION %s()",
+				escapedSyncFunctionName, escapedTableName, escapedSyncFunctionName,
+			),
+			Timeout: statementTimeoutDefault,
+			Hazards: []MigrationHazard{
+				{
+					Type: MigrationHazardTypeAcquiresShareLock,
+					Message: "Creating the trigger briefly acquires a SHARE ROW EXCLUSIVE lock, which locks out writes " +
+						"to the table.",
+				},
+				{
+					Type: MigrationHazardTypeImpactsDatabasePerformance,
+					Message: "Until the shadow column is swapped in, every insert and update of the table will also " +
+						"compute the value of the shadow column, which slows down writes.",
+				},
+			},
+		},
+		{
+			DDL: buildBackfillDDL(csg.tableName,
+				fmt.Sprintf("%s = %s", escapedShadowColumnName, convertedValue(schema.EscapeIdentifier(oldColumn.Name))),
+				"",
+			),
+			Timeout:     getTableRewriteTimeout(csg.tableSizesInBytesByName, csg.tableName),
+			LockTimeout: lockTimeoutDefault,
+			// The backfill commits each batch, which can't be done in a transaction
+			IsNonTransactional: true,
+			Hazards: []MigrationHazard{{
+				Type: MigrationHazardTypeImpactsDatabasePerformance,
+				Message: "Every row of the table is updated to backfill the shadow column, which puts increased load on " +
+					"the database and leaves behind dead rows. The rows are updated in batches, each committed " +
+					"separately, so reads and writes are not locked out, but writes to the rows of the current batch " +
+					"will wait for the batch to commit. The batches are found via TID range scans, which require " +
+					"Postgres 14 or above. On older versions, every batch scans the whole table.",
+			}},
+		},
+	}
+
+	var notNullConstraintName string
+	if !newColumn.IsNullable {
+		// Validate the shadow column has no nulls before the columns are swapped, such that marking the column as not
+		// null does not require a full table scan while the ACCESS EXCLUSIVE lock is held
+		notNullConstraintName, err = generateNonConflictingName(newColumn.Name + "_not_null")
+		if err != nil {
+			return nil, fmt.Errorf("generating not null constraint name: %w", err)
+		}
+		checkConSQLGenerator := checkConstraintSQLGenerator{tableName: csg.tableName}
+		addConStmts, err := checkConSQLGenerator.Add(schema.CheckConstraint{
+			Name:          notNullConstraintName,
+			Expression:    fmt.Sprintf("%s IS NOT NULL", escapedShadowColumnName),
+			IsValid:       true,
+			IsInheritable: true,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("generating add not null check constraint statements: %w", err)
+		}
+		stmts = append(stmts, addConStmts...)
+	}
+
+	alterColumnPrefix := fmt.Sprintf("%s ALTER COLUMN %s", alterTablePrefix(csg.tableName), schema.EscapeIdentifier(newColumn.Name))
+	swapStmts := []string{
+		fmt.Sprintf("LOCK TABLE %s IN ACCESS EXCLUSIVE MODE;", escapedTableName),
+		// The trigger's function references the shadow column by its old name, so the trigger must be dropped before
+		// the shadow column is renamed
+		fmt.Sprintf("DROP TRIGGER %s ON %s;", escapedSyncFunctionName, escapedTableName),
+		fmt.Sprintf("%s RENAME COLUMN %s TO %s;",
+			alterTablePrefix(csg.tableName), schema.EscapeIdentifier(oldColumn.Name), schema.EscapeIdentifier(oldColumnName),
+		),
+	}
+	if !oldColumn.IsNullable {
+		// Otherwise, inserts that don't set the old column would fail until it is dropped
+		swapStmts = append(swapStmts, fmt.Sprintf("%s ALTER COLUMN %s DROP NOT NULL;",
+			alterTablePrefix(csg.tableName), schema.EscapeIdentifier(oldColumnName),
+		))
+	}
+	swapStmts = append(swapStmts, fmt.Sprintf("%s RENAME COLUMN %s TO %s;",
+		alterTablePrefix(csg.tableName), escapedShadowColumnName, schema.EscapeIdentifier(newColumn.Name),
+	))
+	if !newColumn.IsNullable {
+		swapStmts = append(swapStmts,
+			fmt.Sprintf("%s SET NOT NULL;", alterColumnPrefix),
+			dropConstraintDDL(csg.tableName, notNullConstraintName)+";",
+		)
+	}
+	if len(newColumn.Default) > 0 {
+		swapStmts = append(swapStmts, fmt.Sprintf("%s SET DEFAULT %s;", alterColumnPrefix, newColumn.Default))
+	}
+
+	return append(stmts,
+		Statement{
+			DDL:     fmt.Sprintf("DO $pgschemadiff$\nBEGIN\n\t%s\nEND\n$pgschemadiff$", strings.Join(swapStmts, "\n\t")),
+			Timeout: statementTimeoutDefault,
+			Hazards: []MigrationHazard{
+				{
+					Type: MigrationHazardTypeAcquiresAccessExclusiveLock,
+					Message: "Swapping the shadow column in for the column briefly acquires an ACCESS EXCLUSIVE lock. The " +
+						"table is not scanned.",
+				},
+				{
+					Type: MigrationHazardTypeHasUntrackableDependencies,
+					Message: "Objects that depend on the column and are not tracked, e.g., views or foreign keys, will " +
+						"continue to reference the old column, which will cause dropping the old column to fail.",
+				},
+			},
+		},
+		Statement{
+			DDL:     fmt.Sprintf("%s DROP COLUMN %s", alterTablePrefix(csg.tableName), schema.EscapeIdentifier(oldColumnName)),
+			Timeout: statementTimeoutDefault,
+			Hazards: []MigrationHazard{{
+				Type: MigrationHazardTypeAcquiresAccessExclusiveLock,
+				Message: "Dropping the old column briefly acquires an ACCESS EXCLUSIVE lock. Its values have already been " +
+					"converted into the new column.",
+			}},
+		},
+		Statement{
+			DDL:     fmt.Sprintf("DROP FUNCTION %s()", escapedSyncFunctionName),
+			Timeout: statementTimeoutDefault,
+		},
+		buildAnalyzeColumnStatement(csg.tableName, newColumn.Name),
+	), nil
+}
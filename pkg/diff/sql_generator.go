@@ -26,6 +26,28 @@ const (
 	statementTimeoutTableDrop = 20 * time.Minute
 	// statementTimeoutAnalyzeColumn is the statement timeout for analyzing the column of a table
 	statementTimeoutAnalyzeColumn = 20 * time.Minute
+	// statementTimeoutDefaultPartitionScan is the statement timeout for statements that scan the DEFAULT partition
+	// when a new partition is attached to its parent, e.g., validating the constraint that excludes the new partition's
+	// values from the DEFAULT partition. The scan may take a while, but it does not lock out reads or writes
+	statementTimeoutDefaultPartitionScan = 20 * time.Minute
+	// statementTimeoutConstraintValidation is the statement timeout for validating a constraint, which scans the table.
+	// Validation does not lock out reads or writes
+	statementTimeoutConstraintValidation = 20 * time.Minute
+	// statementTimeoutTableRewriteBase is the minimum statement timeout for copying a table into a new table with a
+	// different column ordering. statementTimeoutTableRewritePerGB is added for every GB of the table (including its
+	// indexes and TOAST), since the copy and the index builds scale with the size of the table
+	statementTimeoutTableRewriteBase  = 20 * time.Minute
+	statementTimeoutTableRewritePerGB = 10 * time.Minute
+
+	// lockTimeoutDefault is the lock timeout for long-running statements whose locks block reads or writes. Reads and
+	// writes queue up behind a statement waiting for such a lock, so the wait is kept short. Statements with the default
+	// statement timeout don't set a lock timeout, since the statement timeout already bounds how long they wait for
+	// locks. Long-running statements whose locks don't block reads or writes, e.g., concurrent index builds, use their
+	// statement timeout as their lock timeout, since they might wait for long-running transactions to finish
+	lockTimeoutDefault = 5 * time.Second
+
+	// backfillBatchPages is the number of pages of a table whose rows are updated in each batch of a backfill
+	backfillBatchPages = 1000
 )
 
 var (
@@ -40,6 +62,34 @@ var (
 			"statement. For adds, this means you need to ensure that all functions this function depends on are " +
 			"created/altered before this statement.",
 	}
+	migrationHazardCheckConstraintDependsOnFunctions = MigrationHazard{
+		Type: MigrationHazardTypeHasUntrackableDependencies,
+		Message: "Check constraints are not re-evaluated when the functions they call change. The constraint is " +
+			"re-validated when a change to one of its functions is part of a migration, but changes made outside of " +
+			"migrations, or to the untrackable dependencies of non-sql functions, can leave rows that violate it.",
+	}
+	migrationHazardCheckConstraintAddedNotValid = MigrationHazard{
+		Type: MigrationHazardTypeAcquiresAccessExclusiveLock,
+		Message: "Adding the constraint briefly acquires an ACCESS EXCLUSIVE lock. The table is not scanned " +
+			"because the constraint is added as NOT VALID.",
+	}
+	migrationHazardCheckConstraintValidation = MigrationHazard{
+		Type: MigrationHazardTypeImpactsDatabasePerformance,
+		Message: "Validating the constraint scans the whole table, which might affect database performance. " +
+			"It does not lock out reads or writes.",
+	}
+	migrationHazardIndexBuiltOnOnlyPartitionedTable = MigrationHazard{
+		Type: MigrationHazardTypeAcquiresShareLock,
+		Message: "Creating the index on only the partitioned table briefly acquires a SHARE lock on it, which blocks " +
+			"writes. Its partitions are not scanned. The index is invalid until the index of every partition is " +
+			"built concurrently and attached by the following statements.",
+	}
+	migrationHazardPkAddedToPartitionedTable = MigrationHazard{
+		Type: MigrationHazardTypeAcquiresAccessExclusiveLock,
+		Message: "Adding the primary key briefly acquires an ACCESS EXCLUSIVE lock on the partitioned table. Only the " +
+			"partitioned table itself is altered, so its partitions are not scanned. The primary key's index is " +
+			"invalid until the index of every partition is built and attached by the following statements.",
+	}
 	migrationHazardIndexDroppedQueryPerf = MigrationHazard{
 		Type: MigrationHazardTypeIndexDropped,
 		Message: "Dropping this index means queries that use this index might perform worse because " +
@@ -49,6 +99,12 @@ var (
 		Type:    MigrationHazardTypeAcquiresAccessExclusiveLock,
 		Message: "Index drops will lock out all accesses to the table. They should be fast",
 	}
+	migrationHazardIndexBuildConcurrently = MigrationHazard{
+		Type: MigrationHazardTypeIndexBuild,
+		Message: "This might affect database performance. " +
+			"Concurrent index builds require a non-trivial amount of CPU, potentially affecting database performance. " +
+			"They also can take a while but do not lock out writes.",
+	}
 )
 
 type oldAndNew[S schema.Object] struct {
@@ -57,11 +113,11 @@ type oldAndNew[S schema.Object] struct {
 }
 
 func (o oldAndNew[S]) GetNew() S {
-	return o.old
+	return o.new
 }
 
 func (o oldAndNew[S]) GetOld() S {
-	return o.new
+	return o.old
 }
 
 type (
@@ -102,8 +158,8 @@ type schemaDiff struct {
 	triggerDiffs  listDiff[schema.Trigger, triggerDiff]
 }
 
-func (sd schemaDiff) resolveToSQL() ([]Statement, error) {
-	return schemaSQLGenerator{}.Alter(sd)
+func (sd schemaDiff) resolveToSQL(planOptions *planOptions) ([]Statement, error) {
+	return newSchemaSQLGenerator(planOptions).Alter(sd)
 }
 
 // The procedure for DIFFING schemas and GENERATING/RESOLVING the SQL required to migrate the old schema to the new schema is
@@ -232,13 +288,15 @@ func buildTableDiff(oldTable, newTable schema.Table, _, _ int) (diff tableDiff,
 		return tableDiff{}, false, fmt.Errorf("diffing columns: %w", err)
 	}
 
+	deletedColumnsByName := buildSchemaObjMap(columnsDiff.deletes)
 	checkConsDiff, err := diffLists(
 		oldTable.CheckConstraints,
 		newTable.CheckConstraints,
 		func(old, new schema.CheckConstraint, _, _ int) (checkConstraintDiff, bool, error) {
-			recreateConstraint := (old.Expression != new.Expression) ||
-				(old.IsValid && !new.IsValid) ||
-				(old.IsInheritable != new.IsInheritable)
+			recreateConstraint := (old.IsValid && !new.IsValid) ||
+				(old.IsInheritable != new.IsInheritable) ||
+				// Postgres drops the constraint alongside any column it references, so it can't be altered
+				(old.Expression != new.Expression && referencesAnyColumn(old.Expression, deletedColumnsByName))
 			return checkConstraintDiff{oldAndNew[schema.CheckConstraint]{old: old, new: new}},
 				recreateConstraint,
 				nil
@@ -304,27 +362,73 @@ func buildIndexDiff(newSchemaTablesByName map[string]schema.Table, addedTablesBy
 	}, recreateIndex, nil
 }
 
-type schemaSQLGenerator struct{}
-
-func (schemaSQLGenerator) Alter(diff schemaDiff) ([]Statement, error) {
+type schemaSQLGenerator struct {
+	// moveRowsFromDefaultPartition configures the SQL generation to move rows from an existing DEFAULT partition into
+	// a newly-added partition if they belong in the new partition
+	moveRowsFromDefaultPartition bool
+	// rewriteTablesToReorderColumns configures the SQL generation to rewrite existing tables whose column ordering
+	// has changed
+	rewriteTablesToReorderColumns bool
+	// tableSizesInBytesByName is used to derive the timeouts of table rewrites. It might not contain all tables
+	tableSizesInBytesByName map[string]int64
+	// binaryCoercibleCasts is used to identify column type changes that don't re-write the table
+	binaryCoercibleCasts map[binaryCoercibleCast]bool
+	// typeConversionRules configures how the values of columns are converted when their types change
+	typeConversionRules []TypeConversionRule
+	// shadowColumnTypeChanges configures the SQL generation to change the types of columns via shadow columns
+	shadowColumnTypeChanges bool
+}
+
+func newSchemaSQLGenerator(planOptions *planOptions) schemaSQLGenerator {
+	return schemaSQLGenerator{
+		moveRowsFromDefaultPartition: planOptions.moveRowsFromDefaultPartition,
+		// Column ordering changes are only planned if they are respected
+		rewriteTablesToReorderColumns: planOptions.rewriteTablesToReorderColumns && !planOptions.ignoreChangesToColOrder,
+		tableSizesInBytesByName:       planOptions.tableSizesInBytesByName,
+		binaryCoercibleCasts:          planOptions.binaryCoercibleCasts,
+		typeConversionRules:           planOptions.typeConversionRules,
+		shadowColumnTypeChanges:       planOptions.shadowColumnTypeChanges,
+	}
+}
+
+func (s schemaSQLGenerator) Alter(diff schemaDiff) ([]Statement, error) {
 	tablesInNewSchemaByName := buildSchemaObjMap(diff.new.Tables)
 	deletedTablesByName := buildSchemaObjMap(diff.tableDiffs.deletes)
 
+	indexesInOldSchemaByTableName := buildIndexesByTableName(diff.old.Indexes)
+	indexesInNewSchemaByTableName := buildIndexesByTableName(diff.new.Indexes)
+	triggersInOldSchemaByTableName := buildTriggersByTableName(diff.old.Triggers)
+	triggersInNewSchemaByTableName := buildTriggersByTableName(diff.new.Triggers)
+
 	tableSQLVertexGenerator := tableSQLVertexGenerator{
-		deletedTablesByName:     deletedTablesByName,
-		tablesInNewSchemaByName: tablesInNewSchemaByName,
+		deletedTablesByName:            deletedTablesByName,
+		tablesInNewSchemaByName:        tablesInNewSchemaByName,
+		alteredFunctionsByName:         buildAlteredFunctionsByName(diff),
+		rewriteTablesToReorderColumns:  s.rewriteTablesToReorderColumns,
+		binaryCoercibleCasts:           s.binaryCoercibleCasts,
+		typeConversionRules:            s.typeConversionRules,
+		shadowColumnTypeChanges:        s.shadowColumnTypeChanges,
+		tableSizesInBytesByName:        s.tableSizesInBytesByName,
+		indexesInOldSchemaByTableName:  indexesInOldSchemaByTableName,
+		indexesInNewSchemaByTableName:  indexesInNewSchemaByTableName,
+		triggersInOldSchemaByTableName: triggersInOldSchemaByTableName,
+		triggersInNewSchemaByTableName: triggersInNewSchemaByTableName,
 	}
 	tableGraphs, err := diff.tableDiffs.resolveToSQLGraph(&tableSQLVertexGenerator)
 	if err != nil {
 		return nil, fmt.Errorf("resolving table sql graphs: %w", err)
 	}
 
-	indexesInNewSchemaByTableName := make(map[string][]schema.Index)
-	for _, idx := range diff.new.Indexes {
-		indexesInNewSchemaByTableName[idx.TableName] = append(indexesInNewSchemaByTableName[idx.TableName], idx)
+	existingDefaultPartitionsByParentName := make(map[string]schema.Table)
+	for _, tableDiff := range diff.tableDiffs.alters {
+		if tableDiff.new.IsDefaultPartition() {
+			existingDefaultPartitionsByParentName[tableDiff.new.ParentTableName] = tableDiff.new
+		}
 	}
 	attachPartitionSQLVertexGenerator := attachPartitionSQLVertexGenerator{
-		indexesInNewSchemaByTableName: indexesInNewSchemaByTableName,
+		indexesInNewSchemaByTableName:         indexesInNewSchemaByTableName,
+		existingDefaultPartitionsByParentName: existingDefaultPartitionsByParentName,
+		moveRowsFromDefaultPartition:          s.moveRowsFromDefaultPartition,
 	}
 	attachPartitionGraphs, err := diff.tableDiffs.resolveToSQLGraph(&attachPartitionSQLVertexGenerator)
 	if err != nil {
@@ -370,6 +474,22 @@ func (schemaSQLGenerator) Alter(diff schemaDiff) ([]Statement, error) {
 	if err := tableGraphs.union(attachPartitionGraphs); err != nil {
 		return nil, fmt.Errorf("unioning table and attach partition graphs: %w", err)
 	}
+	if s.rewriteTablesToReorderColumns {
+		tableRewriteSQLVertexGenerator := tableRewriteSQLVertexGenerator{
+			tableSizesInBytesByName:        s.tableSizesInBytesByName,
+			indexesInOldSchemaByTableName:  indexesInOldSchemaByTableName,
+			indexesInNewSchemaByTableName:  indexesInNewSchemaByTableName,
+			triggersInOldSchemaByTableName: triggersInOldSchemaByTableName,
+			triggersInNewSchemaByTableName: triggersInNewSchemaByTableName,
+		}
+		tableRewriteGraphs, err := diff.tableDiffs.resolveToSQLGraph(&tableRewriteSQLVertexGenerator)
+		if err != nil {
+			return nil, fmt.Errorf("resolving table rewrite sql graphs: %w", err)
+		}
+		if err := tableGraphs.union(tableRewriteGraphs); err != nil {
+			return nil, fmt.Errorf("unioning table and table rewrite graphs: %w", err)
+		}
+	}
 	if err := tableGraphs.union(indexGraphs); err != nil {
 		return nil, fmt.Errorf("unioning table and index graphs: %w", err)
 	}
@@ -397,6 +517,22 @@ func buildSchemaObjMap[S schema.Object](s []S) map[string]S {
 type tableSQLVertexGenerator struct {
 	deletedTablesByName     map[string]schema.Table
 	tablesInNewSchemaByName map[string]schema.Table
+	// alteredFunctionsByName contains the functions whose definitions, or the definitions of the functions they
+	// depend on, are being altered
+	alteredFunctionsByName map[string]schema.Function
+	// rewriteTablesToReorderColumns indicates column ordering changes are resolved by the tableRewriteSQLVertexGenerator
+	rewriteTablesToReorderColumns bool
+	binaryCoercibleCasts          map[binaryCoercibleCast]bool
+	typeConversionRules           []TypeConversionRule
+	// shadowColumnTypeChanges indicates the types of columns are changed via shadow columns where possible. The
+	// remaining fields are used to identify the columns whose types can be changed via shadow columns and to derive
+	// the timeouts of the backfills
+	shadowColumnTypeChanges        bool
+	tableSizesInBytesByName        map[string]int64
+	indexesInOldSchemaByTableName  map[string][]schema.Index
+	indexesInNewSchemaByTableName  map[string][]schema.Index
+	triggersInOldSchemaByTableName map[string][]schema.Trigger
+	triggersInNewSchemaByTableName map[string][]schema.Trigger
 }
 
 var _ sqlVertexGenerator[schema.Table, tableDiff] = &tableSQLVertexGenerator{}
@@ -433,7 +569,7 @@ func (t *tableSQLVertexGenerator) Add(table schema.Table) ([]Statement, error) {
 		Timeout: statementTimeoutDefault,
 	})
 
-	csg := checkConstraintSQLGenerator{tableName: table.Name}
+	csg := checkConstraintSQLGenerator{tableName: table.Name, isNewTable: true}
 	for _, checkCon := range table.CheckConstraints {
 		addConStmts, err := csg.Add(checkCon)
 		if err != nil {
@@ -461,8 +597,9 @@ func (t *tableSQLVertexGenerator) Delete(table schema.Table) ([]Statement, error
 	}
 	return []Statement{
 		{
-			DDL:     fmt.Sprintf("DROP TABLE %s", schema.EscapeIdentifier(table.Name)),
-			Timeout: statementTimeoutTableDrop,
+			DDL:         fmt.Sprintf("DROP TABLE %s", schema.EscapeIdentifier(table.Name)),
+			Timeout:     statementTimeoutTableDrop,
+			LockTimeout: lockTimeoutDefault,
 			Hazards: []MigrationHazard{{
 				Type:    MigrationHazardTypeDeletesData,
 				Message: "Deletes all rows in the table (and the table itself)",
@@ -482,13 +619,37 @@ func (t *tableSQLVertexGenerator) Alter(diff tableDiff) ([]Statement, error) {
 		return nil, fmt.Errorf("changing partition key def: %w", ErrNotImplemented)
 	}
 
-	columnSQLGenerator := columnSQLGenerator{tableName: diff.new.Name}
+	if t.rewriteTablesToReorderColumns && canRewriteTableToReorderColumns(diff.new) {
+		// The table will be rewritten with the new column ordering after it is altered
+		diff = diff.withoutColumnOrderingChanges()
+	}
+
+	shadowColumnNames := make(map[string]bool)
+	if t.shadowColumnTypeChanges {
+		for _, colDiff := range diff.columnsDiff.alters {
+			if t.canChangeTypeViaShadowColumn(diff, colDiff) {
+				shadowColumnNames[colDiff.new.Name] = true
+			}
+		}
+	}
+
+	columnSQLGenerator := columnSQLGenerator{
+		tableName:               diff.new.Name,
+		isPartitioned:           diff.new.IsPartitioned(),
+		binaryCoercibleCasts:    t.binaryCoercibleCasts,
+		typeConversionRules:     t.typeConversionRules,
+		shadowColumnNames:       shadowColumnNames,
+		tableSizesInBytesByName: t.tableSizesInBytesByName,
+	}
 	columnGeneratedSQL, err := diff.columnsDiff.resolveToSQLGroupedByEffect(&columnSQLGenerator)
 	if err != nil {
 		return nil, fmt.Errorf("resolving index diff: %w", err)
 	}
 
-	checkConSQLGenerator := checkConstraintSQLGenerator{tableName: diff.new.Name}
+	checkConSQLGenerator := checkConstraintSQLGenerator{
+		tableName:              diff.new.Name,
+		alteredFunctionsByName: t.alteredFunctionsByName,
+	}
 	checkConGeneratedSQL, err := diff.checkConstraintDiff.resolveToSQLGroupedByEffect(&checkConSQLGenerator)
 	if err != nil {
 		return nil, fmt.Errorf("Resolving check constraints diff: %w", err)
@@ -533,10 +694,11 @@ func (t *tableSQLVertexGenerator) alterPartition(diff tableDiff) ([]Statement, e
 				},
 			})
 		} else {
-			stmts = append(stmts, Statement{
-				DDL:     fmt.Sprintf("%s SET NOT NULL", alterColumnPrefix),
-				Timeout: statementTimeoutDefault,
-			})
+			setNotNullStmts, err := buildOnlineSetNotNullStatements(diff.new.Name, colDiff.new.Name)
+			if err != nil {
+				return nil, fmt.Errorf("building set not null statements: %w", err)
+			}
+			stmts = append(stmts, setNotNullStmts...)
 		}
 	}
 
@@ -547,7 +709,7 @@ func (t *tableSQLVertexGenerator) GetSQLVertexId(table schema.Table) string {
 	return buildTableVertexId(table.Name)
 }
 
-func (t *tableSQLVertexGenerator) GetAddAlterDependencies(table, _ schema.Table) []dependency {
+func (t *tableSQLVertexGenerator) GetAddAlterDependencies(table, oldTable schema.Table) []dependency {
 	deps := []dependency{
 		mustRun(t.GetSQLVertexId(table), diffTypeAddAlter).after(t.GetSQLVertexId(table), diffTypeDelete),
 	}
@@ -557,6 +719,44 @@ func (t *tableSQLVertexGenerator) GetAddAlterDependencies(table, _ schema.Table)
 			mustRun(t.GetSQLVertexId(table), diffTypeAddAlter).after(buildTableVertexId(table.ParentTableName), diffTypeAddAlter),
 		)
 	}
+
+	// The check constraints must be added after the functions they call are created/altered, and the old check
+	// constraints must be dropped before the functions they call are dropped
+	for _, checkCon := range table.CheckConstraints {
+		for _, depFunction := range checkCon.DependsOnFunctions {
+			deps = append(deps, mustRun(t.GetSQLVertexId(table), diffTypeAddAlter).after(buildFunctionVertexId(depFunction), diffTypeAddAlter))
+		}
+	}
+	for _, checkCon := range oldTable.CheckConstraints {
+		for _, depFunction := range checkCon.DependsOnFunctions {
+			deps = append(deps, mustRun(t.GetSQLVertexId(table), diffTypeAddAlter).before(buildFunctionVertexId(depFunction), diffTypeDelete))
+		}
+	}
+
+	// Similarly, the column defaults must be set after the functions they call are created/altered, and the old
+	// column defaults must be removed before the functions they call are dropped
+	for _, column := range table.Columns {
+		for _, depFunction := range column.DependsOnFunctions {
+			deps = append(deps, mustRun(t.GetSQLVertexId(table), diffTypeAddAlter).after(buildFunctionVertexId(depFunction), diffTypeAddAlter))
+		}
+	}
+	for _, column := range oldTable.Columns {
+		for _, depFunction := range column.DependsOnFunctions {
+			deps = append(deps, mustRun(t.GetSQLVertexId(table), diffTypeAddAlter).before(buildFunctionVertexId(depFunction), diffTypeDelete))
+		}
+	}
+
+	// The same applies to the sequences used by the column defaults, e.g., via nextval
+	for _, column := range table.Columns {
+		for _, depSequence := range column.DependsOnSequences {
+			deps = append(deps, mustRun(t.GetSQLVertexId(table), diffTypeAddAlter).after(buildSequenceVertexId(depSequence), diffTypeAddAlter))
+		}
+	}
+	for _, column := range oldTable.Columns {
+		for _, depSequence := range column.DependsOnSequences {
+			deps = append(deps, mustRun(t.GetSQLVertexId(table), diffTypeAddAlter).before(buildSequenceVertexId(depSequence), diffTypeDelete))
+		}
+	}
 	return deps
 }
 
@@ -567,18 +767,215 @@ func (t *tableSQLVertexGenerator) GetDeleteDependencies(table schema.Table) []de
 			mustRun(t.GetSQLVertexId(table), diffTypeDelete).after(buildTableVertexId(table.ParentTableName), diffTypeDelete),
 		)
 	}
+	for _, checkCon := range table.CheckConstraints {
+		for _, depFunction := range checkCon.DependsOnFunctions {
+			deps = append(deps, mustRun(t.GetSQLVertexId(table), diffTypeDelete).before(buildFunctionVertexId(depFunction), diffTypeDelete))
+		}
+	}
+	for _, column := range table.Columns {
+		for _, depFunction := range column.DependsOnFunctions {
+			deps = append(deps, mustRun(t.GetSQLVertexId(table), diffTypeDelete).before(buildFunctionVertexId(depFunction), diffTypeDelete))
+		}
+		for _, depSequence := range column.DependsOnSequences {
+			deps = append(deps, mustRun(t.GetSQLVertexId(table), diffTypeDelete).before(buildSequenceVertexId(depSequence), diffTypeDelete))
+		}
+	}
 	return deps
 }
 
+// buildOnlineSetNotNullStatements builds the statements to mark a column as NOT NULL without locking out writes for the
+// duration of a table scan. SET NOT NULL scans the table while holding an ACCESS EXCLUSIVE lock unless a validated
+// CHECK (col IS NOT NULL) constraint proves the column has no nulls. A temporary constraint is added as NOT VALID and
+// validated, which does not lock out reads or writes, such that SET NOT NULL only needs to update the catalog
+func buildOnlineSetNotNullStatements(tableName, columnName string) ([]Statement, error) {
+	tempConstraintName, err := generateNonConflictingName(columnName + "_not_null")
+	if err != nil {
+		return nil, fmt.Errorf("generating non-conflicting name: %w", err)
+	}
+
+	return []Statement{
+		{
+			DDL: fmt.Sprintf("%s ADD CONSTRAINT %s CHECK(%s IS NOT NULL) NOT VALID",
+				alterTablePrefix(tableName),
+				schema.EscapeIdentifier(tempConstraintName),
+				schema.EscapeIdentifier(columnName),
+			),
+			Timeout: statementTimeoutDefault,
+		},
+		{
+			DDL:         fmt.Sprintf("%s VALIDATE CONSTRAINT %s", alterTablePrefix(tableName), schema.EscapeIdentifier(tempConstraintName)),
+			Timeout:     statementTimeoutConstraintValidation,
+			LockTimeout: statementTimeoutConstraintValidation,
+			// The validation must not hold the ACCESS EXCLUSIVE lock acquired by adding the constraint
+			IsNonTransactional: true,
+		},
+		{
+			DDL:     fmt.Sprintf("%s ALTER COLUMN %s SET NOT NULL", alterTablePrefix(tableName), schema.EscapeIdentifier(columnName)),
+			Timeout: statementTimeoutDefault,
+			Hazards: []MigrationHazard{
+				{
+					Type: MigrationHazardTypeAcquiresAccessExclusiveLock,
+					Message: "Marking a column as not null briefly acquires an ACCESS EXCLUSIVE lock. The table is not " +
+						"scanned because the validated check constraint proves the column has no nulls.",
+				},
+			},
+		},
+		{
+			DDL:     dropConstraintDDL(tableName, tempConstraintName),
+			Timeout: statementTimeoutDefault,
+		},
+	}, nil
+}
+
+// buildBackfillDDL builds a DO block that runs the update in batches of backfillBatchPages pages of the table, such
+// that each batch is committed separately and row locks are only held for the duration of the batch. Rows inserted or
+// moved past the last page while the backfill is running are not updated, so the caller must ensure they are already
+// backfilled, e.g., via a default or a trigger. The condition is optional.
+//
+// The batches are bounded by ctid ranges, which Postgres 14 and above resolve via TID range scans that only read the
+// batch's pages. Older versions scan the whole table for every batch, making the backfill quadratic
+func buildBackfillDDL(tableName, assignment, condition string) string {
+	escapedTableName := schema.EscapeIdentifier(tableName)
+	batchCondition := fmt.Sprintf("ctid >= format('(%%s,0)', batch_start_page)::TID AND ctid < format('(%%s,0)', batch_start_page + %d)::TID", backfillBatchPages)
+	if len(condition) > 0 {
+		batchCondition = fmt.Sprintf("(%s) AND %s", condition, batchCondition)
+	}
+	return fmt.Sprintf("DO $pgschemadiff$\n"+
+		"DECLARE\n"+
+		"\tnum_pages BIGINT := pg_catalog.pg_relation_size(%s) / pg_catalog.current_setting('block_size')::BIGINT;\n"+
+		"\tbatch_start_page BIGINT := 0;\n"+
+		"BEGIN\n"+
+		"\tWHILE batch_start_page <= num_pages LOOP\n"+
+		"\t\tUPDATE %s SET %s\n"+
+		"\t\t\tWHERE %s;\n"+
+		"\t\tCOMMIT;\n"+
+		"\t\tbatch_start_page := batch_start_page + %d;\n"+
+		"\tEND LOOP;\n"+
+		"END\n"+
+		"$pgschemadiff$",
+		escapeStringLiteral(escapedTableName),
+		escapedTableName,
+		assignment,
+		batchCondition,
+		backfillBatchPages,
+	)
+}
+
 type columnSQLGenerator struct {
 	tableName string
+	// isPartitioned indicates the table is partitioned. Marking the columns of partitioned tables as NOT NULL recurses
+	// to the partitions, so it can't be done online
+	isPartitioned bool
+	// binaryCoercibleCasts is used to identify type changes that don't re-write the table
+	binaryCoercibleCasts map[binaryCoercibleCast]bool
+	typeConversionRules  []TypeConversionRule
+	// shadowColumnNames contains the columns whose types are changed via shadow columns. tableSizesInBytesByName is
+	// used to derive the timeouts of their backfills
+	shadowColumnNames       map[string]bool
+	tableSizesInBytesByName map[string]int64
 }
 
 func (csg *columnSQLGenerator) Add(column schema.Column) ([]Statement, error) {
-	return []Statement{{
+	if column.IsDefaultVolatile {
+		if csg.isPartitioned {
+			return []Statement{{
+				DDL:     fmt.Sprintf("%s ADD COLUMN %s", alterTablePrefix(csg.tableName), buildColumnDefinition(column)),
+				Timeout: statementTimeoutDefault,
+				Hazards: []MigrationHazard{{
+					Type: MigrationHazardTypeAcquiresAccessExclusiveLock,
+					Message: "The column's default is volatile, so it is evaluated for every existing row. This will " +
+						"completely lock the table while it is re-written.",
+				}},
+			}}, nil
+		}
+		return csg.addColumnWithVolatileDefault(column)
+	}
+
+	var stmts []Statement
+	if !column.IsNullable && len(column.Default) == 0 {
+		// Otherwise, adding the column fails on a non-empty table with an error about null values that does not explain
+		// the column needs a default
+		stmts = append(stmts, Statement{
+			DDL: fmt.Sprintf("DO $pgschemadiff$\n"+
+				"BEGIN\n"+
+				"\tIF EXISTS (SELECT 1 FROM %s) THEN\n"+
+				"\t\tRAISE EXCEPTION %s;\n"+
+				"\tEND IF;\n"+
+				"END\n"+
+				"$pgschemadiff$",
+				schema.EscapeIdentifier(csg.tableName),
+				// RAISE treats % as a placeholder
+				escapeStringLiteral(strings.ReplaceAll(
+					fmt.Sprintf("Cannot add NOT NULL column %s without a default to non-empty table %s",
+						schema.EscapeIdentifier(column.Name), schema.EscapeIdentifier(csg.tableName),
+					),
+					"%", "%%",
+				)),
+			),
+			Timeout: statementTimeoutDefault,
+			Hazards: []MigrationHazard{{
+				Type: MigrationHazardTypeFailsOnExistingData,
+				Message: "This statement fails the migration if the table has any rows, since the column is NOT NULL and " +
+					"has no default to fill the existing rows with. Add a default to the column if the table might have " +
+					"rows.",
+			}},
+		})
+	}
+	return append(stmts, Statement{
 		DDL:     fmt.Sprintf("%s ADD COLUMN %s", alterTablePrefix(csg.tableName), buildColumnDefinition(column)),
 		Timeout: statementTimeoutDefault,
-	}}, nil
+	}), nil
+}
+
+// addColumnWithVolatileDefault adds a column whose default is volatile without re-writing the table. The column is
+// added without a default, and the default is set in the same statement, such that it only applies to new rows. The
+// existing rows are then backfilled in batches
+func (csg *columnSQLGenerator) addColumnWithVolatileDefault(column schema.Column) ([]Statement, error) {
+	nullableColumn := column
+	nullableColumn.IsNullable = true
+	nullableColumn.Default = ""
+	stmts := []Statement{
+		{
+			DDL: fmt.Sprintf("%s ADD COLUMN %s, ALTER COLUMN %s SET DEFAULT %s",
+				alterTablePrefix(csg.tableName),
+				buildColumnDefinition(nullableColumn),
+				schema.EscapeIdentifier(column.Name),
+				column.Default,
+			),
+			Timeout: statementTimeoutDefault,
+			Hazards: []MigrationHazard{{
+				Type: MigrationHazardTypeAcquiresAccessExclusiveLock,
+				Message: "Adding the column briefly acquires an ACCESS EXCLUSIVE lock. The table is not re-written " +
+					"because the volatile default only applies to new rows.",
+			}},
+		},
+		{
+			DDL: buildBackfillDDL(csg.tableName,
+				fmt.Sprintf("%s = DEFAULT", schema.EscapeIdentifier(column.Name)),
+				fmt.Sprintf("%s IS NULL", schema.EscapeIdentifier(column.Name)),
+			),
+			Timeout:     getTableRewriteTimeout(csg.tableSizesInBytesByName, csg.tableName),
+			LockTimeout: lockTimeoutDefault,
+			// The backfill commits each batch, which can't be done in a transaction
+			IsNonTransactional: true,
+			Hazards: []MigrationHazard{{
+				Type: MigrationHazardTypeImpactsDatabasePerformance,
+				Message: "Every existing row of the table is updated to backfill the column's default, which puts " +
+					"increased load on the database and leaves behind dead rows. The rows are updated in batches, " +
+					"each committed separately, so reads and writes are not locked out. Nulls explicitly written to " +
+					"the column before the backfill completes will be overwritten. The batches are found via TID range " +
+					"scans, which require Postgres 14 or above. On older versions, every batch scans the whole table.",
+			}},
+		},
+	}
+	if !column.IsNullable {
+		setNotNullStmts, err := buildOnlineSetNotNullStatements(csg.tableName, column.Name)
+		if err != nil {
+			return nil, fmt.Errorf("building set not null statements: %w", err)
+		}
+		stmts = append(stmts, setNotNullStmts...)
+	}
+	return stmts, nil
 }
 
 func (csg *columnSQLGenerator) Delete(column schema.Column) ([]Statement, error) {
@@ -599,6 +996,10 @@ func (csg *columnSQLGenerator) Alter(diff columnDiff) ([]Statement, error) {
 		return nil, fmt.Errorf("old=%d; new=%d: %w", diff.oldOrdering, diff.newOrdering, ErrColumnOrderingChanged)
 	}
 	oldColumn, newColumn := diff.old, diff.new
+	if csg.shadowColumnNames[newColumn.Name] {
+		return csg.changeTypeViaShadowColumn(oldColumn, newColumn)
+	}
+
 	var stmts []Statement
 	alterColumnPrefix := fmt.Sprintf("%s ALTER COLUMN %s", alterTablePrefix(csg.tableName), schema.EscapeIdentifier(newColumn.Name))
 
@@ -608,7 +1009,7 @@ func (csg *columnSQLGenerator) Alter(diff columnDiff) ([]Statement, error) {
 				DDL:     fmt.Sprintf("%s DROP NOT NULL", alterColumnPrefix),
 				Timeout: statementTimeoutDefault,
 			})
-		} else {
+		} else if csg.isPartitioned {
 			stmts = append(stmts, Statement{
 				DDL:     fmt.Sprintf("%s SET NOT NULL", alterColumnPrefix),
 				Timeout: statementTimeoutDefault,
@@ -619,6 +1020,12 @@ func (csg *columnSQLGenerator) Alter(diff columnDiff) ([]Statement, error) {
 					},
 				},
 			})
+		} else {
+			setNotNullStmts, err := buildOnlineSetNotNullStatements(csg.tableName, newColumn.Name)
+			if err != nil {
+				return nil, fmt.Errorf("building set not null statements: %w", err)
+			}
+			stmts = append(stmts, setNotNullStmts...)
 		}
 	}
 
@@ -633,31 +1040,19 @@ func (csg *columnSQLGenerator) Alter(diff columnDiff) ([]Statement, error) {
 
 	if !strings.EqualFold(oldColumn.Type, newColumn.Type) ||
 		!strings.EqualFold(oldColumn.Collation.GetFQEscapedName(), newColumn.Collation.GetFQEscapedName()) {
-		stmts = append(stmts,
-			[]Statement{
-				csg.generateTypeTransformationStatement(
-					alterColumnPrefix,
-					schema.EscapeIdentifier(newColumn.Name),
-					oldColumn.Type,
-					newColumn.Type,
-					newColumn.Collation,
-				),
-				// When "SET TYPE" is used to alter a column, that column's statistics are removed, which could
-				// affect query plans. In order to mitigate the effect on queries, re-generate the statistics for the
-				// column before continuing with the migration.
-				{
-					DDL:     fmt.Sprintf("ANALYZE %s (%s)", schema.EscapeIdentifier(csg.tableName), schema.EscapeIdentifier(newColumn.Name)),
-					Timeout: statementTimeoutAnalyzeColumn,
-					Hazards: []MigrationHazard{
-						{
-							Type: MigrationHazardTypeImpactsDatabasePerformance,
-							Message: "Running analyze will read rows from the table, putting increased load " +
-								"on the database and consuming database resources. It won't prevent reads/writes to " +
-								"the table, but it could affect performance when executing queries.",
-						},
-					},
-				},
-			}...)
+		stmts = append(stmts, csg.generateTypeTransformationStatement(
+			alterColumnPrefix,
+			schema.EscapeIdentifier(newColumn.Name),
+			oldColumn,
+			newColumn,
+		))
+		if !csg.isTypeChangeWithoutRewrite(oldColumn, newColumn) {
+			// When "SET TYPE" is used to alter a column, that column's statistics are removed, which could
+			// affect query plans. In order to mitigate the effect on queries, re-generate the statistics for the
+			// column before continuing with the migration. Type changes that don't re-write the table are not
+			// followed by a scan of the table, so their hazard asks to analyze the column separately instead
+			stmts = append(stmts, buildAnalyzeColumnStatement(csg.tableName, newColumn.Name))
+		}
 	}
 
 	if oldColumn.Default != newColumn.Default && len(newColumn.Default) > 0 {
@@ -672,48 +1067,76 @@ func (csg *columnSQLGenerator) Alter(diff columnDiff) ([]Statement, error) {
 	return stmts, nil
 }
 
+// isTypeChangeWithoutRewrite returns true if changing the column's type and collation does not re-write the table.
+// Type changes using a type conversion rule are assumed to re-write the table
+func (csg *columnSQLGenerator) isTypeChangeWithoutRewrite(oldColumn, newColumn schema.Column) bool {
+	if _, ok := findTypeConversionRule(csg.typeConversionRules, csg.tableName, oldColumn, newColumn); ok {
+		return false
+	}
+	return oldColumn.Collation.GetFQEscapedName() == newColumn.Collation.GetFQEscapedName() &&
+		isTypeChangeWithoutRewrite(oldColumn.Type, newColumn.Type, csg.binaryCoercibleCasts)
+}
+
 func (csg *columnSQLGenerator) generateTypeTransformationStatement(
 	prefix string,
 	name string,
-	oldType string,
-	newType string,
-	newTypeCollation schema.SchemaQualifiedName,
+	oldColumn schema.Column,
+	newColumn schema.Column,
 ) Statement {
-	if strings.EqualFold(oldType, "bigint") &&
-		strings.EqualFold(newType, "timestamp without time zone") {
+	newType, newTypeCollation := newColumn.Type, newColumn.Collation
+	collationModifier := ""
+	if !newTypeCollation.IsEmpty() {
+		collationModifier = fmt.Sprintf("COLLATE %s ", newTypeCollation.GetFQEscapedName())
+	}
+
+	if rule, ok := findTypeConversionRule(csg.typeConversionRules, csg.tableName, oldColumn, newColumn); ok {
+		hazardMessage := rule.HazardMessage
+		if len(hazardMessage) == 0 {
+			hazardMessage = fmt.Sprintf("This will completely lock the table while the data is being re-written. "+
+				"The values will be converted using the expression: %s", rule.buildUsingExpression(newColumn.Name))
+		}
 		return Statement{
-			DDL: fmt.Sprintf("%s SET DATA TYPE %s using to_timestamp(%s / 1000)",
+			DDL: fmt.Sprintf("%s SET DATA TYPE %s %susing %s",
 				prefix,
 				newType,
-				name,
+				collationModifier,
+				rule.buildUsingExpression(newColumn.Name),
 			),
 			Timeout: statementTimeoutDefault,
+			// The table is re-written, so the statement is not grouped with the preceding statements to avoid holding
+			// their locks during the re-write
+			IsNonTransactional: true,
 			Hazards: []MigrationHazard{{
-				Type: MigrationHazardTypeAcquiresAccessExclusiveLock,
-				Message: "This will completely lock the table while the data is being " +
-					"re-written for a duration of time that scales with the size of your data. " +
-					"The values previously stored as BIGINT will be translated into a " +
-					"TIMESTAMP value via the PostgreSQL to_timestamp() function. This " +
-					"translation will assume that the values stored in BIGINT represent a " +
-					"millisecond epoch value.",
+				Type:    MigrationHazardTypeAcquiresAccessExclusiveLock,
+				Message: hazardMessage,
 			}},
 		}
 	}
 
-	collationModifier := ""
-	if !newTypeCollation.IsEmpty() {
-		collationModifier = fmt.Sprintf("COLLATE %s ", newTypeCollation.GetFQEscapedName())
+	ddl := fmt.Sprintf("%s SET DATA TYPE %s %susing %s::%s",
+		prefix,
+		newType,
+		collationModifier,
+		name,
+		newType,
+	)
+	if csg.isTypeChangeWithoutRewrite(oldColumn, newColumn) {
+		return Statement{
+			DDL:     ddl,
+			Timeout: statementTimeoutDefault,
+			Hazards: []MigrationHazard{{
+				Type: MigrationHazardTypeAcquiresAccessExclusiveLock,
+				Message: "This will briefly lock the table while the column's type is changed. The table will not be " +
+					"re-written because the new type is binary-coercible from the old type. The column's statistics " +
+					"are removed and not re-generated by the migration, so consider analyzing the column afterwards.",
+			}},
+		}
 	}
 
 	return Statement{
-		DDL: fmt.Sprintf("%s SET DATA TYPE %s %susing %s::%s",
-			prefix,
-			newType,
-			collationModifier,
-			name,
-			newType,
-		),
-		Timeout: statementTimeoutDefault,
+		DDL:                ddl,
+		Timeout:            statementTimeoutDefault,
+		IsNonTransactional: true,
 		Hazards: []MigrationHazard{{
 			Type: MigrationHazardTypeAcquiresAccessExclusiveLock,
 			Message: "This will completely lock the table while the data is being re-written. " +
@@ -753,7 +1176,7 @@ func (rsg *renameConflictingIndexSQLVertexGenerator) Add(index schema.Index) ([]
 		return nil, nil
 	}
 
-	newName, err := rsg.generateNonConflictingName(index)
+	newName, err := generateNonConflictingName(index.Name)
 	if err != nil {
 		return nil, fmt.Errorf("generating non-conflicting name: %w", err)
 	}
@@ -766,21 +1189,6 @@ func (rsg *renameConflictingIndexSQLVertexGenerator) Add(index schema.Index) ([]
 	}}, nil
 }
 
-func (rsg *renameConflictingIndexSQLVertexGenerator) generateNonConflictingName(index schema.Index) (string, error) {
-	uuid, err := uuid.NewRandom()
-	if err != nil {
-		return "", fmt.Errorf("generating UUID: %w", err)
-	}
-
-	newNameSuffix := fmt.Sprintf("_%s", uuid.String())
-	idxNameTruncationIdx := len(index.Name)
-	if len(index.Name) > maxPostgresIdentifierSize-len(newNameSuffix) {
-		idxNameTruncationIdx = maxPostgresIdentifierSize - len(newNameSuffix)
-	}
-
-	return index.Name[:idxNameTruncationIdx] + newNameSuffix, nil
-}
-
 func (rsg *renameConflictingIndexSQLVertexGenerator) getRenames() map[string]string {
 	return rsg.indexRenamesByOldName
 }
@@ -846,8 +1254,9 @@ func (isg *indexSQLVertexGenerator) addIdxStmtsWithHazards(index schema.Index) (
 		if index.IsPartitionOfIndex() {
 			if parentIdx, ok := isg.indexesInNewSchemaByName[index.ParentIdxName]; !ok {
 				return nil, fmt.Errorf("could not find parent index %s", index.ParentIdxName)
-			} else if parentIdx.IsPk {
-				// All indexes associated with parent primary keys are automatically created by their parent
+			} else if _, isNewTable := isg.addedTablesByName[index.TableName]; parentIdx.IsPk && isNewTable {
+				// Indexes associated with parent primary keys are automatically created when the new partition is
+				// attached
 				return nil, nil
 			}
 		}
@@ -855,78 +1264,98 @@ func (isg *indexSQLVertexGenerator) addIdxStmtsWithHazards(index schema.Index) (
 		if isOnPartitionedTable, err := isg.isOnPartitionedTable(index); err != nil {
 			return nil, err
 		} else if isOnPartitionedTable {
-			// A partitioned table can't have a constraint added to it with "USING INDEX", so just make the index
-			// automatically through the constraint. This currently blocks and is a dangerous operation
-			// If users want to be able to switch primary keys concurrently, support can be added in the future,
-			// with a similar strategy to adding indexes to a partitioned table
-			return []Statement{
-				{
-					DDL: fmt.Sprintf("%s ADD CONSTRAINT %s PRIMARY KEY (%s)",
-						alterTablePrefix(index.TableName),
-						schema.EscapeIdentifier(index.Name),
-						strings.Join(formattedNamesForSQL(index.Columns), ", "),
-					),
-					Timeout: statementTimeoutDefault,
-					Hazards: []MigrationHazard{
-						{
-							Type:    MigrationHazardTypeAcquiresShareLock,
-							Message: "This will lock writes to the table while the index build occurs.",
-						},
-						{
-							Type: MigrationHazardTypeIndexBuild,
-							Message: "This is non-concurrent because adding PK's concurrently hasn't been" +
-								"implemented yet. It WILL lock out writes. Index builds require a non-trivial " +
-								"amount of CPU as well, which might affect database performance",
-						},
-					},
-				},
-			}, nil
+			return []Statement{isg.addPkConstraintToPartitionedTable(index)}, nil
 		}
 	}
 
 	var stmts []Statement
 	var createIdxStmtHazards []MigrationHazard
 
-	createIdxStmt := string(index.GetIndexDefStmt)
+	var createIdxStmt string
 	createIdxStmtTimeout := statementTimeoutDefault
+	var createIdxStmtLockTimeout time.Duration
+	var createIdxStmtIsNonTransactional bool
 	if isOnPartitionedTable, err := isg.isOnPartitionedTable(index); err != nil {
 		return nil, err
-	} else if !isOnPartitionedTable {
+	} else if isOnPartitionedTable {
+		// Indexes on partitioned tables can't be created concurrently. Instead, the index is only created on the
+		// partitioned table itself, which is fast but leaves the index invalid. Each partition's index is then built
+		// concurrently and attached by its own statements (see the partition of index vertices). The last attach makes
+		// the parent index valid
+		onlyCreateIdxStmt, err := index.GetIndexDefStmt.ToCreateIndexOnOnly()
+		if err != nil {
+			return nil, fmt.Errorf("modifying index def statement to only: %w", err)
+		}
+		createIdxStmt = onlyCreateIdxStmt
+		if _, isNewTable := isg.addedTablesByName[index.TableName]; !isNewTable {
+			createIdxStmtHazards = append(createIdxStmtHazards, migrationHazardIndexBuiltOnOnlyPartitionedTable)
+		}
+	} else {
 		// Only indexes on non-partitioned tables can be created concurrently
 		concurrentCreateIdxStmt, err := index.GetIndexDefStmt.ToCreateIndexConcurrently()
 		if err != nil {
 			return nil, fmt.Errorf("modifying index def statement to concurrently: %w", err)
 		}
 		createIdxStmt = concurrentCreateIdxStmt
-		createIdxStmtHazards = append(createIdxStmtHazards, MigrationHazard{
-			Type: MigrationHazardTypeIndexBuild,
-			Message: "This might affect database performance. " +
-				"Concurrent index builds require a non-trivial amount of CPU, potentially affecting database performance. " +
-				"They also can take a while but do not lock out writes.",
-		})
+		createIdxStmtHazards = append(createIdxStmtHazards, migrationHazardIndexBuildConcurrently)
 		createIdxStmtTimeout = statementTimeoutConcurrentIndexBuild
+		createIdxStmtLockTimeout = statementTimeoutConcurrentIndexBuild
+		createIdxStmtIsNonTransactional = true
 	}
 
 	stmts = append(stmts, Statement{
-		DDL:     createIdxStmt,
-		Timeout: createIdxStmtTimeout,
-		Hazards: createIdxStmtHazards,
+		DDL:                createIdxStmt,
+		Timeout:            createIdxStmtTimeout,
+		LockTimeout:        createIdxStmtLockTimeout,
+		IsNonTransactional: createIdxStmtIsNonTransactional,
+		Hazards:            createIdxStmtHazards,
 	})
 
+	if index.IsPk {
+		// The primary key constraint must be added before the index is attached, since an index partition can only
+		// be attached to a parent primary key index if it also backs a primary key constraint
+		stmts = append(stmts, isg.addPkConstraintUsingIdx(index))
+	} else if len(index.ConstraintName) > 0 {
+		return nil, fmt.Errorf("constraints not supported for non-primary key indexes: %w", ErrNotImplemented)
+	}
+
 	_, isNewTable := isg.addedTablesByName[index.TableName]
 	if index.IsPartitionOfIndex() && !isNewTable {
 		// Exclude if the partition is new because the index will be attached when the partition is attached
 		stmts = append(stmts, buildAttachIndex(index))
 	}
 
-	if index.IsPk {
-		stmts = append(stmts, isg.addPkConstraintUsingIdx(index))
-	} else if len(index.ConstraintName) > 0 {
-		return nil, fmt.Errorf("constraints not supported for non-primary key indexes: %w", ErrNotImplemented)
-	}
 	return stmts, nil
 }
 
+// addPkConstraintToPartitionedTable adds a primary key constraint to a partitioned table. A partitioned table can't
+// have a constraint added to it with "USING INDEX", so the index is created through the constraint.
+//
+// If the table already exists, the constraint is only added to the partitioned table itself (ON ONLY), which leaves
+// the parent index invalid. The primary key indexes on the partitions are built concurrently and then attached
+// individually. The last attach makes the parent index valid. This mirrors how partitioned indexes are built online.
+//
+// Adding the primary key to ONLY the partitioned table fails if the key columns of any partition are nullable. This is
+// avoided because the partitioned table's columns are marked as NOT NULL before its indexes are added, which recurses
+// to the partitions.
+func (isg *indexSQLVertexGenerator) addPkConstraintToPartitionedTable(index schema.Index) Statement {
+	prefix := alterTablePrefix(index.TableName)
+	var hazards []MigrationHazard
+	if _, isNewTable := isg.addedTablesByName[index.TableName]; !isNewTable {
+		prefix = fmt.Sprintf("ALTER TABLE ONLY %s", schema.EscapeIdentifier(index.TableName))
+		hazards = append(hazards, migrationHazardPkAddedToPartitionedTable)
+	}
+	return Statement{
+		DDL: fmt.Sprintf("%s ADD CONSTRAINT %s PRIMARY KEY (%s)",
+			prefix,
+			schema.EscapeIdentifier(index.Name),
+			strings.Join(formattedNamesForSQL(index.Columns), ", "),
+		),
+		Timeout: statementTimeoutDefault,
+		Hazards: hazards,
+	}
+}
+
 func (isg *indexSQLVertexGenerator) Delete(index schema.Index) ([]Statement, error) {
 	_, tableWasDeleted := isg.deletedTablesByName[index.TableName]
 	// An index will be dropped if its owning table is dropped.
@@ -960,12 +1389,14 @@ func (isg *indexSQLVertexGenerator) Delete(index schema.Index) ([]Statement, err
 	var dropIndexStmtHazards []MigrationHazard
 	concurrentlyModifier := "CONCURRENTLY "
 	dropIndexStmtTimeout := statementTimeoutConcurrentIndexDrop
+	dropIndexStmtLockTimeout := statementTimeoutConcurrentIndexDrop
 	if isOnPartitionedTable, err := isg.isOnPartitionedTable(index); err != nil {
 		return nil, err
 	} else if isOnPartitionedTable {
 		// Currently, postgres has no good way of dropping an index partition concurrently
 		concurrentlyModifier = ""
 		dropIndexStmtTimeout = statementTimeoutDefault
+		dropIndexStmtLockTimeout = 0
 		// Technically, CONCURRENTLY also locks the table, but it waits for an "opportunity" to lock
 		// We will omit the locking hazard of concurrent drops for now
 		dropIndexStmtHazards = append(dropIndexStmtHazards, migrationHazardIndexDroppedAcquiresLock)
@@ -978,9 +1409,11 @@ func (isg *indexSQLVertexGenerator) Delete(index schema.Index) ([]Statement, err
 	}
 
 	return []Statement{{
-		DDL:     fmt.Sprintf("DROP INDEX %s%s", concurrentlyModifier, schema.EscapeIdentifier(indexName)),
-		Timeout: dropIndexStmtTimeout,
-		Hazards: append(dropIndexStmtHazards, migrationHazardIndexDroppedQueryPerf),
+		DDL:                fmt.Sprintf("DROP INDEX %s%s", concurrentlyModifier, schema.EscapeIdentifier(indexName)),
+		Timeout:            dropIndexStmtTimeout,
+		LockTimeout:        dropIndexStmtLockTimeout,
+		IsNonTransactional: len(concurrentlyModifier) > 0,
+		Hazards:            append(dropIndexStmtHazards, migrationHazardIndexDroppedQueryPerf),
 	}}, nil
 }
 
@@ -994,6 +1427,19 @@ func (isg *indexSQLVertexGenerator) Alter(diff indexDiff) ([]Statement, error) {
 		diff.old.IsInvalid = diff.new.IsInvalid
 	}
 
+	if diff.old.IsInvalid && !diff.new.IsInvalid {
+		// Invalid indexes are normally re-created. This is only reached if the diff was transformed to rebuild the
+		// invalid index in place (see rebuildInvalidIndexesInPlace)
+		stmts = append(stmts, Statement{
+			DDL:                fmt.Sprintf("REINDEX INDEX CONCURRENTLY %s", schema.EscapeIdentifier(diff.new.Name)),
+			Timeout:            statementTimeoutConcurrentIndexBuild,
+			LockTimeout:        statementTimeoutConcurrentIndexBuild,
+			IsNonTransactional: true,
+			Hazards:            []MigrationHazard{migrationHazardIndexBuildConcurrently},
+		})
+		diff.old.IsInvalid = diff.new.IsInvalid
+	}
+
 	if !diff.new.IsPartitionOfIndex() && !diff.old.IsPk && diff.new.IsPk {
 		stmts = append(stmts, isg.addPkConstraintUsingIdx(diff.new))
 		diff.old.IsPk = diff.new.IsPk
@@ -1033,6 +1479,8 @@ func (isg *indexSQLVertexGenerator) addPkConstraintUsingIdx(index schema.Index)
 	}
 }
 
+// buildAttachIndex attaches the index of a partition to the parent partitioned index. Once the indexes of all
+// partitions are attached, Postgres marks the parent index as valid
 func buildAttachIndex(index schema.Index) Statement {
 	return Statement{
 		DDL:     fmt.Sprintf("ALTER INDEX %s ATTACH PARTITION %s", schema.EscapeIdentifier(index.ParentIdxName), schema.EscapeIdentifier(index.Name)),
@@ -1122,13 +1570,19 @@ func (isg *indexSQLVertexGenerator) addDepsOnTableAddAlterIfNecessary(index sche
 
 type checkConstraintSQLGenerator struct {
 	tableName string
+	// isNewTable is true when the owning table is created by the migration. Nothing else can be using the table, so
+	// valid constraints are added and validated in a single statement
+	isNewTable bool
+	// alteredFunctionsByName contains the functions whose definitions, or the definitions of the functions they
+	// depend on, are being altered. Check constraints that call these functions are re-validated
+	alteredFunctionsByName map[string]schema.Function
 }
 
 func (csg *checkConstraintSQLGenerator) Add(con schema.CheckConstraint) ([]Statement, error) {
-	// UDF's in check constraints are a bad idea. Check constraints are not re-validated
-	// if the UDF changes, so it's not really a safe practice. We won't support it for now
+	// Check constraints are not re-validated if the UDF's they call change, so warn the user
+	var hazards []MigrationHazard
 	if len(con.DependsOnFunctions) > 0 {
-		return nil, fmt.Errorf("check constraints that depend on UDFs: %w", ErrNotImplemented)
+		hazards = append(hazards, migrationHazardCheckConstraintDependsOnFunctions)
 	}
 
 	sb := strings.Builder{}
@@ -1139,21 +1593,29 @@ func (csg *checkConstraintSQLGenerator) Add(con schema.CheckConstraint) ([]State
 
 	if !con.IsValid {
 		sb.WriteString(" NOT VALID")
+	} else if !csg.isNewTable {
+		// Adding a valid constraint scans the whole table while holding an ACCESS EXCLUSIVE lock. Instead, add the
+		// constraint as NOT VALID, which only briefly holds the lock, and validate it separately, which does not lock out
+		// reads or writes
+		sb.WriteString(" NOT VALID")
+		return []Statement{
+			{
+				DDL:     sb.String(),
+				Timeout: statementTimeoutDefault,
+				Hazards: append(hazards, migrationHazardCheckConstraintAddedNotValid),
+			},
+			csg.buildValidateConstraintStatement(con.Name),
+		}, nil
 	}
 
 	return []Statement{{
 		DDL:     sb.String(),
 		Timeout: statementTimeoutDefault,
+		Hazards: hazards,
 	}}, nil
 }
 
 func (csg *checkConstraintSQLGenerator) Delete(con schema.CheckConstraint) ([]Statement, error) {
-	// We won't support deleting check constraints depending on UDF's to align with not supporting adding check
-	// constraints that depend on UDF's
-	if len(con.DependsOnFunctions) > 0 {
-		return nil, fmt.Errorf("check constraints that depend on UDFs: %w", ErrNotImplemented)
-	}
-
 	return []Statement{{
 		DDL:     dropConstraintDDL(csg.tableName, con.Name),
 		Timeout: statementTimeoutDefault,
@@ -1162,38 +1624,252 @@ func (csg *checkConstraintSQLGenerator) Delete(con schema.CheckConstraint) ([]St
 
 func (csg *checkConstraintSQLGenerator) Alter(diff checkConstraintDiff) ([]Statement, error) {
 	if cmp.Equal(diff.old, diff.new) {
+		if diff.new.IsValid && csg.dependsOnAlteredFunction(diff.new) {
+			// Postgres won't re-validate the constraint against the altered function, so the constraint is replaced
+			// with a newly-validated one
+			return csg.replaceWithValidatedConstraint(diff)
+		}
 		return nil, nil
 	}
 
 	oldCopy := diff.old
 	oldCopy.IsValid = diff.new.IsValid
+	oldCopy.Expression = diff.new.Expression
 	if !cmp.Equal(oldCopy, diff.new) {
-		// Technically, we could support altering expression, but I don't see the use case for it. it would require more test
-		// cases than forceReadding it, and I'm not convinced it unlocks any functionality
 		return nil, fmt.Errorf("altering check constraint to resolve the following diff %s: %w", cmp.Diff(oldCopy, diff.new), ErrNotImplemented)
 	} else if diff.old.IsValid && !diff.new.IsValid {
 		return nil, fmt.Errorf("check constraint can't go from invalid to valid")
-	} else if len(diff.old.DependsOnFunctions) > 0 || len(diff.new.DependsOnFunctions) > 0 {
-		return nil, fmt.Errorf("check constraints that depend on UDFs: %w", ErrNotImplemented)
 	}
 
-	return []Statement{{
-		DDL:     fmt.Sprintf("%s VALIDATE CONSTRAINT %s", alterTablePrefix(csg.tableName), schema.EscapeIdentifier(diff.old.Name)),
-		Timeout: statementTimeoutDefault,
-	}}, nil
+	if diff.old.Expression != diff.new.Expression {
+		return csg.replaceWithValidatedConstraint(diff)
+	}
+
+	return []Statement{csg.buildValidateConstraintStatement(diff.old.Name)}, nil
+}
+
+// buildValidateConstraintStatement builds the statement to validate a NOT VALID constraint. The validation scans the
+// table, but it does not lock out reads or writes
+func (csg *checkConstraintSQLGenerator) buildValidateConstraintStatement(conName string) Statement {
+	return Statement{
+		DDL:                fmt.Sprintf("%s VALIDATE CONSTRAINT %s", alterTablePrefix(csg.tableName), schema.EscapeIdentifier(conName)),
+		Timeout:            statementTimeoutConstraintValidation,
+		LockTimeout:        statementTimeoutConstraintValidation,
+		IsNonTransactional: true,
+		Hazards:            []MigrationHazard{migrationHazardCheckConstraintValidation},
+	}
+}
+
+// replaceWithValidatedConstraint generates the statements to replace a check constraint, e.g., to change its expression,
+// without leaving the table unprotected. A temporary constraint with the new expression is added as NOT VALID, which
+// only briefly locks the table, and then validated, which does not lock out reads or writes. Once validated, the old
+// constraint is dropped and the temporary constraint takes its name
+func (csg *checkConstraintSQLGenerator) replaceWithValidatedConstraint(diff checkConstraintDiff) ([]Statement, error) {
+	tempConstraintName, err := generateNonConflictingName(diff.new.Name)
+	if err != nil {
+		return nil, fmt.Errorf("generating non-conflicting name: %w", err)
+	}
+	tempConstraint := diff.new
+	tempConstraint.Name = tempConstraintName
+
+	// The table already exists, so a valid temporary constraint is added as NOT VALID and then validated
+	stmts, err := csg.Add(tempConstraint)
+	if err != nil {
+		return nil, fmt.Errorf("generating add temporary check constraint statements: %w", err)
+	}
+	return append(stmts,
+		Statement{
+			DDL:     dropConstraintDDL(csg.tableName, diff.old.Name),
+			Timeout: statementTimeoutDefault,
+		},
+		Statement{
+			DDL: fmt.Sprintf("%s RENAME CONSTRAINT %s TO %s",
+				alterTablePrefix(csg.tableName),
+				schema.EscapeIdentifier(tempConstraintName),
+				schema.EscapeIdentifier(diff.new.Name),
+			),
+			Timeout: statementTimeoutDefault,
+		},
+	), nil
+}
+
+func (csg *checkConstraintSQLGenerator) dependsOnAlteredFunction(con schema.CheckConstraint) bool {
+	for _, depFunction := range con.DependsOnFunctions {
+		if _, ok := csg.alteredFunctionsByName[depFunction.GetName()]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// buildAlteredFunctionsByName builds a map of the functions in the new schema that are being altered or that
+// (transitively) depend on functions being altered
+func buildAlteredFunctionsByName(diff schemaDiff) map[string]schema.Function {
+	isAlteredByName := make(map[string]bool)
+	for _, functionDiff := range diff.functionDiffs.alters {
+		if !cmp.Equal(functionDiff.old, functionDiff.new) {
+			isAlteredByName[functionDiff.new.GetName()] = true
+		}
+	}
+
+	functionsByName := buildSchemaObjMap(diff.new.Functions)
+	var isAltered func(name string, visited map[string]bool) bool
+	isAltered = func(name string, visited map[string]bool) bool {
+		if isAlteredByName[name] {
+			return true
+		} else if visited[name] {
+			return false
+		}
+		visited[name] = true
+		for _, depFunction := range functionsByName[name].DependsOnFunctions {
+			if isAltered(depFunction.GetName(), visited) {
+				return true
+			}
+		}
+		return false
+	}
+
+	alteredFunctionsByName := make(map[string]schema.Function)
+	for name, function := range functionsByName {
+		if isAltered(name, make(map[string]bool)) {
+			alteredFunctionsByName[name] = function
+		}
+	}
+	return alteredFunctionsByName
+}
+
+// referencesAnyColumn returns true if the expression might reference any of the columns
+func referencesAnyColumn(expression string, columnsByName map[string]schema.Column) bool {
+	for columnName := range columnsByName {
+		if sqlReferencesIdentifier(expression, columnName) {
+			return true
+		}
+	}
+	return false
 }
 
 type attachPartitionSQLVertexGenerator struct {
 	indexesInNewSchemaByTableName map[string][]schema.Index
+	// existingDefaultPartitionsByParentName is a map of parent table name to the DEFAULT partition of that table.
+	// It only contains DEFAULT partitions that already exist and are not being re-created
+	existingDefaultPartitionsByParentName map[string]schema.Table
+	moveRowsFromDefaultPartition          bool
 }
 
-func (*attachPartitionSQLVertexGenerator) Add(table schema.Table) ([]Statement, error) {
+func (a *attachPartitionSQLVertexGenerator) Add(table schema.Table) ([]Statement, error) {
 	if !table.IsPartition() {
 		return nil, nil
 	}
+	if defaultPartition, ok := a.existingDefaultPartitionsByParentName[table.ParentTableName]; ok && !table.IsDefaultPartition() {
+		return a.attachPartitionWithExistingDefaultPartition(table, defaultPartition)
+	}
 	return []Statement{buildAttachPartitionStatement(table)}, nil
 }
 
+// attachPartitionWithExistingDefaultPartition generates the statements to attach a partition to a parent table that
+// already has a DEFAULT partition. Postgres scans the DEFAULT partition while holding an ACCESS EXCLUSIVE lock on it to
+// verify that none of its rows belong in the new partition. To avoid the scan, a temporary constraint excluding the new
+// partition's values is added to the DEFAULT partition and validated (without locking out reads and writes) before
+// the partition is attached.
+func (a *attachPartitionSQLVertexGenerator) attachPartitionWithExistingDefaultPartition(table, defaultPartition schema.Table) ([]Statement, error) {
+	attachPartitionStmt := buildAttachPartitionStatement(table)
+	if len(table.PartitionConstraintDef) == 0 {
+		// Without the partition constraint, we can't build a constraint that excludes the new partition's values.
+		// Fallback to letting Postgres scan the DEFAULT partition
+		attachPartitionStmt.Timeout = statementTimeoutDefaultPartitionScan
+		attachPartitionStmt.Hazards = []MigrationHazard{
+			{
+				Type: MigrationHazardTypeAcquiresAccessExclusiveLock,
+				Message: fmt.Sprintf("Attaching the partition will scan the DEFAULT partition %s while holding an "+
+					"ACCESS EXCLUSIVE lock on it, locking out all reads and writes to it. The duration of the scan scales "+
+					"with the size of the DEFAULT partition. The attach will fail if any rows in the DEFAULT "+
+					"partition belong in the new partition.", schema.EscapeIdentifier(defaultPartition.Name)),
+			},
+		}
+		return []Statement{attachPartitionStmt}, nil
+	}
+
+	var stmts []Statement
+	if a.moveRowsFromDefaultPartition {
+		stmts = append(stmts, buildMoveRowsFromDefaultPartitionStatement(table, defaultPartition))
+	}
+
+	excludeConstraintName, err := generateNonConflictingName(fmt.Sprintf("%s_excludes_%s", defaultPartition.Name, table.Name))
+	if err != nil {
+		return nil, fmt.Errorf("generating non-conflicting name: %w", err)
+	}
+	attachPartitionStmt.Hazards = []MigrationHazard{
+		{
+			Type: MigrationHazardTypeAcquiresAccessExclusiveLock,
+			Message: fmt.Sprintf("Attaching the partition acquires an ACCESS EXCLUSIVE lock on the DEFAULT partition %s. "+
+				"The lock should be brief because the DEFAULT partition has a validated constraint excluding the new "+
+				"partition's values, so it does not need to be scanned.", schema.EscapeIdentifier(defaultPartition.Name)),
+		},
+	}
+	stmts = append(stmts,
+		Statement{
+			DDL: fmt.Sprintf("%s ADD CONSTRAINT %s CHECK(NOT (%s)) NOT VALID",
+				alterTablePrefix(defaultPartition.Name),
+				schema.EscapeIdentifier(excludeConstraintName),
+				table.PartitionConstraintDef,
+			),
+			Timeout: statementTimeoutDefault,
+		},
+		Statement{
+			DDL:                fmt.Sprintf("%s VALIDATE CONSTRAINT %s", alterTablePrefix(defaultPartition.Name), schema.EscapeIdentifier(excludeConstraintName)),
+			Timeout:            statementTimeoutDefaultPartitionScan,
+			LockTimeout:        statementTimeoutDefaultPartitionScan,
+			IsNonTransactional: true,
+			Hazards: []MigrationHazard{
+				{
+					Type: MigrationHazardTypeImpactsDatabasePerformance,
+					Message: fmt.Sprintf("Validating the constraint will scan the DEFAULT partition %s. It won't lock out "+
+						"reads or writes, but it may take a while and consume database resources. The validation will "+
+						"fail if any rows in the DEFAULT partition belong in the new partition.", schema.EscapeIdentifier(defaultPartition.Name)),
+				},
+			},
+		},
+		attachPartitionStmt,
+		Statement{
+			DDL:     dropConstraintDDL(defaultPartition.Name, excludeConstraintName),
+			Timeout: statementTimeoutDefault,
+		},
+	)
+	return stmts, nil
+}
+
+// buildMoveRowsFromDefaultPartitionStatement builds a statement that moves the rows in the DEFAULT partition that belong
+// in the new partition into the new partition. The new partition must not be attached yet.
+func buildMoveRowsFromDefaultPartitionStatement(table, defaultPartition schema.Table) Statement {
+	var columnNames []string
+	for _, column := range table.Columns {
+		columnNames = append(columnNames, column.Name)
+	}
+	escapedColumnNames := strings.Join(formattedNamesForSQL(columnNames), ", ")
+	return Statement{
+		DDL: fmt.Sprintf("WITH moved_rows AS (DELETE FROM %s WHERE %s RETURNING *) INSERT INTO %s (%s) SELECT %s FROM moved_rows",
+			schema.EscapeIdentifier(defaultPartition.Name),
+			table.PartitionConstraintDef,
+			schema.EscapeIdentifier(table.Name),
+			escapedColumnNames,
+			escapedColumnNames,
+		),
+		Timeout:     statementTimeoutDefaultPartitionScan,
+		LockTimeout: lockTimeoutDefault,
+		// The scan may take a while, so the statement is not grouped with the preceding statements to avoid holding
+		// their locks, e.g., the DEFAULT partition's ACCESS EXCLUSIVE lock from adding the constraint, during the scan
+		IsNonTransactional: true,
+		Hazards: []MigrationHazard{
+			{
+				Type: MigrationHazardTypeImpactsDatabasePerformance,
+				Message: fmt.Sprintf("Moving rows will scan the DEFAULT partition %s, deleting the rows that belong in the "+
+					"new partition and inserting them into the new partition. The deleted rows will be locked until the "+
+					"move completes, and any triggers on the tables will fire.", schema.EscapeIdentifier(defaultPartition.Name)),
+			},
+		},
+	}
+}
+
 func (*attachPartitionSQLVertexGenerator) Alter(_ tableDiff) ([]Statement, error) {
 	return nil, nil
 }
@@ -1217,6 +1893,11 @@ func (a *attachPartitionSQLVertexGenerator) GetAddAlterDependencies(table, _ sch
 	deps := []dependency{
 		mustRun(a.GetSQLVertexId(table), diffTypeAddAlter).after(buildTableVertexId(table.Name), diffTypeAddAlter),
 	}
+	if defaultPartition, ok := a.existingDefaultPartitionsByParentName[table.ParentTableName]; ok && !table.IsDefaultPartition() {
+		// The DEFAULT partition is altered (a constraint is added and potentially rows are moved) before the partition
+		// is attached. Any alterations of the DEFAULT partition itself should come first
+		deps = append(deps, mustRun(a.GetSQLVertexId(table), diffTypeAddAlter).after(buildTableVertexId(defaultPartition.Name), diffTypeAddAlter))
+	}
 
 	for _, idx := range a.indexesInNewSchemaByTableName[table.Name] {
 		deps = append(deps, mustRun(a.GetSQLVertexId(table), diffTypeAddAlter).after(buildIndexVertexId(idx.Name), diffTypeAddAlter))
@@ -1228,6 +1909,242 @@ func (a *attachPartitionSQLVertexGenerator) GetDeleteDependencies(_ schema.Table
 	return nil
 }
 
+// tableRewriteSQLVertexGenerator rewrites existing tables whose columns would otherwise not be in the desired order.
+// Postgres can only add columns to the end of a table, so the table is copied into a new table with the desired
+// column ordering, which is then swapped in for the old table.
+//
+// The rewrite runs after all other changes to the table, its indexes and its triggers, such that the new table
+// can be built to exactly match the target schema.
+type tableRewriteSQLVertexGenerator struct {
+	// tableSizesInBytesByName is used to derive the timeouts of the rewrites. It might not contain all tables
+	tableSizesInBytesByName        map[string]int64
+	indexesInOldSchemaByTableName  map[string][]schema.Index
+	indexesInNewSchemaByTableName  map[string][]schema.Index
+	triggersInOldSchemaByTableName map[string][]schema.Trigger
+	triggersInNewSchemaByTableName map[string][]schema.Trigger
+}
+
+func (*tableRewriteSQLVertexGenerator) Add(_ schema.Table) ([]Statement, error) {
+	return nil, nil
+}
+
+func (*tableRewriteSQLVertexGenerator) Delete(_ schema.Table) ([]Statement, error) {
+	return nil, nil
+}
+
+func (t *tableRewriteSQLVertexGenerator) Alter(diff tableDiff) ([]Statement, error) {
+	if !requiresRewriteToReorderColumns(diff) {
+		return nil, nil
+	}
+	table := diff.new
+
+	tempTableName, err := generateNonConflictingName(table.Name)
+	if err != nil {
+		return nil, fmt.Errorf("generating non-conflicting name: %w", err)
+	}
+
+	var columnDefs []string
+	var columnNames []string
+	for _, column := range table.Columns {
+		columnDefs = append(columnDefs, "\t"+buildColumnDefinition(column))
+		columnNames = append(columnNames, column.Name)
+	}
+	escapedColumnNames := strings.Join(formattedNamesForSQL(columnNames), ", ")
+
+	// The data is copied, and the new table's indexes and constraints are built, while the old table is locked
+	// against writes. All the statements are run in a single DO block, which is atomic, such that no writes are
+	// lost between the copy and the swap
+	body := []string{
+		fmt.Sprintf("LOCK TABLE %s IN SHARE MODE;", schema.EscapeIdentifier(table.Name)),
+		fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s;",
+			schema.EscapeIdentifier(tempTableName),
+			escapedColumnNames,
+			escapedColumnNames,
+			schema.EscapeIdentifier(table.Name),
+		),
+	}
+
+	var swapIndexNamesStmts []string
+	for _, index := range t.indexesInNewSchemaByTableName[table.Name] {
+		tempIndexName, err := generateNonConflictingName(index.Name)
+		if err != nil {
+			return nil, fmt.Errorf("generating non-conflicting name: %w", err)
+		}
+		createIdxStmt, err := index.GetIndexDefStmt.ToCreateIndexOnTable(tempIndexName, tempTableName)
+		if err != nil {
+			return nil, fmt.Errorf("modifying index def statement to temporary table: %w", err)
+		}
+		body = append(body, createIdxStmt+";")
+		if index.IsPk {
+			// The constraint will be renamed alongside its index
+			body = append(body, fmt.Sprintf("%s ADD CONSTRAINT %s PRIMARY KEY USING INDEX %s;",
+				alterTablePrefix(tempTableName),
+				schema.EscapeIdentifier(tempIndexName),
+				schema.EscapeIdentifier(tempIndexName),
+			))
+		}
+		swapIndexNamesStmts = append(swapIndexNamesStmts, fmt.Sprintf("ALTER INDEX %s RENAME TO %s;",
+			schema.EscapeIdentifier(tempIndexName),
+			schema.EscapeIdentifier(index.Name),
+		))
+	}
+
+	// The temporary table is not visible to other transactions, so its constraints are validated as they are added
+	checkConSQLGenerator := checkConstraintSQLGenerator{tableName: tempTableName, isNewTable: true}
+	for _, checkCon := range table.CheckConstraints {
+		addConStmts, err := checkConSQLGenerator.Add(checkCon)
+		if err != nil {
+			return nil, fmt.Errorf("generating add check constraint statements for check constraint %s: %w", checkCon.Name, err)
+		}
+		for _, stmt := range addConStmts {
+			body = append(body, stmt.DDL+";")
+		}
+	}
+
+	body = append(body,
+		fmt.Sprintf("LOCK TABLE %s IN ACCESS EXCLUSIVE MODE;", schema.EscapeIdentifier(table.Name)),
+		// Sequences owned by the old table's columns, e.g., serial columns, would be dropped alongside it
+		fmt.Sprintf(`FOR seq_name, column_name IN
+		SELECT dep.objid::regclass::TEXT, a.attname::TEXT
+		FROM pg_catalog.pg_depend dep
+			JOIN pg_catalog.pg_class seq ON seq.oid = dep.objid AND seq.relkind = 'S'
+			JOIN pg_catalog.pg_attribute a ON a.attrelid = dep.refobjid AND a.attnum = dep.refobjsubid
+		WHERE dep.classid = 'pg_catalog.pg_class'::regclass
+			AND dep.refobjid = %s::regclass
+			AND dep.deptype = 'a'
+	LOOP
+		EXECUTE pg_catalog.format('ALTER SEQUENCE %%s OWNED BY %%I.%%I', seq_name, %s, column_name);
+	END LOOP;`,
+			escapeStringLiteral(schema.EscapeIdentifier(table.Name)),
+			escapeStringLiteral(tempTableName),
+		),
+		fmt.Sprintf("DROP TABLE %s;", schema.EscapeIdentifier(table.Name)),
+		fmt.Sprintf("ALTER TABLE %s RENAME TO %s;", schema.EscapeIdentifier(tempTableName), schema.EscapeIdentifier(table.Name)),
+	)
+	body = append(body, swapIndexNamesStmts...)
+	for _, trigger := range t.triggersInNewSchemaByTableName[table.Name] {
+		body = append(body, string(trigger.GetTriggerDefStmt)+";")
+	}
+
+	return []Statement{
+		{
+			DDL: fmt.Sprintf("CREATE TABLE %s (\n%s\n)",
+				schema.EscapeIdentifier(tempTableName),
+				strings.Join(columnDefs, ",\n"),
+			),
+			Timeout: statementTimeoutDefault,
+		},
+		{
+			DDL: fmt.Sprintf("DO $pgschemadiff$\nDECLARE\n\tseq_name TEXT;\n\tcolumn_name TEXT;\nBEGIN\n\t%s\nEND\n$pgschemadiff$",
+				strings.Join(body, "\n\t"),
+			),
+			Timeout:     getTableRewriteTimeout(t.tableSizesInBytesByName, table.Name),
+			LockTimeout: lockTimeoutDefault,
+			// The rewrite is a single statement, so it is atomic on its own. It is not grouped with the preceding
+			// statements to avoid holding their locks during the rewrite
+			IsNonTransactional: true,
+			Hazards: []MigrationHazard{
+				{
+					Type: MigrationHazardTypeAcquiresShareLock,
+					Message: "This will lock out writes to the table while all of its rows are copied into a new table " +
+						"with the desired column ordering and the new table's indexes and constraints are built. " +
+						"The duration scales with the size of the table.",
+				},
+				{
+					Type: MigrationHazardTypeAcquiresAccessExclusiveLock,
+					Message: "This will briefly lock out all accesses to the table while the new table is swapped in. " +
+						"Upgrading the lock might deadlock with transactions that read and then write to the table, " +
+						"in which case the rewrite is rolled back.",
+				},
+				{
+					Type: MigrationHazardTypeImpactsDatabasePerformance,
+					Message: "Copying the table and building its indexes consumes a non-trivial amount of CPU and IO, " +
+						"and the database needs enough disk space to hold a second copy of the table.",
+				},
+				{
+					Type: MigrationHazardTypeHasUntrackableDependencies,
+					Message: "The old table is dropped and replaced by a copy. The copy is owned by the user running the " +
+						"migration and does not carry over the old table's grants, row level security setting or policies. " +
+						"Objects that depend on the old table, e.g., views, foreign keys or identity columns, cause the rewrite to fail.",
+				},
+			},
+		},
+	}, nil
+}
+
+// getTableRewriteTimeout derives the timeout of re-writing the table from its size. Tables missing from
+// tableSizesInBytesByName get the base timeout
+func getTableRewriteTimeout(tableSizesInBytesByName map[string]int64, tableName string) time.Duration {
+	const bytesPerGB = 1024 * 1024 * 1024
+	sizeInGB := (tableSizesInBytesByName[tableName] + bytesPerGB - 1) / bytesPerGB
+	return statementTimeoutTableRewriteBase + time.Duration(sizeInGB)*statementTimeoutTableRewritePerGB
+}
+
+func (*tableRewriteSQLVertexGenerator) GetSQLVertexId(table schema.Table) string {
+	return buildVertexId("tablerewrite", table.Name)
+}
+
+func (t *tableRewriteSQLVertexGenerator) GetAddAlterDependencies(table, _ schema.Table) []dependency {
+	// The rewrite should run after all other changes to the table, its indexes and its triggers, such that the new
+	// table matches the target schema
+	deps := []dependency{
+		mustRun(t.GetSQLVertexId(table), diffTypeAddAlter).after(buildTableVertexId(table.Name), diffTypeAddAlter),
+	}
+	for _, idx := range t.indexesInOldSchemaByTableName[table.Name] {
+		deps = append(deps, mustRun(t.GetSQLVertexId(table), diffTypeAddAlter).after(buildIndexVertexId(idx.Name), diffTypeDelete))
+	}
+	for _, idx := range t.indexesInNewSchemaByTableName[table.Name] {
+		deps = append(deps, mustRun(t.GetSQLVertexId(table), diffTypeAddAlter).after(buildIndexVertexId(idx.Name), diffTypeAddAlter))
+	}
+	for _, trigger := range t.triggersInOldSchemaByTableName[table.Name] {
+		deps = append(deps, mustRun(t.GetSQLVertexId(table), diffTypeAddAlter).after(buildTriggerVertexId(trigger), diffTypeDelete))
+	}
+	for _, trigger := range t.triggersInNewSchemaByTableName[table.Name] {
+		deps = append(deps, mustRun(t.GetSQLVertexId(table), diffTypeAddAlter).after(buildTriggerVertexId(trigger), diffTypeAddAlter))
+	}
+	return deps
+}
+
+func (*tableRewriteSQLVertexGenerator) GetDeleteDependencies(_ schema.Table) []dependency {
+	return nil
+}
+
+// canRewriteTableToReorderColumns returns true if the table can be rewritten to reorder its columns. Partitioned tables
+// and partitions can't be rewritten, since the columns of partitions must match their parent
+func canRewriteTableToReorderColumns(table schema.Table) bool {
+	return !table.IsPartitioned() && !table.IsPartition()
+}
+
+// requiresRewriteToReorderColumns returns true if the columns of the table would not be in the desired order after
+// the table is altered. Postgres only adds columns to the end of a table, so any other change in ordering
+// requires a rewrite
+func requiresRewriteToReorderColumns(diff tableDiff) bool {
+	if !canRewriteTableToReorderColumns(diff.new) {
+		return false
+	}
+
+	deletedColumnsByName := buildSchemaObjMap(diff.columnsDiff.deletes)
+	var columnNamesAfterAlter []string
+	for _, column := range diff.old.Columns {
+		if _, isDeleted := deletedColumnsByName[column.Name]; !isDeleted {
+			columnNamesAfterAlter = append(columnNamesAfterAlter, column.Name)
+		}
+	}
+	for _, column := range diff.columnsDiff.adds {
+		columnNamesAfterAlter = append(columnNamesAfterAlter, column.Name)
+	}
+
+	if len(columnNamesAfterAlter) != len(diff.new.Columns) {
+		return true
+	}
+	for i, column := range diff.new.Columns {
+		if columnNamesAfterAlter[i] != column.Name {
+			return true
+		}
+	}
+	return false
+}
+
 type functionSQLVertexGenerator struct {
 	// functionsInNewSchemaByName is a map of function new to functions in the new schema.
 	// These functions are not necessarily new
@@ -1357,6 +2274,10 @@ func (t *triggerSQLVertexGenerator) Alter(diff triggerDiff) ([]Statement, error)
 }
 
 func (t *triggerSQLVertexGenerator) GetSQLVertexId(trigger schema.Trigger) string {
+	return buildTriggerVertexId(trigger)
+}
+
+func buildTriggerVertexId(trigger schema.Trigger) string {
 	return buildVertexId("trigger", trigger.GetName())
 }
 
@@ -1388,10 +2309,33 @@ func (t *triggerSQLVertexGenerator) GetDeleteDependencies(trigger schema.Trigger
 	}
 }
 
+// buildSequenceVertexId builds the id of a sequence's vertex. Sequences are not diffed yet, so the dependencies on these
+// vertices only take effect once sequences are added to the SQL graph
+func buildSequenceVertexId(name schema.SchemaQualifiedName) string {
+	return buildVertexId("sequence", name.GetFQEscapedName())
+}
+
 func buildVertexId(objType string, id string) string {
 	return fmt.Sprintf("%s_%s", objType, id)
 }
 
+// generateNonConflictingName generates a name that will not conflict with existing names by appending a random suffix
+// to the base name. The base name is truncated if necessary to fit within the max Postgres identifier size
+func generateNonConflictingName(baseName string) (string, error) {
+	uuid, err := uuid.NewRandom()
+	if err != nil {
+		return "", fmt.Errorf("generating UUID: %w", err)
+	}
+
+	newNameSuffix := fmt.Sprintf("_%s", uuid.String())
+	baseNameTruncationIdx := len(baseName)
+	if len(baseName) > maxPostgresIdentifierSize-len(newNameSuffix) {
+		baseNameTruncationIdx = maxPostgresIdentifierSize - len(newNameSuffix)
+	}
+
+	return baseName[:baseNameTruncationIdx] + newNameSuffix, nil
+}
+
 func stripMigrationHazards(stmts []Statement) []Statement {
 	var noHazardsStmts []Statement
 	for _, stmt := range stmts {
@@ -1409,6 +2353,24 @@ func alterTablePrefix(tableName string) string {
 	return fmt.Sprintf("ALTER TABLE %s", schema.EscapeIdentifier(tableName))
 }
 
+func buildAnalyzeColumnStatement(tableName, columnName string) Statement {
+	return Statement{
+		DDL:         fmt.Sprintf("ANALYZE %s (%s)", schema.EscapeIdentifier(tableName), schema.EscapeIdentifier(columnName)),
+		Timeout:     statementTimeoutAnalyzeColumn,
+		LockTimeout: statementTimeoutAnalyzeColumn,
+		// Analyzing must not hold the locks acquired by altering the column
+		IsNonTransactional: true,
+		Hazards: []MigrationHazard{
+			{
+				Type: MigrationHazardTypeImpactsDatabasePerformance,
+				Message: "Running analyze will read rows from the table, putting increased load " +
+					"on the database and consuming database resources. It won't prevent reads/writes to " +
+					"the table, but it could affect performance when executing queries.",
+			},
+		},
+	}
+}
+
 func buildColumnDefinition(column schema.Column) string {
 	sb := strings.Builder{}
 	sb.WriteString(fmt.Sprintf("%s %s", schema.EscapeIdentifier(column.Name), column.Type))
@@ -1424,6 +2386,27 @@ func buildColumnDefinition(column schema.Column) string {
 	return sb.String()
 }
 
+// escapeStringLiteral escapes the string, such that it can be used as a SQL string literal
+func escapeStringLiteral(val string) string {
+	return fmt.Sprintf("'%s'", strings.ReplaceAll(val, "'", "''"))
+}
+
+func buildIndexesByTableName(indexes []schema.Index) map[string][]schema.Index {
+	indexesByTableName := make(map[string][]schema.Index)
+	for _, idx := range indexes {
+		indexesByTableName[idx.TableName] = append(indexesByTableName[idx.TableName], idx)
+	}
+	return indexesByTableName
+}
+
+func buildTriggersByTableName(triggers []schema.Trigger) map[string][]schema.Trigger {
+	triggersByTableName := make(map[string][]schema.Trigger)
+	for _, trigger := range triggers {
+		triggersByTableName[trigger.OwningTableUnescapedName] = append(triggersByTableName[trigger.OwningTableUnescapedName], trigger)
+	}
+	return triggersByTableName
+}
+
 func formattedNamesForSQL(names []string) []string {
 	var formattedNames []string
 	for _, name := range names {
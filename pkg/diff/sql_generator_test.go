@@ -0,0 +1,36 @@
+package diff
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stripe/pg-schema-diff/internal/schema"
+)
+
+func TestTableSQLVertexGeneratorSequenceDependencies(t *testing.T) {
+	idSeq := schema.SchemaQualifiedName{SchemaName: "public", EscapedName: "\"foobar_id_seq\""}
+	versionSeq := schema.SchemaQualifiedName{SchemaName: "public", EscapedName: "\"foobar_version_seq\""}
+	oldTable := schema.Table{
+		Name: "foobar",
+		Columns: []schema.Column{
+			{Name: "id", Type: "integer", Default: "nextval('foobar_id_seq'::regclass)", DependsOnSequences: []schema.SchemaQualifiedName{idSeq}},
+		},
+	}
+	newTable := schema.Table{
+		Name: "foobar",
+		Columns: []schema.Column{
+			{Name: "id", Type: "integer"},
+			{Name: "version", Type: "bigint", Default: "nextval('foobar_version_seq'::regclass)", DependsOnSequences: []schema.SchemaQualifiedName{versionSeq}},
+		},
+	}
+	generator := &tableSQLVertexGenerator{}
+
+	addAlterDeps := generator.GetAddAlterDependencies(newTable, oldTable)
+	assert.Contains(t, addAlterDeps, mustRun(buildTableVertexId("foobar"), diffTypeAddAlter).after(buildSequenceVertexId(versionSeq), diffTypeAddAlter))
+	assert.Contains(t, addAlterDeps, mustRun(buildTableVertexId("foobar"), diffTypeAddAlter).before(buildSequenceVertexId(idSeq), diffTypeDelete))
+
+	deleteDeps := generator.GetDeleteDependencies(oldTable)
+	assert.Equal(t, []dependency{
+		mustRun(buildTableVertexId("foobar"), diffTypeDelete).before(buildSequenceVertexId(idSeq), diffTypeDelete),
+	}, deleteDeps)
+}
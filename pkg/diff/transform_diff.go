@@ -3,6 +3,8 @@ package diff
 import (
 	"sort"
 
+	"github.com/google/go-cmp/cmp"
+
 	"github.com/stripe/pg-schema-diff/internal/schema"
 )
 
@@ -32,13 +34,73 @@ func dataPackNewTables(s schemaDiff) schemaDiff {
 func removeChangesToColumnOrdering(s schemaDiff) schemaDiff {
 	copiedTableDiffs := append([]tableDiff(nil), s.tableDiffs.alters...)
 	for i, _ := range copiedTableDiffs {
-		copiedColDiffs := append([]columnDiff(nil), copiedTableDiffs[i].columnsDiff.alters...)
-		for i, _ := range copiedColDiffs {
-			copiedColDiffs[i].oldOrdering = copiedColDiffs[i].newOrdering
-		}
-		copiedTableDiffs[i].columnsDiff.alters = copiedColDiffs
+		copiedTableDiffs[i] = copiedTableDiffs[i].withoutColumnOrderingChanges()
 	}
 	s.tableDiffs.alters = copiedTableDiffs
 
 	return s
 }
+
+// withoutColumnOrderingChanges returns a copy of the table diff without any changes to column ordering
+func (t tableDiff) withoutColumnOrderingChanges() tableDiff {
+	copiedColDiffs := append([]columnDiff(nil), t.columnsDiff.alters...)
+	for i, _ := range copiedColDiffs {
+		copiedColDiffs[i].oldOrdering = copiedColDiffs[i].newOrdering
+	}
+	t.columnsDiff.alters = copiedColDiffs
+	return t
+}
+
+// rebuildInvalidIndexesInPlace converts the re-creation of invalid indexes, e.g., those left behind by a failed
+// CREATE INDEX CONCURRENTLY, into alters, such that the indexes are rebuilt in place rather than dropped and re-created.
+// Indexes on partitioned tables and new tables are excluded: partitioned indexes are made valid by attaching
+// their partitions, and indexes on new tables are always re-created with their tables
+func rebuildInvalidIndexesInPlace(s schemaDiff) schemaDiff {
+	newTablesByName := buildSchemaObjMap(s.new.Tables)
+	addedTablesByName := buildSchemaObjMap(s.tableDiffs.adds)
+	addedIndexesByName := buildSchemaObjMap(s.indexDiffs.adds)
+
+	rebuiltIndexNames := make(map[string]bool)
+	var copiedDeletes []schema.Index
+	copiedAlters := append([]indexDiff(nil), s.indexDiffs.alters...)
+	for _, oldIndex := range s.indexDiffs.deletes {
+		newIndex, isRecreated := addedIndexesByName[oldIndex.Name]
+		if !isRecreated || !canRebuildInvalidIndexInPlace(newTablesByName, addedTablesByName, oldIndex, newIndex) {
+			copiedDeletes = append(copiedDeletes, oldIndex)
+			continue
+		}
+		rebuiltIndexNames[oldIndex.Name] = true
+		copiedAlters = append(copiedAlters, indexDiff{
+			oldAndNew: oldAndNew[schema.Index]{
+				old: oldIndex, new: newIndex,
+			},
+		})
+	}
+
+	var copiedAdds []schema.Index
+	for _, newIndex := range s.indexDiffs.adds {
+		if !rebuiltIndexNames[newIndex.Name] {
+			copiedAdds = append(copiedAdds, newIndex)
+		}
+	}
+
+	s.indexDiffs.deletes = copiedDeletes
+	s.indexDiffs.adds = copiedAdds
+	s.indexDiffs.alters = copiedAlters
+
+	return s
+}
+
+func canRebuildInvalidIndexInPlace(newTablesByName, addedTablesByName map[string]schema.Table, oldIndex, newIndex schema.Index) bool {
+	if !oldIndex.IsInvalid || newIndex.IsInvalid {
+		return false
+	}
+	if _, isOnNewTable := addedTablesByName[newIndex.TableName]; isOnNewTable {
+		return false
+	}
+	if table, ok := newTablesByName[newIndex.TableName]; !ok || table.IsPartitioned() {
+		return false
+	}
+	oldIndex.IsInvalid = newIndex.IsInvalid
+	return cmp.Equal(oldIndex, newIndex)
+}
@@ -352,3 +352,65 @@ func buildColumnDiff(col schema.Column, oldOrdering, newOrdering int) columnDiff
 		newOrdering: newOrdering,
 	}
 }
+
+func TestTransformDiffRebuildInvalidIndexesInPlace(t *testing.T) {
+	newSchema := schema.Schema{
+		Tables: []schema.Table{
+			{Name: "foobar"},
+			{Name: "partitioned", PartitionKeyDef: "PARTITION BY LIST(foo)"},
+		},
+	}
+	tcs := []transformDiffTestCase{
+		{
+			name: "No index diffs",
+			in: schemaDiff{
+				oldAndNew:  oldAndNew[schema.Schema]{new: newSchema},
+				indexDiffs: listDiff[schema.Index, indexDiff]{},
+			},
+			expectedOut: schemaDiff{
+				oldAndNew:  oldAndNew[schema.Schema]{new: newSchema},
+				indexDiffs: listDiff[schema.Index, indexDiff]{},
+			},
+		},
+		{
+			name: "Standard",
+			in: schemaDiff{
+				oldAndNew: oldAndNew[schema.Schema]{new: newSchema},
+				indexDiffs: listDiff[schema.Index, indexDiff]{
+					adds: []schema.Index{
+						{TableName: "foobar", Name: "invalid_idx", Columns: []string{"foo"}, GetIndexDefStmt: "CREATE INDEX invalid_idx ON public.foobar USING btree (foo)"},
+						{TableName: "foobar", Name: "changed_idx", Columns: []string{"foo", "bar"}, GetIndexDefStmt: "CREATE INDEX changed_idx ON public.foobar USING btree (foo, bar)"},
+						{TableName: "partitioned", Name: "partitioned_idx", Columns: []string{"foo"}, GetIndexDefStmt: "CREATE INDEX partitioned_idx ON ONLY public.partitioned USING btree (foo)"},
+					},
+					deletes: []schema.Index{
+						{TableName: "foobar", Name: "invalid_idx", Columns: []string{"foo"}, IsInvalid: true, GetIndexDefStmt: "CREATE INDEX invalid_idx ON public.foobar USING btree (foo)"},
+						{TableName: "foobar", Name: "changed_idx", Columns: []string{"foo"}, IsInvalid: true, GetIndexDefStmt: "CREATE INDEX changed_idx ON public.foobar USING btree (foo)"},
+						{TableName: "partitioned", Name: "partitioned_idx", Columns: []string{"bar"}, IsInvalid: true, GetIndexDefStmt: "CREATE INDEX partitioned_idx ON ONLY public.partitioned USING btree (bar)"},
+					},
+				},
+			},
+			expectedOut: schemaDiff{
+				oldAndNew: oldAndNew[schema.Schema]{new: newSchema},
+				indexDiffs: listDiff[schema.Index, indexDiff]{
+					adds: []schema.Index{
+						{TableName: "foobar", Name: "changed_idx", Columns: []string{"foo", "bar"}, GetIndexDefStmt: "CREATE INDEX changed_idx ON public.foobar USING btree (foo, bar)"},
+						{TableName: "partitioned", Name: "partitioned_idx", Columns: []string{"foo"}, GetIndexDefStmt: "CREATE INDEX partitioned_idx ON ONLY public.partitioned USING btree (foo)"},
+					},
+					deletes: []schema.Index{
+						{TableName: "foobar", Name: "changed_idx", Columns: []string{"foo"}, IsInvalid: true, GetIndexDefStmt: "CREATE INDEX changed_idx ON public.foobar USING btree (foo)"},
+						{TableName: "partitioned", Name: "partitioned_idx", Columns: []string{"bar"}, IsInvalid: true, GetIndexDefStmt: "CREATE INDEX partitioned_idx ON ONLY public.partitioned USING btree (bar)"},
+					},
+					alters: []indexDiff{
+						{
+							oldAndNew: oldAndNew[schema.Index]{
+								old: schema.Index{TableName: "foobar", Name: "invalid_idx", Columns: []string{"foo"}, IsInvalid: true, GetIndexDefStmt: "CREATE INDEX invalid_idx ON public.foobar USING btree (foo)"},
+								new: schema.Index{TableName: "foobar", Name: "invalid_idx", Columns: []string{"foo"}, GetIndexDefStmt: "CREATE INDEX invalid_idx ON public.foobar USING btree (foo)"},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	runTestCases(t, rebuildInvalidIndexesInPlace, tcs)
+}
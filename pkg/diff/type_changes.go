@@ -0,0 +1,178 @@
+package diff
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/stripe/pg-schema-diff/internal/schema"
+)
+
+// TypeConversionColumnPlaceholder is replaced with the escaped name of the column in the Using expression of a
+// TypeConversionRule
+const TypeConversionColumnPlaceholder = "{column}"
+
+type (
+	// TypeConversionRule configures how the values of a column are converted when its type changes from OldType to
+	// NewType, e.g., converting text to jsonb via to_jsonb. Without a rule, the values are cast to the new type.
+	// Use WithTypeConversionRules to register rules
+	TypeConversionRule struct {
+		// OldType and NewType are the types as formatted by Postgres' format_type, e.g., "character varying(255)" or
+		// "timestamp without time zone". They are matched case-insensitively
+		OldType string
+		NewType string
+		// TableName and ColumnName optionally restrict the rule to a single column. A rule for a specific column takes
+		// priority over a rule for all columns
+		TableName  string
+		ColumnName string
+		// Using is the expression of the USING clause, where TypeConversionColumnPlaceholder references the column,
+		// e.g., "to_jsonb({column})"
+		Using string
+		// HazardMessage describes the conversion to whoever reviews the plan. A generic message is used if it's empty
+		HazardMessage string
+	}
+
+	// binaryCoercibleCast is a cast in pg_cast between two types with the same binary representation, e.g., varchar to
+	// text. Values are not converted when a column's type is changed along such a cast
+	binaryCoercibleCast struct {
+		sourceType string
+		targetType string
+	}
+)
+
+var (
+	// defaultTypeConversionRules are the type conversion rules that apply unless they are overridden by the rules in
+	// the plan options
+	defaultTypeConversionRules = []TypeConversionRule{
+		{
+			OldType: "bigint",
+			NewType: "timestamp without time zone",
+			Using:   "to_timestamp({column} / 1000)",
+			HazardMessage: "This will completely lock the table while the data is being " +
+				"re-written for a duration of time that scales with the size of your data. " +
+				"The values previously stored as BIGINT will be translated into a " +
+				"TIMESTAMP value via the PostgreSQL to_timestamp() function. This " +
+				"translation will assume that the values stored in BIGINT represent a " +
+				"millisecond epoch value.",
+		},
+	}
+
+	// typeModifiersRegex splits a type formatted by format_type into: the portion of the type name before the type
+	// modifiers, the type modifiers and the portion of the type name after the type modifiers, e.g.,
+	// "timestamp(3) without time zone" is split into "timestamp", "3" and " without time zone"
+	typeModifiersRegex = regexp.MustCompile(`^([^()]*)\(([^()]*)\)([^()]*)$`)
+)
+
+// isTypeChangeWithoutRewrite returns true if Postgres can change the type of a column from oldType to newType without
+// re-writing the table, i.e., the new type is binary-coercible from the old type, and any type modifiers, e.g., the
+// maximum length of a varchar, are only widened. Postgres still acquires an ACCESS EXCLUSIVE lock on the table, but
+// only while it updates the catalog
+func isTypeChangeWithoutRewrite(oldType, newType string, binaryCoercibleCasts map[binaryCoercibleCast]bool) bool {
+	if strings.HasSuffix(oldType, "[]") || strings.HasSuffix(newType, "[]") {
+		// Every element of an array is coerced individually, which requires a re-write
+		return false
+	}
+
+	oldBaseType, oldTypeModifiers, ok := parseTypeModifiers(oldType)
+	if !ok {
+		return false
+	}
+	newBaseType, newTypeModifiers, ok := parseTypeModifiers(newType)
+	if !ok {
+		return false
+	}
+
+	if oldBaseType == newBaseType {
+		return isTypeModifierWidening(oldBaseType, oldTypeModifiers, newTypeModifiers)
+	}
+	// If the new type has type modifiers, a length coercion is applied after the cast, which requires a re-write
+	return len(newTypeModifiers) == 0 &&
+		binaryCoercibleCasts[binaryCoercibleCast{sourceType: oldBaseType, targetType: newBaseType}]
+}
+
+// parseTypeModifiers splits a type formatted by format_type into the type name without type modifiers, i.e., as
+// formatted by format_type(oid, NULL), and the type modifiers
+func parseTypeModifiers(typ string) (string, []int, bool) {
+	matches := typeModifiersRegex.FindStringSubmatch(typ)
+	if matches == nil {
+		return typ, nil, true
+	}
+
+	var typeModifiers []int
+	for _, rawTypeModifier := range strings.Split(matches[2], ",") {
+		typeModifier, err := strconv.Atoi(strings.TrimSpace(rawTypeModifier))
+		if err != nil {
+			return "", nil, false
+		}
+		typeModifiers = append(typeModifiers, typeModifier)
+	}
+	return matches[1] + matches[3], typeModifiers, true
+}
+
+// isTypeModifierWidening returns true if changing the type modifiers of the base type from oldTypeModifiers to
+// newTypeModifiers does not require a re-write. These mirror the support functions Postgres uses to skip the length
+// coercion, e.g., varchar_support and numeric_support. A type without type modifiers is unconstrained
+func isTypeModifierWidening(baseType string, oldTypeModifiers, newTypeModifiers []int) bool {
+	if len(newTypeModifiers) == 0 {
+		// Removing the type modifiers only avoids a re-write for types that support widening
+		return len(oldTypeModifiers) == 0 || supportsTypeModifierWidening(baseType)
+	} else if len(oldTypeModifiers) == 0 || !supportsTypeModifierWidening(baseType) {
+		return false
+	}
+
+	if baseType == "numeric" {
+		// The scale defaults to 0. The scale must not change, since the values would be re-scaled
+		oldPrecision, oldScale := oldTypeModifiers[0], 0
+		if len(oldTypeModifiers) > 1 {
+			oldScale = oldTypeModifiers[1]
+		}
+		newPrecision, newScale := newTypeModifiers[0], 0
+		if len(newTypeModifiers) > 1 {
+			newScale = newTypeModifiers[1]
+		}
+		return newScale == oldScale && newPrecision >= oldPrecision
+	}
+	return newTypeModifiers[0] >= oldTypeModifiers[0]
+}
+
+func supportsTypeModifierWidening(baseType string) bool {
+	switch baseType {
+	case "character varying",
+		"bit varying",
+		"numeric",
+		"time without time zone",
+		"timestamp without time zone",
+		"timestamp with time zone":
+		return true
+	default:
+		return false
+	}
+}
+
+// findTypeConversionRule finds the type conversion rule for changing the type of the column from oldColumn to
+// newColumn. Rules for the specific column take priority over rules for all columns. Otherwise, later rules take
+// priority over earlier rules, and the rules take priority over the defaults
+func findTypeConversionRule(rules []TypeConversionRule, tableName string, oldColumn, newColumn schema.Column) (TypeConversionRule, bool) {
+	var match TypeConversionRule
+	var found, foundForColumn bool
+	for _, rule := range append(append([]TypeConversionRule(nil), defaultTypeConversionRules...), rules...) {
+		if !strings.EqualFold(rule.OldType, oldColumn.Type) || !strings.EqualFold(rule.NewType, newColumn.Type) {
+			continue
+		}
+		isForColumn := len(rule.TableName) > 0 || len(rule.ColumnName) > 0
+		if isForColumn && (rule.TableName != tableName || rule.ColumnName != newColumn.Name) {
+			continue
+		}
+		if isForColumn || !foundForColumn {
+			match = rule
+			found = true
+			foundForColumn = foundForColumn || isForColumn
+		}
+	}
+	return match, found
+}
+
+// buildUsingExpression builds the expression of the USING clause for the column
+func (r TypeConversionRule) buildUsingExpression(columnName string) string {
+	return strings.ReplaceAll(r.Using, TypeConversionColumnPlaceholder, schema.EscapeIdentifier(columnName))
+}
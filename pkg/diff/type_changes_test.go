@@ -0,0 +1,124 @@
+package diff
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stripe/pg-schema-diff/internal/schema"
+)
+
+func TestIsTypeChangeWithoutRewrite(t *testing.T) {
+	binaryCoercibleCasts := map[binaryCoercibleCast]bool{
+		{sourceType: "character varying", targetType: "text"}: true,
+		{sourceType: "text", targetType: "character varying"}: true,
+		{sourceType: "cidr", targetType: "inet"}:              true,
+	}
+
+	for _, tc := range []struct {
+		oldType         string
+		newType         string
+		expectNoRewrite bool
+	}{
+		{oldType: "character varying(50)", newType: "character varying(255)", expectNoRewrite: true},
+		{oldType: "character varying(50)", newType: "character varying", expectNoRewrite: true},
+		{oldType: "character varying(255)", newType: "character varying(50)", expectNoRewrite: false},
+		{oldType: "character varying", newType: "character varying(50)", expectNoRewrite: false},
+		{oldType: "character varying(50)", newType: "text", expectNoRewrite: true},
+		{oldType: "text", newType: "character varying", expectNoRewrite: true},
+		{oldType: "text", newType: "character varying(50)", expectNoRewrite: false},
+		{oldType: "cidr", newType: "inet", expectNoRewrite: true},
+		{oldType: "inet", newType: "cidr", expectNoRewrite: false},
+		{oldType: "numeric(10,2)", newType: "numeric(12,2)", expectNoRewrite: true},
+		{oldType: "numeric(10,2)", newType: "numeric", expectNoRewrite: true},
+		{oldType: "numeric(10,2)", newType: "numeric(12,3)", expectNoRewrite: false},
+		{oldType: "numeric(12,2)", newType: "numeric(10,2)", expectNoRewrite: false},
+		{oldType: "numeric(10)", newType: "numeric(12,0)", expectNoRewrite: true},
+		{oldType: "timestamp(3) without time zone", newType: "timestamp(6) without time zone", expectNoRewrite: true},
+		{oldType: "timestamp(6) without time zone", newType: "timestamp(3) without time zone", expectNoRewrite: false},
+		{oldType: "timestamp without time zone", newType: "timestamp with time zone", expectNoRewrite: false},
+		{oldType: "character(5)", newType: "character(10)", expectNoRewrite: false},
+		{oldType: "character varying(50)[]", newType: "character varying(255)[]", expectNoRewrite: false},
+		{oldType: "integer", newType: "bigint", expectNoRewrite: false},
+	} {
+		t.Run(tc.oldType+" to "+tc.newType, func(t *testing.T) {
+			assert.Equal(t, tc.expectNoRewrite, isTypeChangeWithoutRewrite(tc.oldType, tc.newType, binaryCoercibleCasts))
+		})
+	}
+}
+
+func TestFindTypeConversionRule(t *testing.T) {
+	toJsonb := TypeConversionRule{OldType: "text", NewType: "jsonb", Using: "to_jsonb({column})"}
+	toJsonbObject := TypeConversionRule{OldType: "text", NewType: "jsonb", Using: "jsonb_build_object('value', {column})"}
+	toJsonbForColumn := TypeConversionRule{
+		OldType: "TEXT", NewType: "jsonb", TableName: "foobar", ColumnName: "foo", Using: "{column}::jsonb",
+	}
+
+	for _, tc := range []struct {
+		name         string
+		rules        []TypeConversionRule
+		tableName    string
+		oldColumn    schema.Column
+		newColumn    schema.Column
+		expectedRule TypeConversionRule
+		expectFound  bool
+	}{
+		{
+			name:        "No matching rule",
+			rules:       []TypeConversionRule{toJsonb},
+			tableName:   "foobar",
+			oldColumn:   schema.Column{Name: "foo", Type: "integer"},
+			newColumn:   schema.Column{Name: "foo", Type: "jsonb"},
+			expectFound: false,
+		},
+		{
+			name:         "Default rule",
+			tableName:    "foobar",
+			oldColumn:    schema.Column{Name: "foo", Type: "bigint"},
+			newColumn:    schema.Column{Name: "foo", Type: "timestamp without time zone"},
+			expectedRule: defaultTypeConversionRules[0],
+			expectFound:  true,
+		},
+		{
+			name:         "Rule overrides default rule",
+			rules:        []TypeConversionRule{{OldType: "bigint", NewType: "timestamp without time zone", Using: "to_timestamp({column})"}},
+			tableName:    "foobar",
+			oldColumn:    schema.Column{Name: "foo", Type: "bigint"},
+			newColumn:    schema.Column{Name: "foo", Type: "timestamp without time zone"},
+			expectedRule: TypeConversionRule{OldType: "bigint", NewType: "timestamp without time zone", Using: "to_timestamp({column})"},
+			expectFound:  true,
+		},
+		{
+			name:         "Later rule takes priority",
+			rules:        []TypeConversionRule{toJsonb, toJsonbObject},
+			tableName:    "foobar",
+			oldColumn:    schema.Column{Name: "foo", Type: "text"},
+			newColumn:    schema.Column{Name: "foo", Type: "jsonb"},
+			expectedRule: toJsonbObject,
+			expectFound:  true,
+		},
+		{
+			name:         "Rule for column takes priority",
+			rules:        []TypeConversionRule{toJsonbForColumn, toJsonb},
+			tableName:    "foobar",
+			oldColumn:    schema.Column{Name: "foo", Type: "text"},
+			newColumn:    schema.Column{Name: "foo", Type: "jsonb"},
+			expectedRule: toJsonbForColumn,
+			expectFound:  true,
+		},
+		{
+			name:         "Rule for other column is ignored",
+			rules:        []TypeConversionRule{toJsonb, toJsonbForColumn},
+			tableName:    "foobar",
+			oldColumn:    schema.Column{Name: "bar", Type: "text"},
+			newColumn:    schema.Column{Name: "bar", Type: "jsonb"},
+			expectedRule: toJsonb,
+			expectFound:  true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rule, found := findTypeConversionRule(tc.rules, tc.tableName, tc.oldColumn, tc.newColumn)
+			assert.Equal(t, tc.expectFound, found)
+			assert.Equal(t, tc.expectedRule, rule)
+		})
+	}
+}